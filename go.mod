@@ -6,6 +6,5 @@ require (
 	github.com/google/gopacket v1.1.19
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/net v0.29.0
+	golang.org/x/sys v0.25.0
 )
-
-require golang.org/x/sys v0.25.0 // indirect
@@ -0,0 +1,390 @@
+package analysis
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// AQM classes, as of the aqm/ test beds
+const (
+	AqmFqCodel  = "fq_codel"
+	AqmCake     = "cake"
+	AqmCodel    = "codel"
+	AqmPie      = "pie"
+	AqmSfq      = "sfq"
+	AqmTailDrop = "tail-drop"
+)
+
+const (
+	minRetransmits = 5    // retransmissions from which loss burstiness is considered
+	burstGap       = 0.01 // retransmissions within which (s) loss is bursty
+	minBulkQueue   = 2.0  // bulk flow queuing delay (ms) from which the link is deemed saturated
+)
+
+// aqmProfile: expected signature of a queue discipline
+//
+// queuing delays are log-normal about their centers (ms), with sigma in natural log units.
+type aqmProfile struct {
+	class     string
+	fq        bool    // flow queuing: sparse probes bypass the bulk flow's queue
+	queue     float64 // center of the bulk flow's queuing delay (ms)
+	sigma     float64
+	sawtooth  float64 // expected collapses per second
+	plateau   float64 // expected fraction of delays near the median
+	marks     bool    // marks ecn-capable packets rather than dropping
+	burstLoss float64 // expected fraction of retransmissions in bursts
+}
+
+// isolatedProbeQueue: center of the queuing delay of probes isolated from the bulk flow
+const isolatedProbeQueue = 0.5
+
+// fq_codel and cake both isolate sparse flows; they differ in the bulk flow's queue. cake
+// shapes to the link rate itself and holds that queue tight at its target, whereas fq_codel
+// behind a separate shaper (htb, tbf) also holds the shaper's bursts, higher and more spread.
+// the centers are priors, to be calibrated by evaluate on the labelled aqm/ sweeps.
+var aqmProfiles = []aqmProfile{
+	{class: AqmFqCodel, fq: true, queue: 7, sigma: 0.7, plateau: 0.45, marks: true, burstLoss: 0.2},
+	{class: AqmCake, fq: true, queue: 4, sigma: 0.45, plateau: 0.7, marks: true, burstLoss: 0.2},
+	{class: AqmCodel, queue: 6, sigma: 0.7, plateau: 0.5, marks: true, burstLoss: 0.2},
+	{class: AqmPie, queue: 18, sigma: 0.5, sawtooth: 0.05, plateau: 0.6, marks: true, burstLoss: 0.2},
+	{class: AqmSfq, fq: true, queue: 60, sigma: 1, sawtooth: 0.5, plateau: 0.3, burstLoss: 0.7},
+	{class: AqmTailDrop, queue: 80, sigma: 1.2, sawtooth: 0.5, plateau: 0.4, burstLoss: 0.7},
+}
+
+// AqmFeatures: delay and loss signature of the bottleneck under load
+type AqmFeatures struct {
+	ProbeQueueMedian float64  `json:"probe_queue_delay_median_ms" desc:"median queuing delay of probes under load (ms)"`
+	ProbeQueueP95    float64  `json:"probe_queue_delay_p95_ms" desc:"95th percentile queuing delay of probes under load (ms)"`
+	FlowQueueMedian  *float64 `json:"flow_queue_delay_median_ms,omitempty" desc:"median queuing delay of the sparse latency flow under load (ms) [absent: no latency flow]"`
+	BulkQueueMedian  *float64 `json:"bulk_queue_delay_median_ms,omitempty" desc:"median queuing delay of the speedtest flow under load (ms) [absent: no capture timestamps]"`
+	Isolation        *float64 `json:"isolation,omitempty" desc:"sparse (latency flow, else probe) to bulk flow queuing delay ratio [~0: flow isolation, ~1: shared queue]"`
+	Sawtooth         float64  `json:"sawtooth" desc:"queuing delay collapses per second under load (build-up and drop cycles)"`
+	Plateau          float64  `json:"plateau" desc:"fraction of queuing delay samples within 25% of the median"`
+	DataSegments     int      `json:"data_segments" desc:"speedtest data segments under load"`
+	Retransmissions  int      `json:"retransmissions" desc:"speedtest retransmissions under load"`
+	ECT              int      `json:"ect_segments" desc:"ecn-capable speedtest data segments under load"`
+	CE               int      `json:"ce_segments" desc:"congestion experienced marked speedtest data segments under load"`
+	LossBurstiness   *float64 `json:"loss_burstiness,omitempty" desc:"fraction of retransmissions within 10 ms of another"`
+}
+
+// AqmFingerprint: likely queue discipline of the bottleneck
+type AqmFingerprint struct {
+	Hop        int                `json:"bottleneck_hop" desc:"hop of the largest queuing delay increase under load [0: undetermined]"`
+	AQM        string             `json:"aqm,omitempty" desc:"most likely of fq_codel, cake, codel, pie, sfq and tail-drop [absent: undetermined]"`
+	Confidence float64            `json:"confidence" desc:"probability of the most likely aqm (0 to 1)"`
+	Scores     map[string]float64 `json:"scores,omitempty" desc:"probability per aqm"`
+	Note       string             `json:"note,omitempty" desc:"reason where undetermined or ambiguous"`
+	Features   AqmFeatures        `json:"features"`
+}
+
+// FingerprintAqm: classify the bottleneck's queue discipline from probes, their latency
+// report and (optionally) probes of the sparse latency flow to the server and capture
+// observations of the speedtest flow
+//
+// classes are scored by the likelihood of the observed features under their expected
+// signature: queuing delay level of sparse flows and bulk flow (flow isolation), sawtooth
+// and plateau shape, ecn marking and loss burstiness; fq_codel and cake differ in the bulk
+// flow's queue alone.
+func FingerprintAqm(probes, flow []Probe, report *Bufferbloat, capture *CaptureStats, slowPath map[int]bool) *AqmFingerprint {
+	if report == nil {
+		return nil
+	}
+
+	fingerprint := &AqmFingerprint{Hop: bottleneckHop(report, slowPath)}
+
+	hop := fingerprint.Hop
+	if hop == 0 && report.EndToEnd != nil {
+		hop = report.EndToEnd.Hop
+	}
+
+	queue := probeQueue(probes, hop, report.ReachedTarget && hop == report.EndToEnd.Hop)
+	if len(queue) == 0 {
+		fingerprint.Note = "no probe replies under load"
+		return fingerprint
+	}
+
+	f := &fingerprint.Features
+	values := sampleValues(queue)
+	f.ProbeQueueMedian = Quantile(values, 0.5)
+	f.ProbeQueueP95 = Quantile(values, 0.95)
+
+	// sparse flow sharing the bulk flow's path end to end, as a probe of flow isolation
+	sparse := f.ProbeQueueMedian
+	if flowQueue := probeQueue(flow, 0, true); len(flowQueue) > 0 {
+		median := Quantile(sampleValues(flowQueue), 0.5)
+		f.FlowQueueMedian = &median
+		sparse = median
+	}
+
+	// shape from the bulk flow's denser rtt samples where captured
+	shape := queue
+	if capture != nil {
+		f.DataSegments, f.Retransmissions = capture.DataSegments, capture.Retransmissions
+		f.ECT, f.CE = capture.ECT, capture.CE
+
+		if len(capture.RTT) > 0 {
+			bulk := queueDelays(capture.RTT, targetBase(probes, report))
+			median := Quantile(sampleValues(bulk), 0.5)
+			f.BulkQueueMedian = &median
+			if median >= minBulkQueue {
+				isolation := sparse / median
+				f.Isolation = &isolation
+			}
+			shape = bulk
+		}
+
+		if len(capture.RetransmitTimes) >= minRetransmits {
+			burstiness := lossBurstiness(capture.RetransmitTimes)
+			f.LossBurstiness = &burstiness
+		}
+	}
+	f.Sawtooth = sawtooth(shape)
+	f.Plateau = plateau(sampleValues(shape))
+
+	if f.BulkQueueMedian == nil && f.ProbeQueueP95 < minBulkQueue {
+		// flow isolation and an unsaturated link are indistinguishable by probes alone
+		fingerprint.Note = "no queuing delay under load and no capture evidence of saturation"
+		return fingerprint
+	}
+	if f.BulkQueueMedian != nil && *f.BulkQueueMedian < minBulkQueue && f.ProbeQueueP95 < minBulkQueue {
+		fingerprint.Note = "link not saturated: no queuing delay under load"
+		return fingerprint
+	}
+
+	fingerprint.Scores = scoreAqm(f)
+
+	best := slices.MaxFunc(aqmProfiles, func(a, b aqmProfile) int {
+		return cmp.Compare(fingerprint.Scores[a.class], fingerprint.Scores[b.class])
+	})
+	fingerprint.AQM = best.class
+	fingerprint.Confidence = fingerprint.Scores[best.class]
+
+	if (best.class == AqmFqCodel || best.class == AqmCake) && f.BulkQueueMedian == nil {
+		fingerprint.Note = "fq_codel and cake differ in the bulk flow's queuing delay, not captured"
+	}
+
+	return fingerprint
+}
+
+// scoreAqm: probability per class, of uniform prior
+func scoreAqm(f *AqmFeatures) map[string]float64 {
+	likelihoods := make(map[string]float64, len(aqmProfiles))
+	var total float64
+
+	for _, p := range aqmProfiles {
+		probeCenter := p.queue
+		if p.fq {
+			probeCenter = isolatedProbeQueue
+		}
+
+		l := logNormal(f.ProbeQueueMedian, probeCenter, p.sigma)
+		if f.FlowQueueMedian != nil {
+			l *= logNormal(*f.FlowQueueMedian, probeCenter, p.sigma)
+		}
+		if f.BulkQueueMedian != nil {
+			l *= logNormal(*f.BulkQueueMedian, p.queue, p.sigma)
+		}
+
+		l *= normal(min(f.Sawtooth, 1), p.sawtooth, 0.25)
+		l *= normal(f.Plateau, p.plateau, 0.35)
+
+		if f.ECT > 0 {
+			switch {
+			case f.CE > 0 && !p.marks:
+				// only aqm marks
+				l *= 0.02
+			case f.CE == 0 && f.Retransmissions > 0 && p.marks:
+				// aqm would have marked rather than dropped
+				l *= 0.3
+			}
+		}
+
+		if f.LossBurstiness != nil {
+			l *= normal(*f.LossBurstiness, p.burstLoss, 0.25)
+		}
+
+		likelihoods[p.class] = l
+		total += l
+	}
+
+	scores := make(map[string]float64, len(likelihoods))
+	for class, l := range likelihoods {
+		if total > 0 {
+			scores[class] = math.Round(l/total*1e3) / 1e3
+		}
+	}
+
+	return scores
+}
+
+// bottleneckHop: hop of the largest increase in loaded median rtt over the previous hop,
+// but for hops of slowPath [0: no increase]
+func bottleneckHop(report *Bufferbloat, slowPath map[int]bool) int {
+	var (
+		hop      int
+		previous float64
+		largest  = 1.0 // ms, below which no queue is deemed to build
+	)
+
+	for _, h := range report.Hops {
+		if slowPath[h.Hop] {
+			continue
+		}
+
+		increase := maxIncrease(h)
+		if step := increase - previous; step > largest {
+			hop, largest = h.Hop, step
+		}
+		previous = max(previous, increase)
+	}
+
+	return hop
+}
+
+func maxIncrease(h HopLatency) float64 {
+	var increase float64
+	for _, value := range h.Increase {
+		increase = max(increase, value)
+	}
+	return increase
+}
+
+// probeQueue: queuing delay of loaded probes to hop (or the target) over the idle minimum
+func probeQueue(probes []Probe, hop int, target bool) []Sample {
+	var idle, loaded []Sample
+
+	for _, probe := range probes {
+		if probe.Lost || target && !probe.Target || !target && probe.Hop != hop {
+			continue
+		}
+
+		sample := Sample{Time: probe.Time, Value: probe.RTT}
+		if probe.Load == "" {
+			idle = append(idle, sample)
+		} else {
+			loaded = append(loaded, sample)
+		}
+	}
+
+	base := math.Inf(1)
+	for _, sample := range append(idle, loaded...) {
+		base = min(base, sample.Value)
+	}
+
+	for i := range loaded {
+		loaded[i].Value -= base
+	}
+	slices.SortFunc(loaded, func(a, b Sample) int { return cmp.Compare(a.Time, b.Time) })
+
+	return loaded
+}
+
+// targetBase: minimum rtt of probes replied by the ping target, the speedtest server
+// [+Inf: target not reached]
+func targetBase(probes []Probe, report *Bufferbloat) float64 {
+	base := math.Inf(1)
+	if !report.ReachedTarget {
+		return base
+	}
+	for _, probe := range probes {
+		if probe.Target && !probe.Lost {
+			base = min(base, probe.RTT)
+		}
+	}
+	return base
+}
+
+// queueDelays: rtt samples over the path's base rtt, else their minimum (as queues
+// standing throughout the load are then not observed)
+func queueDelays(rtt []Sample, base float64) []Sample {
+	base = min(base, slices.MinFunc(rtt, func(a, b Sample) int { return cmp.Compare(a.Value, b.Value) }).Value)
+
+	delays := make([]Sample, len(rtt))
+	for i, sample := range rtt {
+		delays[i] = Sample{Time: sample.Time, Value: sample.Value - base}
+	}
+	slices.SortFunc(delays, func(a, b Sample) int { return cmp.Compare(a.Time, b.Time) })
+
+	return delays
+}
+
+// sawtooth: collapses of queuing delay per second, a collapse being a drop from a peak
+// of at least half the 95th percentile (and 15 ms) to below half the peak
+func sawtooth(series []Sample) float64 {
+	if len(series) < 2 {
+		return 0
+	}
+
+	p95 := Quantile(sampleValues(series), 0.95)
+	threshold := max(p95/2, 15)
+
+	var (
+		collapses int
+		peak      float64
+	)
+	for _, sample := range series {
+		peak = max(peak, sample.Value)
+		if peak >= threshold && sample.Value < peak/2 {
+			collapses++
+			peak = sample.Value
+		}
+	}
+
+	duration := series[len(series)-1].Time - series[0].Time
+	if duration <= 0 {
+		return 0
+	}
+
+	return math.Round(float64(collapses)/duration*1e3) / 1e3
+}
+
+// plateau: fraction of sorted values within 25% of their median
+func plateau(sorted []float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	median := Quantile(sorted, 0.5)
+
+	var near int
+	for _, value := range sorted {
+		if math.Abs(value-median) <= 0.25*median {
+			near++
+		}
+	}
+
+	return math.Round(float64(near)/float64(len(sorted))*1e3) / 1e3
+}
+
+// lossBurstiness: fraction of (sorted) retransmission times within burstGap of another
+func lossBurstiness(times []float64) float64 {
+	var bursty int
+	for i, t := range times {
+		if i > 0 && t-times[i-1] <= burstGap || i < len(times)-1 && times[i+1]-t <= burstGap {
+			bursty++
+		}
+	}
+	return math.Round(float64(bursty)/float64(len(times))*1e3) / 1e3
+}
+
+// sampleValues: sorted values of samples
+func sampleValues(samples []Sample) []float64 {
+	values := make([]float64, len(samples))
+	for i, sample := range samples {
+		values[i] = sample.Value
+	}
+	slices.Sort(values)
+	return values
+}
+
+// logNormal: unnormalized log-normal likelihood of x (floored at 0.1 ms) about center
+func logNormal(x, center, sigma float64) float64 {
+	return normal(math.Log(max(x, 0.1)), math.Log(center), sigma)
+}
+
+// normal: unnormalized normal likelihood of x about center
+func normal(x, center, sigma float64) float64 {
+	z := (x - center) / sigma
+	return math.Exp(-z * z / 2)
+}
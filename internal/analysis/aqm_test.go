@@ -0,0 +1,112 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+)
+
+// profileRun: probes, report and capture of a run under load whose features are those
+// expected of profile p, over 10 s of load at a base rtt of 10 ms
+func profileRun(p aqmProfile) ([]Probe, *Bufferbloat, *CaptureStats) {
+	const start, base = 1700000000.0, 10.0
+
+	probeCenter := p.queue
+	if p.fq {
+		probeCenter = isolatedProbeQueue
+	}
+
+	var probes []Probe
+	for round := range 200 {
+		probe := Probe{Hop: 1, Round: round, Time: start - 10 + float64(round)*0.1, RTT: base, Target: true}
+		if round >= 100 {
+			probe.Load = "download"
+			probe.RTT += probeCenter
+		}
+		probes = append(probes, probe)
+	}
+	report := &Bufferbloat{
+		Hops:          []HopLatency{{Hop: 1, Increase: map[string]float64{"download": probeCenter}}},
+		EndToEnd:      &HopLatency{Hop: 1},
+		ReachedTarget: true,
+	}
+
+	// bulk flow delays at the queue center, but for a share off the plateau (symmetrically,
+	// to keep the median) and collapses at the sawtooth rate
+	capture := &CaptureStats{DataSegments: 1000, ECT: 1000}
+	collapses := int(math.Round(p.sawtooth * 10))
+	for i := range 1000 {
+		queue := p.queue
+		switch {
+		case collapses > 0 && i%(1000/collapses) == 1000/collapses/2:
+			queue *= 0.1
+		case float64(i*7%100) >= p.plateau*100:
+			queue *= []float64{0.7, 1.3}[i%2]
+		}
+		capture.RTT = append(capture.RTT, Sample{Time: start + float64(i)*0.01, Value: base + queue})
+	}
+
+	// retransmissions, bursty in pairs 5 ms apart for the profile's share
+	const retransmits = 20
+	bursty := int(math.Round(p.burstLoss*retransmits/2)) * 2
+	for i := range retransmits {
+		t := start + float64(i)
+		if i < bursty {
+			t = start + float64(i/2) + float64(i%2)*0.005
+		}
+		capture.RetransmitTimes = append(capture.RetransmitTimes, t)
+	}
+	capture.Retransmissions = retransmits
+	if p.marks {
+		capture.CE = 50
+	}
+
+	return probes, report, capture
+}
+
+func TestFingerprintAqm(t *testing.T) {
+	for _, p := range aqmProfiles {
+		probes, report, capture := profileRun(p)
+
+		fingerprint := FingerprintAqm(probes, nil, report, capture, nil)
+		if fingerprint == nil || fingerprint.AQM != p.class {
+			t.Errorf("%s: got %+v", p.class, fingerprint)
+			continue
+		}
+		if fingerprint.Hop != 0 && fingerprint.Hop != 1 {
+			t.Errorf("%s: bottleneck hop: got %d", p.class, fingerprint.Hop)
+		}
+		if fingerprint.Note != "" {
+			t.Errorf("%s: note: got %q", p.class, fingerprint.Note)
+		}
+
+		// the features drawn are those of the profile
+		f := fingerprint.Features
+		if f.BulkQueueMedian == nil || *f.BulkQueueMedian != p.queue {
+			t.Errorf("%s: bulk queue: got %v, want %v", p.class, f.BulkQueueMedian, p.queue)
+		}
+		if math.Abs(f.Plateau-p.plateau) > 0.05 || math.Abs(f.Sawtooth-p.sawtooth) > 0.05 {
+			t.Errorf("%s: plateau %v, sawtooth %v: want %v, %v", p.class, f.Plateau, f.Sawtooth, p.plateau, p.sawtooth)
+		}
+	}
+}
+
+func TestFingerprintAqmUndetermined(t *testing.T) {
+	if fingerprint := FingerprintAqm(nil, nil, nil, nil, nil); fingerprint != nil {
+		t.Errorf("no report: got %+v", fingerprint)
+	}
+
+	// flow isolation, or no queue at all, without capture
+	probes, report, _ := profileRun(aqmProfiles[0])
+	if fingerprint := FingerprintAqm(probes, nil, report, nil, nil); fingerprint == nil || fingerprint.AQM != "" || fingerprint.Note == "" {
+		t.Errorf("probes alone: got %+v", fingerprint)
+	}
+
+	// no queue under load
+	for i := range probes {
+		probes[i].RTT = 10
+	}
+	_, _, capture := profileRun(aqmProfile{queue: 0.5, plateau: 1})
+	if fingerprint := FingerprintAqm(probes, nil, report, capture, nil); fingerprint == nil || fingerprint.AQM != "" || fingerprint.Note == "" {
+		t.Errorf("unsaturated: got %+v", fingerprint)
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ * analysis: derived measures of latency under load
+ *
+ * probes are summarized per hop while idle and under load, and end-to-end, into a
+ * bufferbloat grade (on the median latency increase under load, by the thresholds of
+ * common public tests) and a responsiveness in round-trips per minute (rpm)
+ *
+ */
+package analysis
+
+import (
+	"math"
+	"slices"
+)
+
+// Probe: ping probe as input to analyses
+type Probe struct {
+	Hop    int
+	Round  int
+	Time   float64 // send time (unix seconds)
+	Load   string  // load phase in which the probe was sent [empty: idle]
+	RTT    float64 // ms
+	Lost   bool
+	Target bool // replied by the ping target (end to end)
+
+	ReplyTTL  int  // remaining ttl (hop limit) of the reply [0: unrecorded]
+	Quoted    bool // whether the reply quotes the probe header (time exceeded)
+	QuotedTTL int  // ttl (hop limit) of the quoted probe
+	QuotedTOS int  // tos (traffic class) of the quoted probe
+
+	Mpls       []MplsLabel    // label stack of the reply's icmp extensions
+	Interfaces []HopInterface // interfaces of the reply's icmp extensions
+}
+
+// LatencyStats: rtt distribution of probes, in ms
+type LatencyStats struct {
+	Probes int     `json:"probes" desc:"probes sent"`
+	Lost   int     `json:"lost" desc:"probes without reply"`
+	Median float64 `json:"median_ms" desc:"median rtt (ms)"`
+	P95    float64 `json:"p95_ms" desc:"95th percentile rtt (ms)"`
+	P99    float64 `json:"p99_ms" desc:"99th percentile rtt (ms)"`
+}
+
+// HopLatency: latency to a hop while idle and under load
+type HopLatency struct {
+	Hop      int                `json:"hop" desc:"probe ttl"`
+	Idle     *LatencyStats      `json:"idle,omitempty" desc:"probes outside load phases"`
+	Loaded   *LatencyStats      `json:"loaded,omitempty" desc:"probes during any load phase"`
+	Increase map[string]float64 `json:"median_increase_ms,omitempty" desc:"median rtt increase over idle per load phase (ms)"`
+}
+
+// Bufferbloat: latency under load report
+type Bufferbloat struct {
+	Hops           []HopLatency `json:"hops" desc:"latency per hop"`
+	EndToEnd       *HopLatency  `json:"end_to_end,omitempty" desc:"latency to the ping target, else to the farthest responding hop"`
+	ReachedTarget  bool         `json:"reached_target" desc:"whether end-to-end probes were replied by the ping target"`
+	Grade          string       `json:"grade,omitempty" desc:"bufferbloat grade A+ to F on the largest end-to-end median increase"`
+	RPM            float64      `json:"rpm,omitempty" desc:"responsiveness under load (round-trips per minute)"`
+	Responsiveness string       `json:"responsiveness,omitempty" desc:"low (< 300 rpm), medium (< 1000 rpm) or high"`
+}
+
+// grades: upper bounds (ms) of median latency increase under load per grade
+var grades = []struct {
+	grade string
+	bound float64
+}{
+	{"A+", 5}, {"A", 30}, {"B", 60}, {"C", 200}, {"D", 400},
+}
+
+// Grade: bufferbloat grade of a latency increase (ms)
+func Grade(increase float64) string {
+	for _, g := range grades {
+		if increase < g.bound {
+			return g.grade
+		}
+	}
+	return "F"
+}
+
+// AnalyzeBufferbloat: latency under load report of probes
+//
+// returns nil where no probe was replied.
+func AnalyzeBufferbloat(probes []Probe) *Bufferbloat {
+	byHop := make(map[int][]Probe)
+	targetHops := make(map[int]bool)
+
+	for _, probe := range probes {
+		byHop[probe.Hop] = append(byHop[probe.Hop], probe)
+		if probe.Target && !probe.Lost {
+			targetHops[probe.Hop] = true
+		}
+	}
+
+	report := &Bufferbloat{}
+	for _, hop := range sortedKeys(byHop) {
+		latency := hopLatency(hop, byHop[hop])
+		if latency.Idle != nil && latency.Idle.Probes > latency.Idle.Lost ||
+			latency.Loaded != nil && latency.Loaded.Probes > latency.Loaded.Lost {
+			report.Hops = append(report.Hops, latency)
+		}
+	}
+	if len(report.Hops) == 0 {
+		return nil
+	}
+
+	// end to end: probes of hops reaching the target, else of the farthest responding hop
+	var endToEnd []Probe
+	if len(targetHops) > 0 {
+		for hop := range targetHops {
+			for _, probe := range byHop[hop] {
+				// excluding replies of intermediate routers (e.g. on route change)
+				if probe.Target || probe.Lost {
+					endToEnd = append(endToEnd, probe)
+				}
+			}
+		}
+		report.ReachedTarget = true
+	} else {
+		last := report.Hops[len(report.Hops)-1].Hop
+		endToEnd = byHop[last]
+	}
+
+	latency := hopLatency(endToEnd[0].Hop, endToEnd)
+	if report.ReachedTarget {
+		latency.Hop = slices.Min(sortedKeys(targetHops))
+	}
+	report.EndToEnd = &latency
+
+	if len(latency.Increase) > 0 {
+		worst := math.Inf(-1)
+		for _, increase := range latency.Increase {
+			worst = max(worst, increase)
+		}
+		report.Grade = Grade(worst)
+	}
+
+	var loaded []float64
+	for _, probe := range endToEnd {
+		if probe.Load != "" && !probe.Lost {
+			loaded = append(loaded, probe.RTT)
+		}
+	}
+	if rpm := responsiveness(loaded); rpm > 0 {
+		report.RPM = rpm
+		report.Responsiveness = rpmClass(rpm)
+	}
+
+	return report
+}
+
+// hopLatency: idle and loaded latency of a hop's probes, and increase per load phase
+func hopLatency(hop int, probes []Probe) HopLatency {
+	var idle, loaded []Probe
+	byLoad := make(map[string][]Probe)
+
+	for _, probe := range probes {
+		if probe.Load == "" {
+			idle = append(idle, probe)
+		} else {
+			loaded = append(loaded, probe)
+			byLoad[probe.Load] = append(byLoad[probe.Load], probe)
+		}
+	}
+
+	latency := HopLatency{Hop: hop, Idle: latencyStats(idle), Loaded: latencyStats(loaded)}
+
+	if latency.Idle != nil && latency.Idle.Probes > latency.Idle.Lost {
+		for load, probes := range byLoad {
+			if stats := latencyStats(probes); stats.Probes > stats.Lost {
+				if latency.Increase == nil {
+					latency.Increase = make(map[string]float64)
+				}
+				latency.Increase[load] = stats.Median - latency.Idle.Median
+			}
+		}
+	}
+
+	return latency
+}
+
+// latencyStats: rtt distribution of probes [nil: no probes]
+func latencyStats(probes []Probe) *LatencyStats {
+	if len(probes) == 0 {
+		return nil
+	}
+
+	stats := &LatencyStats{Probes: len(probes)}
+
+	var rtts []float64
+	for _, probe := range probes {
+		if probe.Lost {
+			stats.Lost++
+		} else {
+			rtts = append(rtts, probe.RTT)
+		}
+	}
+
+	if len(rtts) > 0 {
+		slices.Sort(rtts)
+		stats.Median = Quantile(rtts, 0.5)
+		stats.P95 = Quantile(rtts, 0.95)
+		stats.P99 = Quantile(rtts, 0.99)
+	}
+
+	return stats
+}
+
+// responsiveness: round-trips per minute of the 95% trimmed mean of rtts under load (ms)
+func responsiveness(rtts []float64) float64 {
+	if len(rtts) == 0 {
+		return 0
+	}
+
+	sorted := slices.Sorted(slices.Values(rtts))
+	trimmed := sorted[:max(1, int(math.Floor(0.95*float64(len(sorted)))))]
+
+	var sum float64
+	for _, rtt := range trimmed {
+		sum += rtt
+	}
+	mean := sum / float64(len(trimmed))
+	if mean <= 0 {
+		return 0
+	}
+
+	return math.Round(60000 / mean)
+}
+
+func rpmClass(rpm float64) string {
+	switch {
+	case rpm < 300:
+		return "low"
+	case rpm < 1000:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// Quantile: nearest-rank quantile q of sorted values
+func Quantile(sorted []float64, q float64) float64 {
+	rank := int(math.Ceil(q * float64(len(sorted))))
+	return sorted[max(rank, 1)-1]
+}
+
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,85 @@
+package analysis
+
+import "testing"
+
+func TestGrade(t *testing.T) {
+	tests := []struct {
+		increase float64
+		want     string
+	}{
+		{-2, "A+"},
+		{0, "A+"},
+		{4.99, "A+"},
+		{5, "A"},
+		{29.9, "A"},
+		{30, "B"},
+		{60, "C"},
+		{199.9, "C"},
+		{200, "D"},
+		{399.9, "D"},
+		{400, "F"},
+		{5000, "F"},
+	}
+
+	for _, test := range tests {
+		if got := Grade(test.increase); got != test.want {
+			t.Errorf("%v ms: got %s, want %s", test.increase, got, test.want)
+		}
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	tests := []struct {
+		sorted []float64
+		q      float64
+		want   float64
+	}{
+		{[]float64{7}, 0, 7},
+		{[]float64{7}, 0.99, 7},
+		{[]float64{1, 2, 3, 4}, 0, 1},
+		{[]float64{1, 2, 3, 4}, 0.25, 1},
+		{[]float64{1, 2, 3, 4}, 0.26, 2},
+		{[]float64{1, 2, 3, 4}, 0.5, 2},
+		{[]float64{1, 2, 3, 4}, 0.95, 4},
+		{[]float64{1, 2, 3, 4}, 1, 4},
+		// nearest rank, not interpolated
+		{[]float64{10, 20, 30, 40, 50}, 0.5, 30},
+		{[]float64{10, 20, 30, 40, 50}, 0.7, 40},
+	}
+
+	for _, test := range tests {
+		if got := Quantile(test.sorted, test.q); got != test.want {
+			t.Errorf("%v of %v: got %v, want %v", test.q, test.sorted, got, test.want)
+		}
+	}
+}
+
+func TestResponsiveness(t *testing.T) {
+	var ramp, outlier []float64
+	for i := 1; i <= 20; i++ {
+		ramp = append(ramp, float64(i))
+		outlier = append(outlier, 10)
+	}
+	outlier[7] = 1000
+
+	tests := []struct {
+		name string
+		rtts []float64
+		want float64
+	}{
+		{"none", nil, 0},
+		{"single", []float64{50}, 1200},
+		// 19 of 20 kept, of mean 10 ms
+		{"ramp", ramp, 6000},
+		{"outlier trimmed", outlier, 6000},
+		// too few to trim
+		{"pair", []float64{100, 300}, 600},
+		{"zero", []float64{0, 0}, 0},
+	}
+
+	for _, test := range tests {
+		if got := responsiveness(test.rtts); got != test.want {
+			t.Errorf("%s: got %v rpm, want %v", test.name, got, test.want)
+		}
+	}
+}
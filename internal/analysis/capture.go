@@ -0,0 +1,210 @@
+package analysis
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// Window: time interval (unix seconds)
+type Window struct {
+	Start, End float64
+}
+
+// CaptureStats: speedtest (tcp) flow observations of the capture during load windows
+type CaptureStats struct {
+	DataSegments    int       // segments carrying payload
+	Retransmissions int       // data segments not beyond the flow's highest sequence
+	ECT             int       // data segments marked ecn-capable (ECT or CE)
+	CE              int       // data segments marked congestion experienced
+	RetransmitTimes []float64 // unix seconds
+	RTT             []Sample  // bulk flow rtt (ms), matched by tcp timestamps
+}
+
+// Sample: timed value
+type Sample struct {
+	Time  float64
+	Value float64
+}
+
+// packetReader: reader of pcap and pcapng files
+type packetReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+// pcapngMagic: block type of the pcapng section header block
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// newPacketReader: pcapng or pcap reader of r, by its leading block
+func newPacketReader(r io.Reader) (packetReader, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(len(pcapngMagic))
+	if err != nil {
+		return nil, err
+	}
+	if string(magic) == string(pcapngMagic) {
+		return pcapgo.NewNgReader(buffered, pcapgo.DefaultNgReaderOptions)
+	}
+	return pcapgo.NewReader(buffered)
+}
+
+type flowKey struct {
+	src, dst         string
+	srcPort, dstPort layers.TCPPort
+}
+
+func (k flowKey) reverse() flowKey {
+	return flowKey{k.dst, k.src, k.dstPort, k.srcPort}
+}
+
+type flowState struct {
+	maxEnd  uint32
+	started bool
+	tsSent  map[uint32]float64 // first send time per tsval
+}
+
+// AnalyzeCapture: tcp flow observations of the pcap or pcapng file at path within windows
+//
+// packets from local addresses are outbound; rtt is that from an outbound packet to the
+// first inbound packet echoing its timestamp, such that it covers the queue traversed by
+// the bulk data in either direction.
+func AnalyzeCapture(path string, local []net.IP, windows []Window) (*CaptureStats, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader, err := newPacketReader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	isLocal := func(ip net.IP) bool {
+		for _, l := range local {
+			if l.Equal(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	stats := &CaptureStats{}
+	flows := make(map[flowKey]*flowState)
+	flow := func(key flowKey) *flowState {
+		state, ok := flows[key]
+		if !ok {
+			state = &flowState{tsSent: make(map[uint32]float64)}
+			flows[key] = state
+		}
+		return state
+	}
+
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if err != nil {
+			break
+		}
+		t := float64(ci.Timestamp.UnixNano()) / 1e9
+
+		packet := gopacket.NewPacket(data, reader.LinkType(), gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+
+		var (
+			src, dst   net.IP
+			ecn        uint8
+			payloadLen int
+		)
+		switch ip := packet.NetworkLayer().(type) {
+		case *layers.IPv4:
+			src, dst, ecn = ip.SrcIP, ip.DstIP, ip.TOS&0x3
+			payloadLen = int(ip.Length) - int(ip.IHL)*4
+		case *layers.IPv6:
+			src, dst, ecn = ip.SrcIP, ip.DstIP, ip.TrafficClass&0x3
+			payloadLen = int(ip.Length)
+		default:
+			continue
+		}
+
+		tcp, ok := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		if !ok {
+			continue
+		}
+		// payload length by headers, the capture being truncated
+		payloadLen -= int(tcp.DataOffset) * 4
+
+		key := flowKey{src.String(), dst.String(), tcp.SrcPort, tcp.DstPort}
+		state := flow(key)
+		inLoad := within(windows, t)
+
+		if payloadLen > 0 {
+			end := tcp.Seq + uint32(payloadLen)
+			retransmit := state.started && int32(end-state.maxEnd) <= 0
+			if !state.started || int32(end-state.maxEnd) > 0 {
+				state.maxEnd, state.started = end, true
+			}
+
+			if inLoad {
+				stats.DataSegments++
+				if retransmit {
+					stats.Retransmissions++
+					stats.RetransmitTimes = append(stats.RetransmitTimes, t)
+				}
+				if ecn != 0 {
+					stats.ECT++
+				}
+				if ecn == 0x3 {
+					stats.CE++
+				}
+			}
+		}
+
+		tsval, tsecr, ok := timestamps(tcp)
+		if !ok {
+			continue
+		}
+
+		if isLocal(src) {
+			if _, seen := state.tsSent[tsval]; !seen {
+				state.tsSent[tsval] = t
+			}
+		} else if isLocal(dst) {
+			reverse := flow(key.reverse())
+			if sendTime, ok := reverse.tsSent[tsecr]; ok {
+				delete(reverse.tsSent, tsecr)
+				// bulk flows only, not handshakes (e.g. of a tcp latency flow)
+				if inLoad && (state.started || reverse.started) {
+					stats.RTT = append(stats.RTT, Sample{Time: t, Value: (t - sendTime) * 1e3})
+				}
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// timestamps: tsval and tsecr of the tcp timestamps option
+func timestamps(tcp *layers.TCP) (tsval, tsecr uint32, ok bool) {
+	for _, option := range tcp.Options {
+		if option.OptionType == layers.TCPOptionKindTimestamps && len(option.OptionData) == 8 {
+			data := option.OptionData
+			return binary.BigEndian.Uint32(data[0:4]), binary.BigEndian.Uint32(data[4:8]), true
+		}
+	}
+	return 0, 0, false
+}
+
+func within(windows []Window, t float64) bool {
+	for _, w := range windows {
+		if t >= w.Start && t < w.End {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package analysis
+
+// FlowLatency: latency of the sparse flow to the server while idle and under load
+//
+// one-way delays are offset by the difference of the clocks at either end, which cancels
+// in their increase under load.
+type FlowLatency struct {
+	Idle            *LatencyStats      `json:"idle,omitempty" desc:"probes outside load phases"`
+	Loaded          *LatencyStats      `json:"loaded,omitempty" desc:"probes during any load phase"`
+	Increase        map[string]float64 `json:"median_increase_ms,omitempty" desc:"median rtt increase over idle per load phase (ms)"`
+	ForwardIncrease map[string]float64 `json:"forward_increase_ms,omitempty" desc:"median one-way delay increase to the server per load phase (ms) [absent: tcp]"`
+	ReverseIncrease map[string]float64 `json:"reverse_increase_ms,omitempty" desc:"median one-way delay increase from the server per load phase (ms) [absent: tcp]"`
+}
+
+// AnalyzeFlow: latency of the flow's probes of rtt, and of forward and reverse one-way
+// delay where measured
+//
+// returns nil where no probe was sent.
+func AnalyzeFlow(rtt, forward, reverse []Probe) *FlowLatency {
+	if len(rtt) == 0 {
+		return nil
+	}
+
+	latency := hopLatency(0, rtt)
+
+	return &FlowLatency{
+		Idle:            latency.Idle,
+		Loaded:          latency.Loaded,
+		Increase:        latency.Increase,
+		ForwardIncrease: hopLatency(0, forward).Increase,
+		ReverseIncrease: hopLatency(0, reverse).Increase,
+	}
+}
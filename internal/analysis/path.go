@@ -0,0 +1,206 @@
+package analysis
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+)
+
+// initialTTLs: common initial ttls (hop limits) of replies, from which the reverse path
+// length is inferred
+var initialTTLs = []int{32, 64, 128, 255}
+
+// MplsLabel: mpls label stack entry of the probe as received by a hop (rfc 4950)
+type MplsLabel struct {
+	Label int  `json:"label" desc:"label value"`
+	TC    int  `json:"tc" desc:"traffic class"`
+	S     bool `json:"s" desc:"bottom of stack"`
+	TTL   int  `json:"ttl" desc:"label ttl"`
+}
+
+// HopInterface: interface of a hop, identified by its reply (rfc 5837)
+type HopInterface struct {
+	Role  string `json:"role" desc:"interface role: incoming, sub-ip, outgoing or next-hop"`
+	Index *int   `json:"index,omitempty" desc:"interface index (ifIndex) [absent: not included]"`
+	Addr  net.IP `json:"addr,omitempty" desc:"interface address [absent: not included]"`
+	Name  string `json:"name,omitempty" desc:"interface name [absent: not included]"`
+	MTU   int    `json:"mtu,omitempty" desc:"interface mtu [absent: not included]"`
+}
+
+// interfaceRoles: roles of rfc 5837 interface information objects, by the two high bits
+// of their c-type
+var interfaceRoles = []string{"incoming", "sub-ip", "outgoing", "next-hop"}
+
+// InterfaceRole: role of an rfc 5837 interface information object of c-type cType
+func InterfaceRole(cType int) string {
+	return interfaceRoles[cType>>6&0x03]
+}
+
+// HopPath: path to and from a hop, inferred from the headers of its replies
+//
+// a label stack in the icmp extensions of its replies marks a hop inside an mpls tunnel,
+// as does a quoted ttl above 1, of a tunnel not propagating the label ttl to the ip
+// header at its ingress, the quoted ttl less 1 being the hop's depth in the tunnel; a
+// jump of the reverse path length over that of the previous hop marks a tunnel hiding
+// its hops from the forward path altogether (return path length analysis).
+type HopPath struct {
+	Hop         int  `json:"hop" desc:"probe ttl"`
+	Replies     int  `json:"replies" desc:"replies with recorded headers"`
+	ReplyTTL    int  `json:"reply_ttl" desc:"most frequent remaining ttl of replies"`
+	ReverseHops int  `json:"reverse_hops" desc:"reverse path length (hops), assuming the nearest initial ttl of 32, 64, 128 or 255"`
+	Asymmetry   int  `json:"asymmetry" desc:"reverse less forward path length (hops)"`
+	QuotedTTL   *int `json:"quoted_ttl,omitempty" desc:"most frequent ttl of the probe as quoted [absent: no time exceeded replies]"`
+	QuotedTOS   *int `json:"quoted_tos,omitempty" desc:"most frequent tos of the probe as quoted [absent: no time exceeded replies]"`
+	TunnelDepth int  `json:"tunnel_depth,omitempty" desc:"depth in an mpls tunnel, from a quoted ttl above 1"`
+	HiddenHops  int  `json:"hidden_hops,omitempty" desc:"hops hidden before this hop, from a jump in reverse path length"`
+	Mpls        bool `json:"mpls,omitempty" desc:"whether the hop is inside an mpls tunnel, from a label stack or quoted ttl"`
+
+	MplsStacks [][]MplsLabel  `json:"mpls_stacks,omitempty" desc:"distinct label stacks of replies (rfc 4950), by label values, in order seen"`
+	Interfaces []HopInterface `json:"interfaces,omitempty" desc:"distinct interfaces identified by replies (rfc 5837), in order seen"`
+	Remarked   bool           `json:"remarked,omitempty" desc:"whether the quoted dscp differs from that sent"`
+	EcnChanged bool           `json:"ecn_changed,omitempty" desc:"whether the quoted ecn differs from that sent"`
+}
+
+// PathReport: hidden hops, path asymmetry and tos remarking along the probed path
+type PathReport struct {
+	ProbeTOS     int       `json:"probe_tos" desc:"tos (traffic class) of the probes"`
+	MplsHops     int       `json:"mpls_hops" desc:"hops inside mpls tunnels, from label stacks or quoted ttls above 1"`
+	HiddenHops   int       `json:"hidden_hops" desc:"hops hidden from the forward path up to the farthest hop, by which hop numbering falls short"`
+	RemarkedHop  *int      `json:"remarked_hop,omitempty" desc:"first hop quoting a dscp other than that sent [absent: none]"`
+	EcnChangeHop *int      `json:"ecn_change_hop,omitempty" desc:"first hop quoting an ecn other than that sent, e.g. bleached [absent: none]"`
+	Hops         []HopPath `json:"hops" desc:"per-hop path inferences"`
+}
+
+// AnalyzePath: path inferences from the reply and quoted headers of probes sent with tos
+// probeTOS [nil: no headers recorded]
+func AnalyzePath(probes []Probe, probeTOS int) *PathReport {
+	byHop := make(map[int][]Probe)
+	for _, probe := range probes {
+		if !probe.Lost && probe.ReplyTTL > 0 {
+			byHop[probe.Hop] = append(byHop[probe.Hop], probe)
+		}
+	}
+	if len(byHop) == 0 {
+		return nil
+	}
+
+	report := &PathReport{ProbeTOS: probeTOS, Hops: make([]HopPath, 0, len(byHop))}
+
+	previous := HopPath{Hop: -1}
+	for _, hop := range sortedKeys(byHop) {
+		replies := byHop[hop]
+
+		ttls := make([]int, 0, len(replies))
+		var quotedTTLs, quotedTOSs []int
+		for _, probe := range replies {
+			ttls = append(ttls, probe.ReplyTTL)
+			if probe.Quoted {
+				quotedTTLs = append(quotedTTLs, probe.QuotedTTL)
+				quotedTOSs = append(quotedTOSs, probe.QuotedTOS)
+			}
+		}
+
+		h := HopPath{Hop: hop, Replies: len(replies), ReplyTTL: mode(ttls)}
+		h.MplsStacks, h.Interfaces = extensionsOf(replies)
+		h.ReverseHops = initialTTL(h.ReplyTTL) - h.ReplyTTL
+		h.Asymmetry = h.ReverseHops - (hop - 1)
+
+		if len(quotedTTLs) > 0 {
+			ttl, tos := mode(quotedTTLs), mode(quotedTOSs)
+			h.QuotedTTL, h.QuotedTOS = &ttl, &tos
+			h.TunnelDepth = max(ttl-1, 0)
+			h.Remarked = tos>>2 != probeTOS>>2
+			h.EcnChanged = tos&0x03 != probeTOS&0x03
+		}
+
+		if previous.Hop == hop-1 && h.ReverseHops > previous.ReverseHops+1 {
+			h.HiddenHops = h.ReverseHops - previous.ReverseHops - 1
+		}
+		previous = h
+
+		h.Mpls = h.TunnelDepth > 0 || len(h.MplsStacks) > 0
+		if h.Mpls {
+			report.MplsHops++
+		}
+		report.HiddenHops += h.HiddenHops
+
+		if h.Remarked && report.RemarkedHop == nil {
+			report.RemarkedHop = &h.Hop
+		}
+		if h.EcnChanged && report.EcnChangeHop == nil {
+			report.EcnChangeHop = &h.Hop
+		}
+
+		report.Hops = append(report.Hops, h)
+	}
+
+	return report
+}
+
+// extensionsOf: distinct label stacks and interfaces of replies' icmp extensions
+func extensionsOf(replies []Probe) (stacks [][]MplsLabel, interfaces []HopInterface) {
+	seen := make(map[string]bool)
+
+	for _, probe := range replies {
+		if len(probe.Mpls) > 0 {
+			if key := "mpls " + LabelStack(probe.Mpls); !seen[key] {
+				seen[key] = true
+				stacks = append(stacks, probe.Mpls)
+			}
+		}
+
+		for _, ifc := range probe.Interfaces {
+			if key := fmt.Sprintf("if %s %v %s %s", ifc.Role, intOf(ifc.Index), ifc.Addr, ifc.Name); !seen[key] {
+				seen[key] = true
+				interfaces = append(interfaces, ifc)
+			}
+		}
+	}
+
+	return stacks, interfaces
+}
+
+func intOf(i *int) any {
+	if i == nil {
+		return nil
+	}
+	return *i
+}
+
+// LabelStack: label values of an mpls label stack, top first, separated by "/"
+func LabelStack(stack []MplsLabel) string {
+	labels := make([]string, len(stack))
+	for i, entry := range stack {
+		labels[i] = fmt.Sprint(entry.Label)
+	}
+	return strings.Join(labels, "/")
+}
+
+// initialTTL: nearest common initial ttl not below ttl
+func initialTTL(ttl int) int {
+	for _, initial := range initialTTLs {
+		if ttl <= initial {
+			return initial
+		}
+	}
+	return initialTTLs[len(initialTTLs)-1]
+}
+
+// mode: most frequent of values, the least of ties
+func mode(values []int) int {
+	counts := make(map[int]int)
+	for _, v := range values {
+		counts[v]++
+	}
+
+	keys := slices.Sorted(maps.Keys(counts))
+	best := keys[0]
+	for _, k := range keys[1:] {
+		if counts[k] > counts[best] {
+			best = k
+		}
+	}
+	return best
+}
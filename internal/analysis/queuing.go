@@ -0,0 +1,213 @@
+package analysis
+
+import (
+	"cmp"
+	"maps"
+	"math"
+	"slices"
+)
+
+const (
+	baselineWindow = 30.0 // s, of the rolling minimum rtt
+	queuingBin     = 1.0  // s, of the queuing delay timeseries
+	minBinReplies  = 2    // replies per bin from which its median is reported
+
+	minLinkQueue = 1.0 // ms, of loaded link queuing delay from which a link is a bottleneck
+)
+
+// QueuingDelay: per-hop queuing delay estimate
+//
+// each probe's queuing delay is its rtt over the rolling minimum rtt of its hop (such that
+// the constant propagation and icmp generation delays cancel), and that of a link is the
+// difference of the queuing delays of its far and near hops in the same round (such that
+// delay increases are attributed to the link at which they arise). timeseries are binned,
+// and medians taken, robust to slow-path outliers and to replies missing by rate limiting.
+type QueuingDelay struct {
+	Window     float64      `json:"baseline_window_s" desc:"window of the rolling minimum rtt (s)"`
+	Bin        float64      `json:"bin_s" desc:"width of timeseries bins (s)"`
+	Bottleneck int          `json:"bottleneck_hop,omitempty" desc:"far hop of the link of largest median queuing delay under load [absent: none over 1 ms]"`
+	Hops       []HopQueuing `json:"hops" desc:"queuing delay per responding hop"`
+}
+
+// HopQueuing: queuing delay to a hop, and on the link from the previous responding hop
+type HopQueuing struct {
+	Hop         int            `json:"hop" desc:"probe ttl"`
+	PreviousHop int            `json:"previous_hop,omitempty" desc:"near hop of the link [absent: link from the host]"`
+	Baseline    float64        `json:"baseline_ms" desc:"minimum rtt (ms)"`
+	LoadedQueue *float64       `json:"loaded_queue_median_ms,omitempty" desc:"median queuing delay under load (ms) [absent: no loaded replies]"`
+	LoadedLink  *float64       `json:"loaded_link_median_ms,omitempty" desc:"median link queuing delay under load (ms) [absent: no loaded replies in common rounds]"`
+	Series      []QueuingPoint `json:"series" desc:"queuing delay timeseries"`
+}
+
+// QueuingPoint: median queuing delays over a bin
+type QueuingPoint struct {
+	Time    float64  `json:"time" desc:"bin start (unix seconds)"`
+	Replies int      `json:"replies" desc:"replies in bin"`
+	Queue   float64  `json:"queue_ms" desc:"median queuing delay to the hop (ms)"`
+	Link    *float64 `json:"link_ms,omitempty" desc:"median queuing delay on the link (ms) [absent: no replies of the near hop in common rounds]"`
+}
+
+type queuingReply struct {
+	time, queue float64
+	round       int
+	loaded      bool
+}
+
+// hopReplies: queuing delay of a hop's replies, and by round of those and of its link
+type hopReplies struct {
+	replies []queuingReply
+	queues  map[int]float64
+	links   map[int]float64
+}
+
+// EstimateQueuing: per-hop queuing delay of probes [nil: no replies]
+//
+// probes of a hop in the same round (e.g. by time exceeded and by direct echo) are taken
+// at their minimum rtt. links are taken from the nearest hop not of slowPath, whose replies
+// carry delays of their own, and such hops are not taken for the bottleneck.
+func EstimateQueuing(probes []Probe, slowPath map[int]bool) *QueuingDelay {
+	byHop := make(map[int]map[int]Probe)
+	start := math.Inf(1)
+
+	for _, probe := range probes {
+		if probe.Lost {
+			continue
+		}
+		if byHop[probe.Hop] == nil {
+			byHop[probe.Hop] = make(map[int]Probe)
+		}
+		if previous, ok := byHop[probe.Hop][probe.Round]; !ok || probe.RTT < previous.RTT {
+			byHop[probe.Hop][probe.Round] = probe
+		}
+		start = min(start, probe.Time)
+	}
+	if len(byHop) == 0 {
+		return nil
+	}
+
+	estimate := &QueuingDelay{Window: baselineWindow, Bin: queuingBin}
+	var (
+		largest float64
+		near    *hopReplies
+		nearHop int
+	)
+
+	for _, hop := range sortedKeys(byHop) {
+		h := HopQueuing{Hop: hop, Baseline: math.Inf(1)}
+		for _, probe := range byHop[hop] {
+			h.Baseline = min(h.Baseline, probe.RTT)
+		}
+
+		r := queuingReplies(slices.Collect(maps.Values(byHop[hop])))
+		if near != nil {
+			h.PreviousHop = nearHop
+			r.links = make(map[int]float64)
+			for round, queue := range r.queues {
+				if nearQueue, ok := near.queues[round]; ok {
+					r.links[round] = queue - nearQueue
+				}
+			}
+		} else {
+			// link from the host
+			r.links = r.queues
+		}
+		if !slowPath[hop] {
+			near, nearHop = &r, hop
+		}
+
+		h.Series = queuingSeries(r.replies, r.links, start)
+
+		var loaded, loadedLink []float64
+		for _, reply := range r.replies {
+			if !reply.loaded {
+				continue
+			}
+			loaded = append(loaded, reply.queue)
+			if link, ok := r.links[reply.round]; ok {
+				loadedLink = append(loadedLink, link)
+			}
+		}
+		if len(loaded) > 0 {
+			h.LoadedQueue = median(loaded)
+		}
+		if len(loadedLink) > 0 {
+			h.LoadedLink = median(loadedLink)
+			if *h.LoadedLink > max(largest, minLinkQueue) && !slowPath[hop] {
+				estimate.Bottleneck, largest = hop, *h.LoadedLink
+			}
+		}
+
+		estimate.Hops = append(estimate.Hops, h)
+	}
+
+	return estimate
+}
+
+// queuingReplies: queuing delay of a hop's replies over their rolling minimum rtt
+func queuingReplies(replies []Probe) hopReplies {
+	slices.SortFunc(replies, func(a, b Probe) int { return cmp.Compare(a.Time, b.Time) })
+
+	r := hopReplies{queues: make(map[int]float64)}
+
+	// monotonic deque of indices of increasing rtt within the window
+	var window []int
+	for i, probe := range replies {
+		for len(window) > 0 && replies[window[len(window)-1]].RTT >= probe.RTT {
+			window = window[:len(window)-1]
+		}
+		window = append(window, i)
+		for replies[window[0]].Time < probe.Time-baselineWindow {
+			window = window[1:]
+		}
+
+		queue := probe.RTT - replies[window[0]].RTT
+		r.replies = append(r.replies, queuingReply{time: probe.Time, queue: queue, round: probe.Round, loaded: probe.Load != ""})
+		r.queues[probe.Round] = queue
+	}
+
+	return r
+}
+
+// queuingSeries: binned medians of queuing delays of replies and of their links
+func queuingSeries(replies []queuingReply, links map[int]float64, start float64) []QueuingPoint {
+	type bin struct{ queues, links []float64 }
+	bins := make(map[int]*bin)
+
+	for _, reply := range replies {
+		i := int((reply.time - start) / queuingBin)
+		if bins[i] == nil {
+			bins[i] = &bin{}
+		}
+		bins[i].queues = append(bins[i].queues, reply.queue)
+		if link, ok := links[reply.round]; ok {
+			bins[i].links = append(bins[i].links, link)
+		}
+	}
+
+	series := []QueuingPoint{}
+	for _, i := range sortedKeys(bins) {
+		b := bins[i]
+		if len(b.queues) < minBinReplies {
+			continue
+		}
+
+		point := QueuingPoint{
+			Time:    start + float64(i)*queuingBin,
+			Replies: len(b.queues),
+			Queue:   *median(b.queues),
+		}
+		if len(b.links) >= minBinReplies {
+			point.Link = median(b.links)
+		}
+		series = append(series, point)
+	}
+
+	return series
+}
+
+// median: median of values, rounded to µs
+func median(values []float64) *float64 {
+	slices.Sort(values)
+	m := math.Round(Quantile(values, 0.5)*1e3) / 1e3
+	return &m
+}
@@ -0,0 +1,91 @@
+package analysis
+
+import "testing"
+
+// steppedProbes: probes of hops 1 to 3, of base rtt 1, 5 and 10 ms, loaded from round 20
+// on, when delay adds to the rtt of each hop
+func steppedProbes(delay func(hop int) float64) []Probe {
+	var probes []Probe
+	for round := range 60 {
+		for hop, base := range map[int]float64{1: 1, 2: 5, 3: 10} {
+			probe := Probe{Hop: hop, Round: round, Time: 1700000000 + float64(round)*0.1, RTT: base}
+			if round >= 20 {
+				probe.Load = "download"
+				probe.RTT += delay(hop)
+			}
+			probes = append(probes, probe)
+		}
+	}
+	return probes
+}
+
+func TestEstimateQueuing(t *testing.T) {
+	tests := []struct {
+		name       string
+		delay      func(hop int) float64
+		slowPath   map[int]bool
+		bottleneck int
+		previous   map[int]int     // near hop per hop
+		links      map[int]float64 // loaded link median per hop
+	}{
+		{
+			name: "step on the second link",
+			delay: func(hop int) float64 {
+				return map[int]float64{2: 20, 3: 20}[hop]
+			},
+			bottleneck: 2,
+			previous:   map[int]int{1: 0, 2: 1, 3: 2},
+			links:      map[int]float64{1: 0, 2: 20, 3: 0},
+		},
+		{
+			name: "no step",
+			delay: func(hop int) float64 {
+				return 0.5
+			},
+			bottleneck: 0,
+			links:      map[int]float64{1: 0.5, 2: 0, 3: 0},
+		},
+		{
+			name: "slow path hop taken for the bottleneck",
+			delay: func(hop int) float64 {
+				return map[int]float64{2: 40, 3: 20}[hop]
+			},
+			bottleneck: 2,
+			previous:   map[int]int{3: 2},
+			links:      map[int]float64{2: 40, 3: -20},
+		},
+		{
+			name: "slow path hop skipped",
+			delay: func(hop int) float64 {
+				return map[int]float64{2: 40, 3: 20}[hop]
+			},
+			slowPath:   map[int]bool{2: true},
+			bottleneck: 3,
+			previous:   map[int]int{2: 1, 3: 1},
+			links:      map[int]float64{2: 40, 3: 20},
+		},
+	}
+
+	for _, test := range tests {
+		estimate := EstimateQueuing(steppedProbes(test.delay), test.slowPath)
+		if estimate == nil || len(estimate.Hops) != 3 {
+			t.Fatalf("%s: got %+v", test.name, estimate)
+		}
+		if estimate.Bottleneck != test.bottleneck {
+			t.Errorf("%s: bottleneck: got hop %d, want %d", test.name, estimate.Bottleneck, test.bottleneck)
+		}
+
+		for _, h := range estimate.Hops {
+			if previous, ok := test.previous[h.Hop]; ok && h.PreviousHop != previous {
+				t.Errorf("%s: hop %d: previous hop: got %d, want %d", test.name, h.Hop, h.PreviousHop, previous)
+			}
+			if link, ok := test.links[h.Hop]; ok && (h.LoadedLink == nil || *h.LoadedLink != link) {
+				t.Errorf("%s: hop %d: loaded link: got %v, want %v", test.name, h.Hop, h.LoadedLink, link)
+			}
+		}
+	}
+
+	if estimate := EstimateQueuing([]Probe{{Hop: 1, Lost: true}}, nil); estimate != nil {
+		t.Errorf("no replies: got %+v", estimate)
+	}
+}
@@ -0,0 +1,247 @@
+package analysis
+
+import (
+	"cmp"
+	"math"
+	"slices"
+)
+
+// evidence of icmp rate limiting and slow-path generation
+const (
+	EvidenceFartherHops     = "loss not at farther hops"
+	EvidencePeriodic        = "periodic drops"
+	EvidenceTokenBucket     = "replies capped per second"
+	EvidenceLoadIndependent = "loss independent of load"
+	EvidenceInversion       = "rtt inversion"
+	EvidenceDispersion      = "slow-path dispersion"
+)
+
+const (
+	minHopProbes     = 10   // probes from which a hop's loss is considered
+	minLossRatio     = 0.05 // loss ratio from which a hop's loss is considered
+	minLossExcess    = 0.05 // loss ratio over that of a farther hop, from which it is not on the data path
+	minDrops         = 5    // drops from which their pattern is considered
+	maxPeriodicCV    = 0.25 // coefficient of variation of drop gaps below which drops are periodic
+	minCappedBins    = 3    // bins with drops from which a cap is considered
+	minCappedShare   = 0.8  // share of bins with drops at the cap from which replies are capped
+	maxCapRatio      = 0.8  // of the cap to probes per bin, above which it may be random loss
+	minInversion     = 1.0  // ms, of minimum rtt over that of a farther hop
+	minDispersion    = 5.0  // ms, of idle rtt dispersion (p90 over minimum)
+	dispersionFactor = 3.0  // of idle rtt dispersion over that of a farther hop
+)
+
+// HopResponse: response behavior of a hop to probes
+//
+// rate limited hops drop replies rather than probes being lost on the data path, and
+// slow-path hops generate replies with delays of their own, such that neither's replies
+// are to be attributed to the path.
+type HopResponse struct {
+	Hop         int      `json:"hop" desc:"probe ttl"`
+	LossRatio   float64  `json:"loss_ratio" desc:"fraction of probes without reply"`
+	RateLimited bool     `json:"rate_limited" desc:"whether replies are rate limited"`
+	SlowPath    bool     `json:"slow_path" desc:"whether replies are generated on a slow path"`
+	Evidence    []string `json:"evidence,omitempty" desc:"signatures of rate limiting and slow-path generation"`
+}
+
+type hopProbes struct {
+	hop    int
+	probes []Probe
+	lost   int
+	min    float64
+	spread float64 // idle p90 over minimum rtt (ms) [NaN: no idle replies]
+}
+
+// AnalyzeHopResponses: response behavior per hop of probes
+func AnalyzeHopResponses(probes []Probe) []HopResponse {
+	byHop := make(map[int][]Probe)
+	for _, probe := range probes {
+		byHop[probe.Hop] = append(byHop[probe.Hop], probe)
+	}
+
+	var hops []hopProbes
+	for _, hop := range sortedKeys(byHop) {
+		hops = append(hops, newHopProbes(hop, byHop[hop]))
+	}
+
+	responses := make([]HopResponse, 0, len(hops))
+	for i, h := range hops {
+		farther := hops[i+1:]
+		r := HopResponse{Hop: h.hop, LossRatio: math.Round(float64(h.lost)/float64(len(h.probes))*1e3) / 1e3}
+
+		if len(h.probes) >= minHopProbes && r.LossRatio >= minLossRatio {
+			for _, f := range farther {
+				if len(f.probes) >= minHopProbes && float64(f.lost)/float64(len(f.probes)) <= r.LossRatio-minLossExcess {
+					r.Evidence = append(r.Evidence, EvidenceFartherHops)
+					break
+				}
+			}
+		}
+		if periodicDrops(h.probes) {
+			r.Evidence = append(r.Evidence, EvidencePeriodic)
+		}
+		if cappedReplies(h.probes) {
+			r.Evidence = append(r.Evidence, EvidenceTokenBucket)
+		}
+		r.RateLimited = len(r.Evidence) > 0
+		if r.RateLimited && loadIndependent(h.probes) {
+			r.Evidence = append(r.Evidence, EvidenceLoadIndependent)
+		}
+
+		for _, f := range farther {
+			if h.min-f.min > minInversion {
+				r.SlowPath = true
+				r.Evidence = append(r.Evidence, EvidenceInversion)
+				break
+			}
+		}
+		for _, f := range farther {
+			if h.spread > minDispersion && h.spread > dispersionFactor*f.spread {
+				r.SlowPath = true
+				r.Evidence = append(r.Evidence, EvidenceDispersion)
+				break
+			}
+		}
+
+		responses = append(responses, r)
+	}
+
+	return responses
+}
+
+// SlowPathHops: hops flagged as generating replies on a slow path
+func SlowPathHops(responses []HopResponse) map[int]bool {
+	hops := make(map[int]bool)
+	for _, r := range responses {
+		if r.SlowPath {
+			hops[r.Hop] = true
+		}
+	}
+	return hops
+}
+
+func newHopProbes(hop int, probes []Probe) hopProbes {
+	slices.SortFunc(probes, func(a, b Probe) int { return cmp.Compare(a.Time, b.Time) })
+
+	h := hopProbes{hop: hop, probes: probes, min: math.Inf(1), spread: math.NaN()}
+
+	var idle []float64
+	for _, probe := range probes {
+		if probe.Lost {
+			h.lost++
+			continue
+		}
+		h.min = min(h.min, probe.RTT)
+		if probe.Load == "" {
+			idle = append(idle, probe.RTT)
+		}
+	}
+	if len(idle) > 0 {
+		slices.Sort(idle)
+		h.spread = Quantile(idle, 0.9) - idle[0]
+	}
+
+	return h
+}
+
+// periodicDrops: whether drops recur at a regular interval of probes
+func periodicDrops(probes []Probe) bool {
+	var gaps []float64
+	last := -1
+	for i, probe := range probes {
+		if !probe.Lost {
+			continue
+		}
+		if last >= 0 {
+			gaps = append(gaps, float64(i-last))
+		}
+		last = i
+	}
+	if len(gaps)+1 < minDrops {
+		return false
+	}
+
+	var sum float64
+	for _, gap := range gaps {
+		sum += gap
+	}
+	mean := sum / float64(len(gaps))
+	if mean < 2 {
+		// consecutive drops: bursts rather than a period
+		return false
+	}
+
+	var variance float64
+	for _, gap := range gaps {
+		variance += (gap - mean) * (gap - mean)
+	}
+	return math.Sqrt(variance/float64(len(gaps)))/mean < maxPeriodicCV
+}
+
+// cappedReplies: whether replies per second are held at a cap in seconds with drops, as by
+// a token bucket (within one, as bins and refills are not aligned)
+func cappedReplies(probes []Probe) bool {
+	if len(probes) == 0 {
+		return false
+	}
+
+	type bin struct{ replies, lost int }
+	bins := make(map[int]*bin)
+	start := probes[0].Time
+	for _, probe := range probes {
+		i := int(probe.Time - start)
+		if bins[i] == nil {
+			bins[i] = &bin{}
+		}
+		if probe.Lost {
+			bins[i].lost++
+		} else {
+			bins[i].replies++
+		}
+	}
+
+	var lossy, sent []int
+	for _, b := range bins {
+		sent = append(sent, b.replies+b.lost)
+		if b.lost > 0 {
+			lossy = append(lossy, b.replies)
+		}
+	}
+	if len(lossy) < minCappedBins {
+		return false
+	}
+
+	capped := slices.Max(lossy)
+	var atCap int
+	for _, replies := range lossy {
+		if replies >= capped-1 {
+			atCap++
+		}
+	}
+	slices.Sort(sent)
+	return capped > 0 && float64(capped) <= maxCapRatio*float64(sent[len(sent)/2]) &&
+		float64(atCap)/float64(len(lossy)) >= minCappedShare
+}
+
+// loadIndependent: whether loss under load is not above that while idle
+func loadIndependent(probes []Probe) bool {
+	var idle, idleLost, loaded, loadedLost int
+	for _, probe := range probes {
+		if probe.Load == "" {
+			idle++
+			if probe.Lost {
+				idleLost++
+			}
+		} else {
+			loaded++
+			if probe.Lost {
+				loadedLost++
+			}
+		}
+	}
+	if idle < minHopProbes || loaded < minHopProbes {
+		return false
+	}
+
+	idleRatio := float64(idleLost) / float64(idle)
+	return float64(loadedLost)/float64(loaded) <= 1.5*idleRatio+0.02
+}
@@ -0,0 +1,72 @@
+package analysis
+
+import "testing"
+
+// probeSeries: probes of 10 per second over seconds, lost as by lost(second, i) of the
+// i-th probe of a second
+func probeSeries(seconds int, lost func(second, i int) bool) []Probe {
+	var probes []Probe
+	for s := range seconds {
+		for i := range 10 {
+			probes = append(probes, Probe{Hop: 3, Round: s*10 + i, Time: float64(s) + float64(i)/10, RTT: 10, Lost: lost(s, i)})
+		}
+	}
+	return probes
+}
+
+func TestPeriodicDrops(t *testing.T) {
+	dropsAt := func(rounds ...int) func(int, int) bool {
+		return func(s, i int) bool {
+			for _, round := range rounds {
+				if s*10+i == round {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	tests := []struct {
+		name string
+		lost func(second, i int) bool
+		want bool
+	}{
+		{"every fifth", func(s, i int) bool { return i%5 == 4 }, true},
+		{"about every tenth", dropsAt(10, 21, 30, 39, 50, 61), true},
+		{"too few drops", dropsAt(10, 20, 30, 40), false},
+		{"burst", dropsAt(40, 41, 42, 43, 44, 45), false},
+		{"irregular", dropsAt(0, 1, 10, 12, 30, 31, 50), false},
+		{"none", func(s, i int) bool { return false }, false},
+	}
+
+	for _, test := range tests {
+		if got := periodicDrops(probeSeries(8, test.lost)); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCappedReplies(t *testing.T) {
+	tests := []struct {
+		name    string
+		seconds int
+		lost    func(second, i int) bool
+		want    bool
+	}{
+		{"five per second", 8, func(s, i int) bool { return i >= 5 }, true},
+		// within one of the cap, as refills are not aligned with bins
+		{"five or six per second", 8, func(s, i int) bool { return i >= 5+s%2 }, true},
+		{"idle seconds uncapped", 8, func(s, i int) bool { return s >= 4 && i >= 5 }, true},
+		{"random loss", 8, func(s, i int) bool { return i == s%10 }, false},
+		{"too few lossy seconds", 8, func(s, i int) bool { return s < 2 && i >= 5 }, false},
+		{"varying replies", 8, func(s, i int) bool { return i >= []int{2, 7, 4, 1, 6, 3, 5, 2}[s] }, false},
+		{"all lost", 8, func(s, i int) bool { return true }, false},
+		{"none", 0, nil, false},
+	}
+
+	for _, test := range tests {
+		if got := cappedReplies(probeSeries(test.seconds, test.lost)); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
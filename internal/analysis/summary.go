@@ -0,0 +1,197 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// WriteSummary: human-readable latency under load report
+func WriteSummary(w io.Writer, report *Bufferbloat) error {
+	if report == nil {
+		_, err := fmt.Fprintln(w, "Latency under load: no ping replies")
+		return err
+	}
+
+	fmt.Fprintln(w, "Latency under load")
+
+	if e := report.EndToEnd; e != nil {
+		target := "ping target"
+		if !report.ReachedTarget {
+			target = fmt.Sprintf("farthest responding hop (%d)", e.Hop)
+		}
+		fmt.Fprintf(w, "  End to end (%s): idle %s, loaded %s\n", target, medianString(e.Idle), medianString(e.Loaded))
+
+		for _, load := range slices.Sorted(maps.Keys(e.Increase)) {
+			fmt.Fprintf(w, "  Increase under %s: %+.1f ms\n", load, e.Increase[load])
+		}
+	}
+
+	grade := report.Grade
+	if grade == "" {
+		grade = "n/a (no idle or loaded replies)"
+	}
+	fmt.Fprintf(w, "  Bufferbloat grade: %s\n", grade)
+
+	if report.RPM > 0 {
+		fmt.Fprintf(w, "  Responsiveness: %.0f RPM (%s)\n", report.RPM, report.Responsiveness)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "  hop\tidle median\tp95\tp99\tloss\tloaded median\tp95\tp99\tloss\t")
+	for _, hop := range report.Hops {
+		fmt.Fprintf(tw, "  %d\t%s\t%s\t\n", hop.Hop, statsColumns(hop.Idle), statsColumns(hop.Loaded))
+	}
+
+	return tw.Flush()
+}
+
+// WriteHopResponses: human-readable rate limited and slow-path hops
+func WriteHopResponses(w io.Writer, responses []HopResponse) error {
+	for _, r := range responses {
+		var flags []string
+		if r.RateLimited {
+			flags = append(flags, "rate limited")
+		}
+		if r.SlowPath {
+			flags = append(flags, "slow path")
+		}
+		if len(flags) == 0 {
+			continue
+		}
+
+		if _, err := fmt.Fprintf(w, "  Hop %d: %s (%s)\n", r.Hop, strings.Join(flags, ", "), strings.Join(r.Evidence, ", ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WritePath: human-readable hidden hops, path asymmetry and tos remarking
+func WritePath(w io.Writer, report *PathReport) error {
+	if report == nil {
+		return nil
+	}
+
+	if report.MplsHops > 0 || report.HiddenHops > 0 {
+		fmt.Fprintf(w, "  MPLS: %d hops in tunnels, %d hidden from hop numbering\n", report.MplsHops, report.HiddenHops)
+	}
+	for _, hop := range report.Hops {
+		if len(hop.MplsStacks) > 0 {
+			stacks := make([]string, len(hop.MplsStacks))
+			for i, stack := range hop.MplsStacks {
+				stacks[i] = LabelStack(stack)
+			}
+			fmt.Fprintf(w, "  Hop %d: MPLS labels %s\n", hop.Hop, strings.Join(stacks, ", "))
+		}
+	}
+
+	var asymmetric []string
+	for _, hop := range report.Hops {
+		if hop.Asymmetry != 0 {
+			asymmetric = append(asymmetric, fmt.Sprintf("%d (%+d)", hop.Hop, hop.Asymmetry))
+		}
+	}
+	if len(asymmetric) > 0 {
+		fmt.Fprintf(w, "  Reverse path asymmetry at hops: %s\n", strings.Join(asymmetric, ", "))
+	}
+
+	if report.RemarkedHop != nil {
+		fmt.Fprintf(w, "  DSCP remarked before hop %d\n", *report.RemarkedHop)
+	}
+	if report.EcnChangeHop != nil {
+		fmt.Fprintf(w, "  ECN changed before hop %d\n", *report.EcnChangeHop)
+	}
+
+	return nil
+}
+
+// WriteQueuing: human-readable bottleneck link of the queuing delay estimate
+func WriteQueuing(w io.Writer, estimate *QueuingDelay) error {
+	if estimate == nil {
+		return nil
+	}
+
+	for _, hop := range estimate.Hops {
+		if hop.Hop != estimate.Bottleneck {
+			continue
+		}
+
+		link := fmt.Sprintf("host to hop %d", hop.Hop)
+		if hop.PreviousHop > 0 {
+			link = fmt.Sprintf("hop %d to %d", hop.PreviousHop, hop.Hop)
+		}
+		_, err := fmt.Fprintf(w, "  Bottleneck link: %s (queuing %.1f ms under load)\n", link, *hop.LoadedLink)
+		return err
+	}
+
+	_, err := fmt.Fprintln(w, "  Bottleneck link: none (no queuing under load)")
+	return err
+}
+
+// WriteAqm: human-readable aqm fingerprint
+func WriteAqm(w io.Writer, fingerprint *AqmFingerprint) error {
+	if fingerprint == nil {
+		return nil
+	}
+
+	hop := "undetermined"
+	if fingerprint.Hop > 0 {
+		hop = fmt.Sprintf("hop %d", fingerprint.Hop)
+	}
+
+	var err error
+	if fingerprint.AQM == "" {
+		_, err = fmt.Fprintf(w, "  Bottleneck AQM (%s): undetermined, %s\n", hop, fingerprint.Note)
+	} else if fingerprint.Note != "" {
+		_, err = fmt.Fprintf(w, "  Bottleneck AQM (%s): %s (confidence %.2f; %s)\n", hop, fingerprint.AQM, fingerprint.Confidence, fingerprint.Note)
+	} else {
+		_, err = fmt.Fprintf(w, "  Bottleneck AQM (%s): %s (confidence %.2f)\n", hop, fingerprint.AQM, fingerprint.Confidence)
+	}
+	return err
+}
+
+// WriteFlow: human-readable latency of the sparse flow of protocol
+func WriteFlow(w io.Writer, protocol string, latency *FlowLatency) error {
+	if latency == nil {
+		_, err := fmt.Fprintf(w, "Latency flow (%s): no probes\n", protocol)
+		return err
+	}
+
+	fmt.Fprintf(w, "Latency flow (%s): idle %s, loaded %s\n", protocol, medianString(latency.Idle), medianString(latency.Loaded))
+
+	for _, load := range slices.Sorted(maps.Keys(latency.Increase)) {
+		fmt.Fprintf(w, "  Increase under %s: %+.1f ms", load, latency.Increase[load])
+		forward, okF := latency.ForwardIncrease[load]
+		reverse, okR := latency.ReverseIncrease[load]
+		if okF && okR {
+			fmt.Fprintf(w, " (forward %+.1f ms, reverse %+.1f ms)", forward, reverse)
+		}
+		fmt.Fprintln(w)
+	}
+
+	return nil
+}
+
+func medianString(stats *LatencyStats) string {
+	if stats == nil || stats.Probes == stats.Lost {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f ms", stats.Median)
+}
+
+func statsColumns(stats *LatencyStats) string {
+	if stats == nil {
+		return strings.Repeat("-\t", 3) + "-"
+	}
+
+	loss := fmt.Sprintf("%.1f%%", 100*float64(stats.Lost)/float64(stats.Probes))
+	if stats.Probes == stats.Lost {
+		return strings.Repeat("-\t", 3) + loss
+	}
+
+	return fmt.Sprintf("%.1f\t%.1f\t%.1f\t%s", stats.Median, stats.P95, stats.P99, loss)
+}
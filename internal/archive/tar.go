@@ -3,6 +3,7 @@ package archive
 import (
 	"archive/tar"
 	"compress/gzip"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -11,9 +12,15 @@ import (
 	"github.com/internet-equity/traceneck/internal/config"
 	"github.com/internet-equity/traceneck/internal/meta"
 	"github.com/internet-equity/traceneck/internal/network"
+	"github.com/internet-equity/traceneck/internal/s3"
 )
 
 func Write() {
+	if config.S3Output() {
+		writeS3()
+		return
+	}
+
 	var outFile *os.File
 
 	if config.OutPath == "-" {
@@ -28,9 +35,65 @@ func Write() {
 		defer outFile.Close()
 	}
 
+	if err := write(outFile, isCompressed(config.OutPath)); err != nil {
+		log.Fatalln("[archive] error writing archive:", err.Error())
+	}
+
+	log.Println("[archive] data archived to:", config.OutPath)
+}
+
+// writeS3: stream archive to the object store as a multipart upload
+func writeS3() {
+	client := s3.Client{
+		Endpoint: config.S3Endpoint,
+		Region:   config.S3Region,
+		Credentials: s3.Credentials{
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		},
+	}
+	key := config.S3Key()
+
+	writer, err := s3.NewWriter(client, config.S3Bucket, key)
+	if err != nil {
+		log.Println("[archive] error starting upload:", err)
+		return
+	}
+
+	if err := write(writer, isCompressed(key)); err != nil {
+		writer.Abort()
+		log.Println("[archive] error writing archive:", err)
+		return
+	}
+
+	if err := writer.Close(); err != nil {
+		log.Println("[archive] error completing upload:", err)
+		return
+	}
+
+	log.Println("[archive] data archived to:", "s3://"+config.S3Bucket+"/"+key)
+}
+
+// WriteFile: write archive to path, compressed according to its extension
+func WriteFile(path string) error {
+	outFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(outFile, isCompressed(path)); err != nil {
+		outFile.Close()
+		return err
+	}
+
+	return outFile.Close()
+}
+
+func write(outFile io.Writer, compress bool) error {
 	var archive *tar.Writer
 
-	if outExt := filepath.Ext(config.OutPath); outExt == ".gz" || outExt == ".tgz" {
+	if compress {
 		writer, _ := gzip.NewWriterLevel(outFile, gzip.BestCompression)
 		defer writer.Close()
 
@@ -41,14 +104,28 @@ func Write() {
 	defer archive.Close()
 
 	if err := addFileToTar(archive, meta.MetaFile); err != nil {
-		log.Fatalln("[archive] error writing", meta.MetaFile, "to tar:", err.Error())
+		return fmt.Errorf("%s: %w", meta.MetaFile, err)
 	}
 
-	if err := addFileToTar(archive, network.CapFile); err != nil {
-		log.Fatalln("[archive] error writing", network.CapFile, "to tar:", err.Error())
+	// capture skipped without permission
+	if network.CapFile != "" {
+		if err := addFileToTar(archive, network.CapFile); err != nil {
+			return fmt.Errorf("%s: %w", network.CapFile, err)
+		}
 	}
 
-	log.Println("[archive] data archived to:", config.OutPath)
+	for _, exportFile := range meta.ExportFiles {
+		if err := addFileToTar(archive, exportFile); err != nil {
+			return fmt.Errorf("%s: %w", exportFile, err)
+		}
+	}
+
+	return nil
+}
+
+func isCompressed(path string) bool {
+	outExt := filepath.Ext(path)
+	return outExt == ".gz" || outExt == ".tgz"
 }
 
 func addFileToTar(archive *tar.Writer, fileName string) error {
@@ -0,0 +1,134 @@
+/*
+ * capability: privileges required by measurements
+ *
+ * pings listen on raw icmp sockets (CAP_NET_RAW) where permitted, else send on icmp
+ * datagram sockets, permitted to the groups of net.ipv4.ping_group_range; packet capture
+ * requires capture permission on the interface (CAP_NET_RAW and CAP_NET_ADMIN)
+ *
+ */
+package capability
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/icmp"
+)
+
+const (
+	procStatusPath     = "/proc/self/status"
+	pingGroupRangePath = "/proc/sys/net/ipv4/ping_group_range"
+)
+
+// capabilities: names of the capabilities of interest, by bit
+var capabilities = map[int]string{
+	12: "cap_net_admin",
+	13: "cap_net_raw",
+}
+
+// RawSockets: whether raw icmp sockets may be opened [nil: permitted]
+func RawSockets(ipv6 bool) error {
+	network, address := "ip4:icmp", "0.0.0.0"
+	if ipv6 {
+		network, address = "ip6:ipv6-icmp", "::"
+	}
+
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// DatagramSockets: whether icmp datagram sockets may be opened [nil: permitted]
+func DatagramSockets(ipv6 bool) error {
+	network, address := "udp4", "0.0.0.0"
+	if ipv6 {
+		network, address = "udp6", "::"
+	}
+
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Capture: whether packets may be captured on iface [nil: permitted]
+func Capture(iface string) error {
+	handle, err := pcap.OpenLive(iface, 0, false, 0)
+	if err != nil {
+		return err
+	}
+	handle.Close()
+	return nil
+}
+
+// PingGroupRange: groups permitted icmp datagram sockets (net.ipv4.ping_group_range)
+func PingGroupRange() (low, high int, err error) {
+	data, err := os.ReadFile(pingGroupRangePath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected %s: %q", pingGroupRangePath, data)
+	}
+	if low, err = strconv.Atoi(fields[0]); err != nil {
+		return 0, 0, err
+	}
+	if high, err = strconv.Atoi(fields[1]); err != nil {
+		return 0, 0, err
+	}
+	return low, high, nil
+}
+
+// InPingGroup: whether a group of the process is within the ping group range
+func InPingGroup(low, high int) bool {
+	groups, _ := os.Getgroups()
+	groups = append(groups, os.Getgid(), os.Getegid())
+
+	return slices.ContainsFunc(groups, func(gid int) bool {
+		return low <= gid && gid <= high
+	})
+}
+
+// Effective: effective capabilities of interest held by the process
+func Effective() ([]string, error) {
+	f, err := os.Open(procStatusPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "CapEff:")
+		if !ok {
+			continue
+		}
+
+		bits, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		var held []string
+		for _, bit := range slices.Sorted(maps.Keys(capabilities)) {
+			if bits&(1<<bit) != 0 {
+				held = append(held, capabilities[bit])
+			}
+		}
+		return held, nil
+	}
+
+	return nil, fmt.Errorf("no effective capabilities in %s", procStatusPath)
+}
@@ -8,4 +8,5 @@ var (
 	SpeedtestDone = make(Type)
 	CaptureDone   = make(Type)
 	PingDone      = make(Type)
+	FlowDone      = make(Type)
 )
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// parseBitrate: parse bits per second with optional K/M/G suffix (as iperf3, powers of 1000)
+func parseBitrate(rate string) (uint64, error) {
+	if rate == "" {
+		return 0, nil
+	}
+
+	multiplier := uint64(1)
+	switch strings.ToUpper(rate[len(rate)-1:]) {
+	case "K":
+		multiplier = 1e3
+	case "M":
+		multiplier = 1e6
+	case "G":
+		multiplier = 1e9
+	}
+	if multiplier != 1 {
+		rate = rate[:len(rate)-1]
+	}
+
+	value, err := strconv.ParseFloat(rate, 64)
+	if err != nil || value < 0 {
+		return 0, errors.New("invalid bitrate")
+	}
+
+	return uint64(value * float64(multiplier)), nil
+}
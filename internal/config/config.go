@@ -5,8 +5,10 @@ import (
 	"io"
 	"log"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -21,48 +23,174 @@ var (
 	// defaults *may* be specified here (and to be modifiable prior to invocation of Define)
 	Interface string           // interface
 	Tool      string           // ndt or ookla
-	Server    string		   // address for the custom server
+	Server    string           // address for the custom server
+	Direction string           // download, upload or both
+	IPVersion int              // ip version of the server: 4 or 6 [0: either]
+	DualStack bool             // run over ipv4, then ipv6, and compare
 	NoPing    bool             // whether to skip pings
 	PingType  string           // icmp or udp
 	MaxTTL    int              // maximum TTL until which to send pings
-	DirectHop int              // hop to ping directly by icmp echo
+	DirectHop int              // hop to ping directly
+	ProbeTOS  int              // tos (traffic class) byte of pings
 	OutPath   string = "data/" // out path/directory (may be directory/, file or -)
 	TShark    bool             // use tshark
 	IdleTime  int              // idle time in seconds
+	PreIdle   int              // pre-speedtest idle (baseline) time in seconds
 	Force     bool             // whether to confirm
 	Quiet     bool             // silence logging
 	Terse     bool             // terse rtt metadata
 
+	// probe flags
+	ProbeInterval time.Duration // interval between probes of a hop
+	ProbePoisson  bool          // exponentially distributed probe intervals
+	ProbeSize     int           // probe payload size in bytes
+	ProbePattern  string        // probe payload pattern: hex bytes or random
+	ProbeBudget   int           // probes per second across hops [0: unlimited]
+
+	// latency flow flags
+	LatencyFlow     string // sparse flow alongside the speedtest: tcp or udp [empty: none]
+	LatencyFlowPort int    // server port of the latency flow
+
+	// output flags
+	Formats  []string // additional output formats
+	TagPairs []string // key=value tags
+
+	// metrics flags
+	MetricsAddr string // address at which the daemon serves metrics
+	MetricsFile string // textfile collector output
+
+	// daemon flags
+	Schedule       string        // cron schedule
+	Interval       time.Duration // interval between runs
+	RandomInterval bool          // exponentially distributed intervals
+	Jitter         time.Duration // maximum random delay per run
+	RetainRuns     int           // runs to retain
+	RetainDays     int           // days to retain runs
+	StatusFile     string        // daemon status file
+	LockFile       string        // run lock file
+
+	// s3 flags
+	S3Endpoint string // custom endpoint of s3-compatible store
+	S3Region   string // region
+
+	// upload flags
+	UploadURL      string   // collector endpoint
+	UploadToken    string   // bearer token
+	UploadHeaders  []string // additional request headers
+	UploadMetadata bool     // upload metadata rather than archive
+	UploadRetries  int      // retries per file
+	SpoolDir       string   // local spool directory
+
+	// telemetry flags
+	InfluxURL   string   // influxdb write endpoint
+	InfluxToken string   // influxdb api token
+	OtlpURL     string   // otlp/http metrics endpoint
+	OtlpHeaders []string // additional otlp request headers
+
+	// discovery flags
+	NoDiscover  bool   // let the tool select its server
+	DiscoverURL string // base url of the server discovery service
+
+	// iperf flags
+	IperfParallel   int    // number of parallel streams
+	IperfReverse    bool   // server sends
+	IperfBidir      bool   // both directions simultaneously
+	IperfDuration   int    // test duration in seconds
+	IperfUDP        bool   // use udp
+	IperfBitrate    string // target bitrate per stream (K/M/G suffix)
+	IperfCongestion string // tcp congestion control algorithm
+
 	// other flags
 	help    bool
 	version bool
 
 	// internal config
+	Daemon bool // daemon subcommand
+
 	WorkDir     string
 	TempWorkDir string
 
 	Timestamp   time.Time
+	Tags        map[string]string
 	InterfaceIP []net.IP
 	ServerIP    net.IP
 
+	ServerID   string // discovered ookla server id
+	ServiceURL string // discovered ndt7 service url
+
+	ProbePayload []byte // probe payload
+	PingSocket   string // raw or datagram
+	NoCapture    bool   // whether capture is skipped, lacking permission
+
+	IperfAddr string // iperf server host:port
+	IperfRate uint64 // iperf target bits per second per stream
+
+	S3Bucket      string // s3 output bucket
+	S3KeyTemplate string // s3 output key, with placeholders
+
 	NAME    string
 	VERSION string
 )
 
 func Define() {
-	pflag.StringVarP(&Interface, "interface", "I", defaultInterface(), "Interface")
-	pflag.StringVarP(&Tool, "tool", "t", "ndt", "Speedtest tool to use: ndt, ookla or iperf")
+	pflag.StringVarP(&Interface, "interface", "I", DefaultInterface(), "Interface")
+	pflag.StringVarP(&Tool, "tool", "t", "ndt", "Speedtest tool to use: ndt, ookla, ookla-http or iperf")
 	pflag.StringVarP(&Server, "server", "s", "", "IP address and port (<ip>:<port>) for custom server. Optional. If not provided, will use default server.")
+	pflag.StringVarP(&Direction, "direction", "D", "both", "Test direction: download, upload or both")
+	pflag.IntVar(&IPVersion, "ip-version", 0, "IP version of the server (and pings): 4 or 6 [default: either]")
+	pflag.BoolVar(&DualStack, "dual-stack", false, "Run over IPv4, then IPv6, to the same server, and compare the bottlenecks [requires output directory]")
+	pflag.BoolVar(&NoDiscover, "no-discovery", false, "Skip server discovery and let the tool select its server")
+	pflag.StringVar(&DiscoverURL, "discovery-url", "", "Base URL of server discovery service [default: M-Lab Locate API for ndt, speedtest.net for ookla]")
 	pflag.BoolVarP(&NoPing, "no-ping", "n", false, "Skip pings")
 	pflag.StringVarP(&PingType, "ping-type", "p", "icmp", "Ping packet type: icmp or udp")
 	pflag.IntVarP(&MaxTTL, "max-ttl", "m", 5, "Maximum TTL until which to send pings")
-	pflag.IntVarP(&DirectHop, "direct-hop", "d", 1, "Hop to ping directly by icmp echo [0 to skip]")
+	pflag.IntVarP(&DirectHop, "direct-hop", "d", 1, "Hop to ping directly, by icmp echo or udp to a closed port [0 to skip]")
+	pflag.IntVar(&ProbeTOS, "probe-tos", 0, "ToS (traffic class) byte of pings, DSCP << 2 | ECN, e.g. 0xb8 for EF or 0x01 for ECT(1), against which to detect remarking on the path")
+	pflag.DurationVar(&ProbeInterval, "probe-interval", 100*time.Millisecond, "Interval between probes of a hop, e.g. 10ms or 500us")
+	pflag.BoolVar(&ProbePoisson, "probe-poisson", false, "Draw probe intervals from exponential distribution with mean probe interval (Poisson probing)")
+	pflag.IntVar(&ProbeSize, "probe-size", 0, "Probe payload size in bytes")
+	pflag.StringVar(&ProbePattern, "probe-pattern", "00", "Probe payload pattern: hex bytes, repeated, or \"random\"")
+	pflag.IntVar(&ProbeBudget, "probe-budget", 0, "Probes per second across hops, stretching the probe interval [0 for unlimited]")
+	pflag.StringVar(&LatencyFlow, "latency-flow", "", "Sparse latency flow to the server alongside the speedtest: tcp (handshake rtt) or udp (TWAMP-Light reflector) [default: none]")
+	pflag.IntVar(&LatencyFlowPort, "latency-flow-port", 0, "Server port of the latency flow [default: speedtest port for tcp, 862 for udp]")
 	pflag.BoolVarP(&TShark, "tshark", "T", false, "Use TShark")
+	pflag.IntVar(&PreIdle, "pre-idle", 0, "Pre speedtest idle time with pings running (in secs) [requires server or discovery]")
 	pflag.IntVarP(&IdleTime, "idle", "i", 10, "Post speedtest idle time (in secs)")
-	pflag.StringVarP(&OutPath, "out-path", "o", OutPath, "Output path [path with trailing slash for directory, file path for tar archive, \"-\" for stdout]")
+	pflag.StringVarP(&OutPath, "out-path", "o", OutPath, "Output path [path with trailing slash for directory, file path for tar archive, \"-\" for stdout, s3://bucket/prefix/ for object store]")
 	pflag.BoolVarP(&Terse, "terse-metadata", "r", false, "Terse rtt metadata")
+	pflag.StringSliceVarP(&Formats, "format", "f", nil, "Additional output formats: csv, ndjson, parquet, influx [comma-separated]")
+	pflag.StringArrayVar(&TagPairs, "tag", nil, "Tag \"key=value\" recorded with the run, e.g. test matrix parameters [repeatable]")
+	pflag.IntVarP(&IperfParallel, "parallel", "P", 1, "Number of parallel streams (iperf)")
+	pflag.BoolVarP(&IperfReverse, "reverse", "R", false, "Reverse mode: server sends (iperf)")
+	pflag.BoolVar(&IperfBidir, "bidir", false, "Bidirectional mode: client and server send (iperf)")
+	pflag.IntVar(&IperfDuration, "duration", 10, "Test duration in secs (iperf)")
+	pflag.BoolVarP(&IperfUDP, "udp", "u", false, "Use UDP rather than TCP (iperf)")
+	pflag.StringVarP(&IperfBitrate, "bitrate", "b", "", "Target bitrate per stream in bits/sec with optional K/M/G suffix (iperf) [default: unlimited TCP, 1M UDP]")
+	pflag.StringVarP(&IperfCongestion, "congestion", "C", "", "TCP congestion control algorithm (iperf)")
 	pflag.BoolVarP(&Quiet, "quiet", "q", false, "Minimize logging")
 	pflag.BoolVarP(&Force, "yes", "y", false, "Do not prompt for confirmation")
+	pflag.StringVar(&S3Endpoint, "s3-endpoint", "", "Endpoint URL of S3-compatible store [default: $AWS_ENDPOINT_URL, else AWS] (s3 output)")
+	pflag.StringVar(&S3Region, "s3-region", "", "Region [default: $AWS_REGION, else us-east-1] (s3 output)")
+	pflag.StringVar(&UploadURL, "upload-url", "", "HTTP(S) collector endpoint to which to POST results")
+	pflag.StringVar(&UploadToken, "upload-token", "", "Bearer token for collector [default: $TRACENECK_UPLOAD_TOKEN] (upload)")
+	pflag.StringArrayVar(&UploadHeaders, "upload-header", nil, "Additional request header \"Name: value\" (upload) [repeatable]")
+	pflag.BoolVar(&UploadMetadata, "upload-metadata", false, "Upload metadata JSON rather than archive (upload)")
+	pflag.IntVar(&UploadRetries, "upload-retries", 3, "Retries per file, with exponential backoff (upload)")
+	pflag.StringVar(&SpoolDir, "spool-dir", "", "Spool directory holding results until uploaded [default: user cache directory] (upload)")
+	pflag.StringVar(&MetricsFile, "metrics-file", "", "Prometheus textfile collector file (*.prom) to which to write run metrics")
+	pflag.StringVar(&MetricsAddr, "metrics-addr", "", "Address (<host>:<port>) at which to serve Prometheus metrics at /metrics (daemon)")
+	pflag.StringVar(&InfluxURL, "influx-url", "", "InfluxDB write endpoint to which to POST samples as line protocol, e.g. http://localhost:8086/api/v2/write?org=o&bucket=b&precision=ns")
+	pflag.StringVar(&InfluxToken, "influx-token", "", "InfluxDB API token [default: $INFLUX_TOKEN] (influx)")
+	pflag.StringVar(&OtlpURL, "otlp-url", "", "OTLP/HTTP metrics endpoint to which to POST samples, e.g. http://localhost:4318/v1/metrics")
+	pflag.StringArrayVar(&OtlpHeaders, "otlp-header", nil, "Additional request header \"Name: value\" (otlp) [repeatable]")
+	pflag.StringVar(&Schedule, "schedule", "", "Cron schedule of runs: minute hour day-of-month month day-of-week (daemon)")
+	pflag.DurationVar(&Interval, "interval", time.Hour, "Interval between runs, if not scheduled (daemon)")
+	pflag.BoolVar(&RandomInterval, "random-interval", false, "Draw intervals from exponential distribution with mean interval (daemon)")
+	pflag.DurationVar(&Jitter, "jitter", 0, "Maximum random delay added to each run (daemon)")
+	pflag.IntVar(&RetainRuns, "retain-runs", 0, "Number of runs to retain in output directory [0 for unlimited] (daemon)")
+	pflag.IntVar(&RetainDays, "retain-days", 0, "Days to retain runs in output directory [0 for unlimited] (daemon)")
+	pflag.StringVar(&StatusFile, "status-file", "", "Status file [default: status.json in output directory] (daemon)")
+	pflag.StringVar(&LockFile, "lock-file", "", "Lock file preventing overlapping runs [default in daemon: .traceneck.lock in output directory]")
 	pflag.BoolVarP(&help, "help", "h", false, "Show this help")
 	pflag.BoolVarP(&version, "version", "v", false, "Show version")
 
@@ -70,10 +198,15 @@ func Define() {
 }
 
 func Parse() error {
-	pflag.Parse()
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "daemon" {
+		Daemon = true
+		args = args[1:]
+	}
+	pflag.CommandLine.Parse(args)
 
 	if help {
-		fmt.Printf("\nUsage: %s [OPTIONS]\n\nOptions:\n", NAME)
+		fmt.Printf("\nUsage: %s [daemon] [OPTIONS]\n\nOptions:\n", NAME)
 		pflag.PrintDefaults()
 		os.Exit(0)
 	}
@@ -99,6 +232,135 @@ func Parse() error {
 	return nil
 }
 
+// daemonFlags: flags configuring the daemon rather than its runs
+var daemonFlags = []string{
+	"schedule", "interval", "random-interval", "jitter",
+	"retain-runs", "retain-days", "status-file", "lock-file", "out-path", "metrics-addr",
+}
+
+// dualStackFlags: flags configuring the dual-stack comparison rather than its runs, the
+// metrics file being written once of both runs
+var dualStackFlags = []string{"dual-stack", "ip-version", "out-path", "lock-file", "server", "metrics-file"}
+
+// sensitiveFlags: flags of credentials, redacted from recorded arguments
+var sensitiveFlags = []string{"upload-token", "influx-token", "upload-header", "otlp-header"}
+
+// urlFlags: flags of endpoints, whose userinfo and query values may carry credentials
+// (e.g. ?token=, ?u=&p=), redacted from recorded arguments
+var urlFlags = []string{"upload-url", "influx-url", "otlp-url"}
+
+const redactedValue = "<redacted>"
+
+// credentialFlags: flags of credentials passed to runs by environment rather than by
+// arguments, which other users may list
+var credentialFlags = []string{"upload-token", "influx-token"}
+
+// RunArgs: arguments for a single run, as configured for the daemon
+func RunArgs() []string {
+	return runArgs(slices.Concat(daemonFlags, credentialFlags), false)
+}
+
+// FamilyRunArgs: arguments for the run of either ip version, as configured for the
+// dual-stack comparison
+func FamilyRunArgs() []string {
+	return runArgs(slices.Concat(dualStackFlags, credentialFlags), false)
+}
+
+// RunEnv: environment of runs, carrying the credentials of credentialFlags in the
+// variables from which they are read by default
+func RunEnv() []string {
+	env := os.Environ()
+	if UploadToken != "" {
+		env = append(env, "TRACENECK_UPLOAD_TOKEN="+UploadToken)
+	}
+	if InfluxToken != "" {
+		env = append(env, "INFLUX_TOKEN="+InfluxToken)
+	}
+	return env
+}
+
+// RecordedArgs: arguments of this run, as recorded in its outputs, credentials redacted
+func RecordedArgs() []string {
+	return runArgs(daemonFlags, true)
+}
+
+// runArgs: arguments for a single run, as configured, less the excluded flags, and
+// credentials redacted where redact
+func runArgs(excluded []string, redact bool) []string {
+	args := []string{"--yes"}
+
+	pflag.Visit(func(flag *pflag.Flag) {
+		if slices.Contains(excluded, flag.Name) || flag.Name == "yes" {
+			return
+		}
+
+		values := []string{flag.Value.String()}
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			// repeat flag per value
+			values = slice.GetSlice()
+		}
+		for _, value := range values {
+			if redact && slices.Contains(sensitiveFlags, flag.Name) {
+				value = redacted(value)
+			} else if redact && slices.Contains(urlFlags, flag.Name) {
+				value = redactedURL(value)
+			}
+			args = append(args, "--"+flag.Name+"="+value)
+		}
+	})
+
+	return args
+}
+
+// redacted: value of a sensitive flag, less its credential, keeping header names
+func redacted(value string) string {
+	if name, _, ok := strings.Cut(value, ":"); ok {
+		return name + ": " + redactedValue
+	}
+	return redactedValue
+}
+
+// redactedURL: url less its userinfo and query values, keeping query keys [redacted
+// whole: not a url]
+func redactedURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil {
+		return redactedValue
+	}
+
+	var query []string
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if key, _, _ := strings.Cut(pair, "="); key != "" {
+			query = append(query, key+"="+redactedValue)
+		}
+	}
+	user := u.User != nil
+	u.User, u.RawQuery, u.Fragment, u.RawFragment = nil, "", "", ""
+
+	redacted := u.String()
+	if user {
+		redacted = strings.Replace(redacted, "//", "//"+redactedValue+"@", 1)
+	}
+	if len(query) > 0 {
+		redacted += "?" + strings.Join(query, "&")
+	}
+	return redacted
+}
+
+// S3Output: whether the archive is written to an object store
+func S3Output() bool {
+	return strings.HasPrefix(OutPath, "s3://")
+}
+
+// S3Key: object key, expanding {interface}, {tool} and {timestamp} placeholders
+func S3Key() string {
+	return strings.NewReplacer(
+		"{interface}", Interface,
+		"{tool}", Tool,
+		"{timestamp}", Timestamp.UTC().Format(timeUtil.RunIDFormat),
+	).Replace(S3KeyTemplate)
+}
+
 func ShouldArchive() bool {
 	return OutPath != WorkDir
 }
@@ -7,19 +7,37 @@
 package config
 
 import (
+	"cmp"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/google/gopacket/pcap"
+	"github.com/spf13/pflag"
 
+	"github.com/internet-equity/traceneck/internal/capability"
+	"github.com/internet-equity/traceneck/internal/daemon"
+	"github.com/internet-equity/traceneck/internal/iperf"
+	"github.com/internet-equity/traceneck/internal/s3"
 	osUtil "github.com/internet-equity/traceneck/internal/util/os"
 	"github.com/internet-equity/traceneck/internal/util/term"
 )
 
+// defaultS3Key: key template of s3 output to a prefix
+const defaultS3Key = "{interface}/{tool}/{timestamp}.tar.gz"
+
+// default latency flow ports
+const (
+	httpsPort = 443  // ndt7
+	ooklaPort = 8080 // ookla
+	twampPort = 862  // twamp-light reflector
+)
+
 // finishers: slice of configuration-checking closures
 //
 // closures return a ConfigFinish, such as a ConfigEval or Confirmation
@@ -34,7 +52,7 @@ var finishers = [...]func() ConfigFinish{
 	//
 	// OutPath: checkNakedOutPath: confirm ambiguous value missing both trailing slash and file extension
 	func() ConfigFinish {
-		if Force || OutPath == "-" || osUtil.PathDirectoryLike(OutPath) || filepath.Ext(OutPath) != "" || !term.IsTerm() {
+		if Force || OutPath == "-" || S3Output() || osUtil.PathDirectoryLike(OutPath) || filepath.Ext(OutPath) != "" || !term.IsTerm() {
 			return nil
 		}
 		return Confirmation{
@@ -79,14 +97,9 @@ var finishers = [...]func() ConfigFinish{
 			}
 		}
 
-		if handle, err := pcap.OpenLive(Interface, 0, false, 0); err == nil {
-			handle.Close()
-		} else {
-			return ConfigEval{
-				Label:  "interface",
-				Value:  Interface,
-				ErrorM: "requires capture permission",
-			}
+		// tshark captures by its own (dumpcap) permission
+		if !TShark && capability.Capture(Interface) != nil {
+			NoCapture = true
 		}
 
 		addrs, err := iface.Addrs()
@@ -105,6 +118,9 @@ var finishers = [...]func() ConfigFinish{
 			}
 		}
 
+		if NoCapture {
+			return ConfigEval{Label: "interface", Value: Interface + " (no capture permission: capture skipped)"}
+		}
 		return ConfigEval{Label: "interface", Value: Interface}
 	},
 
@@ -117,6 +133,16 @@ var finishers = [...]func() ConfigFinish{
 			cmd = exec.Command("speedtest", "--version")
 		} else if Tool == "ookla-http" {
 			cmd = exec.Command("tools/ookla-http/speedtest.py", "--version")
+		} else if Tool == "iperf" {
+			// native client: nothing to install
+			if Server == "" {
+				return ConfigEval{
+					Label:  "tool",
+					Value:  Tool,
+					ErrorM: "requires server",
+				}
+			}
+			return ConfigEval{Label: "tool", Value: Tool}
 		} else {
 			return ConfigEval{
 				Label:  "tool",
@@ -136,6 +162,155 @@ var finishers = [...]func() ConfigFinish{
 		return ConfigEval{Label: "tool", Value: Tool}
 	},
 
+	// Direction: checkDirection: validate direction and tool support
+	func() ConfigFinish {
+		if IperfReverse {
+			// reverse is the iperf spelling of a download-only test
+			if pflag.Lookup("direction").Changed && Direction != "download" {
+				return ConfigEval{
+					Label:  "direction",
+					Value:  Direction,
+					ErrorM: "conflicts with reverse",
+				}
+			}
+			Direction = "download"
+		}
+
+		if Direction != "download" && Direction != "upload" && Direction != "both" {
+			return ConfigEval{
+				Label:  "direction",
+				Value:  Direction,
+				ErrorM: "invalid direction",
+			}
+		}
+
+		// ookla-http falls back to ookla without a (discovered) server
+		if Direction != "both" && (Tool == "ookla" || (Tool == "ookla-http" && Server == "" && NoDiscover)) {
+			return ConfigEval{
+				Label:  "direction",
+				Value:  Direction,
+				ErrorM: "not supported by tool: " + Tool,
+			}
+		}
+
+		if Direction != "both" && IperfBidir {
+			return ConfigEval{
+				Label:  "direction",
+				Value:  Direction,
+				ErrorM: "conflicts with bidir",
+			}
+		}
+
+		return ConfigEval{Label: "direction", Value: Direction}
+	},
+
+	// IPVersion: checkIPVersion
+	func() ConfigFinish {
+		switch IPVersion {
+		case 0:
+			return ConfigEval{Label: "ip version", Value: "either"}
+		case 4, 6:
+			if Tool == "ndt" {
+				// ndt7-client resolves the service url itself, of either version
+				return ConfigEval{
+					Label:  "ip version",
+					Value:  strconv.Itoa(IPVersion),
+					ErrorM: "excludes ndt, whose client is not bound to an ip version",
+				}
+			}
+			if InterfaceIPOf(IPVersion) == nil {
+				return ConfigEval{
+					Label:  "ip version",
+					Value:  strconv.Itoa(IPVersion),
+					ErrorM: "no global address on interface",
+				}
+			}
+			return ConfigEval{Label: "ip version", Value: strconv.Itoa(IPVersion)}
+		default:
+			return ConfigEval{
+				Label:  "ip version",
+				Value:  strconv.Itoa(IPVersion),
+				ErrorM: "invalid ip version",
+			}
+		}
+	},
+
+	// DualStack: checkDualStack
+	func() ConfigFinish {
+		if !DualStack {
+			return nil
+		}
+
+		if IPVersion != 0 {
+			return ConfigEval{Label: "dual stack", Value: "ipv4, ipv6", ErrorM: "excludes --ip-version"}
+		}
+		if Tool == "ndt" {
+			return ConfigEval{Label: "dual stack", Value: "ipv4, ipv6", ErrorM: "excludes ndt, whose client is not bound to an ip version"}
+		}
+		if !osUtil.PathDirectoryLike(OutPath) || S3Output() {
+			return ConfigEval{Label: "dual stack", Value: "ipv4, ipv6", ErrorM: "requires output directory (path with trailing slash)"}
+		}
+		if InterfaceIPOf(4) == nil || InterfaceIPOf(6) == nil {
+			return ConfigEval{Label: "dual stack", Value: "ipv4, ipv6", ErrorM: "requires global ipv4 and ipv6 addresses on interface"}
+		}
+
+		return ConfigEval{Label: "dual stack", Value: "ipv4, ipv6"}
+	},
+
+	// DiscoverURL: checkDiscoverURL
+	func() ConfigFinish {
+		if NoDiscover || Server != "" {
+			return nil
+		}
+
+		if DiscoverURL != "" {
+			if u, err := url.Parse(DiscoverURL); err != nil || u.Scheme == "" || u.Host == "" {
+				return ConfigEval{
+					Label:  "discovery url",
+					Value:  DiscoverURL,
+					ErrorM: "invalid url",
+				}
+			}
+		}
+
+		return ConfigEval{Label: "discovery url", Value: DiscoverURL}
+	},
+
+	// Iperf: checkIperf: validate iperf options and set IperfAddr and IperfRate
+	func() ConfigFinish {
+		if Tool != "iperf" {
+			return nil
+		}
+
+		label := "iperf"
+		value := fmt.Sprintf("parallel=%d reverse=%t bidir=%t duration=%d udp=%t bitrate=%q congestion=%q",
+			IperfParallel, IperfReverse, IperfBidir, IperfDuration, IperfUDP, IperfBitrate, IperfCongestion)
+
+		if IperfParallel < 1 || IperfParallel > 128 {
+			return ConfigEval{Label: label, Value: value, ErrorM: "parallel streams not in range [1, 128]"}
+		}
+		if IperfDuration < 1 {
+			return ConfigEval{Label: label, Value: value, ErrorM: "duration must be positive"}
+		}
+		if IperfUDP && IperfCongestion != "" {
+			return ConfigEval{Label: label, Value: value, ErrorM: "congestion control requires tcp"}
+		}
+
+		rate, err := parseBitrate(IperfBitrate)
+		if err != nil {
+			return ConfigEval{Label: label, Value: value, ErrorM: err.Error()}
+		}
+		IperfRate = rate
+
+		if _, _, err := net.SplitHostPort(Server); err == nil {
+			IperfAddr = Server
+		} else {
+			IperfAddr = net.JoinHostPort(Server, strconv.Itoa(iperf.DefaultPort))
+		}
+
+		return ConfigEval{Label: label, Value: value}
+	},
+
 	// PingType: checkPingType
 	func() ConfigFinish {
 		if PingType != "icmp" && PingType != "udp" {
@@ -149,6 +324,40 @@ var finishers = [...]func() ConfigFinish{
 		return ConfigEval{Label: "ping type", Value: PingType}
 	},
 
+	// PingSocket: checkPingSocket: raw sockets where permitted, else unprivileged icmp
+	// datagram sockets, without the features of raw sockets
+	func() ConfigFinish {
+		if NoPing {
+			return nil
+		}
+
+		ipv6 := IPVersion == 6
+		if capability.RawSockets(ipv6) == nil {
+			PingSocket = "raw"
+			return ConfigEval{Label: "ping socket", Value: PingSocket}
+		}
+		if capability.DatagramSockets(ipv6) != nil {
+			return ConfigEval{
+				Label:  "ping socket",
+				Value:  "none",
+				ErrorM: "requires CAP_NET_RAW or a group of net.ipv4.ping_group_range (see doctor)",
+			}
+		}
+		if PingType == "udp" {
+			return ConfigEval{
+				Label:  "ping socket",
+				Value:  "datagram",
+				ErrorM: "udp pings require raw sockets (CAP_NET_RAW)",
+			}
+		}
+
+		PingSocket = "datagram"
+		return ConfigEval{
+			Label: "ping socket",
+			Value: PingSocket + " (unprivileged: no reply tos, ip id, quoted headers, icmp extensions nor kernel send timestamps)",
+		}
+	},
+
 	// MaxTTL: log only
 	func() ConfigFinish {
 		return ConfigEval{Label: "max ttl", Value: strconv.Itoa(MaxTTL)}
@@ -167,8 +376,130 @@ var finishers = [...]func() ConfigFinish{
 		return ConfigEval{Label: "direct hop", Value: strconv.Itoa(DirectHop)}
 	},
 
+	// ProbeTOS: checkProbeTOS
+	func() ConfigFinish {
+		if ProbeTOS == 0 {
+			return nil
+		}
+
+		value := fmt.Sprintf("%#04x", ProbeTOS)
+		if ProbeTOS < 0 || ProbeTOS > 0xff {
+			return ConfigEval{Label: "probe tos", Value: strconv.Itoa(ProbeTOS), ErrorM: "not in range [0, 0xff]"}
+		}
+
+		return ConfigEval{Label: "probe tos", Value: value}
+	},
+
+	// ProbeInterval: checkProbes: validate probe schedule and payload, stretch the interval to
+	// the probe budget and generate the payload
+	func() ConfigFinish {
+		if NoPing {
+			return nil
+		}
+
+		value := "every " + ProbeInterval.String()
+		if ProbePoisson {
+			value = "random, mean " + ProbeInterval.String()
+		}
+
+		if ProbeInterval <= 0 {
+			return ConfigEval{Label: "probes", Value: value, ErrorM: "interval must be positive"}
+		}
+		if ProbeBudget < 0 {
+			return ConfigEval{Label: "probes", Value: value, ErrorM: "budget must not be negative"}
+		}
+		if ProbeSize < 0 || ProbeSize > maxProbeSize {
+			return ConfigEval{Label: "probes", Value: value, ErrorM: fmt.Sprintf("size not in range [0, %d]", maxProbeSize)}
+		}
+
+		payload, err := probePayload(ProbeSize, ProbePattern)
+		if err != nil {
+			return ConfigEval{Label: "probes", Value: value, ErrorM: "invalid pattern: " + err.Error()}
+		}
+		ProbePayload = payload
+
+		if ProbeBudget > 0 {
+			senders := MaxTTL
+			if DirectHop != 0 {
+				senders++
+			}
+			if minimum := time.Duration(senders) * time.Second / time.Duration(ProbeBudget); ProbeInterval < minimum {
+				ProbeInterval = minimum
+				value += fmt.Sprintf(", stretched to %s by budget of %d/s", ProbeInterval, ProbeBudget)
+			}
+		}
+		if ProbeSize > 0 {
+			value += fmt.Sprintf(", %d bytes", ProbeSize)
+		}
+
+		return ConfigEval{Label: "probes", Value: value}
+	},
+
+	// LatencyFlow: checkLatencyFlow: validate protocol and set default port
+	func() ConfigFinish {
+		if LatencyFlow == "" {
+			return nil
+		}
+
+		if LatencyFlow != "tcp" && LatencyFlow != "udp" {
+			return ConfigEval{Label: "latency flow", Value: LatencyFlow, ErrorM: "invalid protocol"}
+		}
+		if LatencyFlowPort < 0 || LatencyFlowPort > 65535 {
+			return ConfigEval{Label: "latency flow", Value: LatencyFlow, ErrorM: "port not in range [1, 65535]"}
+		}
+
+		if LatencyFlowPort == 0 {
+			LatencyFlowPort = defaultFlowPort()
+		}
+
+		return ConfigEval{Label: "latency flow", Value: LatencyFlow + " port " + strconv.Itoa(LatencyFlowPort)}
+	},
+
+	// OutPath: checkS3OutPath: parse bucket and key template and check credentials
+	func() ConfigFinish {
+		if !S3Output() {
+			return nil
+		}
+
+		bucket, key, err := s3.ParseURL(OutPath)
+		if err != nil {
+			return ConfigEval{Label: "output path", Value: OutPath, ErrorM: err.Error()}
+		}
+		if key == "" || strings.HasSuffix(key, "/") {
+			key += defaultS3Key
+		}
+		S3Bucket, S3KeyTemplate = bucket, key
+
+		if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+			return ConfigEval{
+				Label:  "output path",
+				Value:  OutPath,
+				ErrorM: "requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY",
+			}
+		}
+
+		if S3Endpoint == "" {
+			S3Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+		}
+		if S3Endpoint != "" {
+			if u, err := url.Parse(S3Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+				return ConfigEval{Label: "output path", Value: OutPath, ErrorM: "invalid endpoint: " + S3Endpoint}
+			}
+		}
+
+		if S3Region == "" {
+			S3Region = cmp.Or(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), s3.DefaultRegion)
+		}
+
+		return ConfigEval{Label: "output path", Value: "s3://" + S3Bucket + "/" + S3KeyTemplate}
+	},
+
 	// OutPath: checkOutPath: if not directory-like, nor stdout, ensure can open it for writing
 	func() ConfigFinish {
+		if S3Output() {
+			return nil
+		}
+
 		if OutPath != "-" && !osUtil.PathDirectoryLike(OutPath) {
 			outDir := filepath.Dir(OutPath)
 
@@ -196,7 +527,7 @@ var finishers = [...]func() ConfigFinish{
 
 	// WorkDir: checkWorkDir: establish dir path and ensure writeable
 	func() ConfigFinish {
-		if osUtil.PathDirectoryLike(OutPath) {
+		if osUtil.PathDirectoryLike(OutPath) && !S3Output() {
 			// working directory can be output directory
 			WorkDir = OutPath
 		} else {
@@ -232,6 +563,69 @@ var finishers = [...]func() ConfigFinish{
 		return ConfigEval{Label: "working dir", Value: WorkDir}
 	},
 
+	// Formats: checkFormats
+	func() ConfigFinish {
+		for _, format := range Formats {
+			if format != "csv" && format != "ndjson" && format != "parquet" && format != "influx" {
+				return ConfigEval{
+					Label:  "formats",
+					Value:  strings.Join(Formats, ","),
+					ErrorM: "invalid format: " + format,
+				}
+			}
+		}
+
+		return ConfigEval{Label: "formats", Value: strings.Join(append([]string{"json"}, Formats...), ",")}
+	},
+
+	// Tags: checkTags: parse key=value pairs into Tags
+	func() ConfigFinish {
+		if len(TagPairs) == 0 {
+			return nil
+		}
+
+		Tags = make(map[string]string, len(TagPairs))
+		for _, pair := range TagPairs {
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok || key == "" {
+				return ConfigEval{
+					Label:  "tags",
+					Value:  strings.Join(TagPairs, ","),
+					ErrorM: "invalid tag: " + strconv.Quote(pair),
+				}
+			}
+			Tags[key] = value
+		}
+
+		return ConfigEval{Label: "tags", Value: strings.Join(TagPairs, ",")}
+	},
+
+	// Metrics: checkMetrics: textfile collector file and daemon listen address
+	func() ConfigFinish {
+		if MetricsAddr != "" {
+			if !Daemon {
+				return ConfigEval{Label: "metrics addr", Value: MetricsAddr, ErrorM: "requires daemon"}
+			}
+			if _, _, err := net.SplitHostPort(MetricsAddr); err != nil {
+				return ConfigEval{Label: "metrics addr", Value: MetricsAddr, ErrorM: "invalid address: " + err.Error()}
+			}
+		}
+
+		if MetricsFile != "" {
+			if !strings.HasSuffix(MetricsFile, ".prom") {
+				return ConfigEval{Label: "metrics file", Value: MetricsFile, ErrorM: "requires .prom extension"}
+			}
+			if err := osUtil.DirWriteable(filepath.Dir(MetricsFile)); err != nil {
+				return ConfigEval{Label: "metrics file", Value: MetricsFile, ErrorM: "directory requires write access"}
+			}
+		}
+
+		if MetricsAddr == "" && MetricsFile == "" {
+			return nil
+		}
+		return ConfigEval{Label: "metrics", Value: strings.Trim(MetricsAddr+" "+MetricsFile, " ")}
+	},
+
 	// TShark: checkTshark
 	func() ConfigFinish {
 		if TShark && exec.Command("tshark", "--version").Run() != nil {
@@ -245,8 +639,167 @@ var finishers = [...]func() ConfigFinish{
 		return ConfigEval{Label: "tshark", Value: strconv.FormatBool(TShark)}
 	},
 
+	// PreIdle: checkPreIdle: baseline pings require the server up front
+	func() ConfigFinish {
+		if PreIdle < 0 {
+			return ConfigEval{
+				Label:  "pre idle time",
+				Value:  strconv.Itoa(PreIdle),
+				ErrorM: "must not be negative",
+			}
+		}
+		if PreIdle > 0 && Server == "" && NoDiscover {
+			return ConfigEval{
+				Label:  "pre idle time",
+				Value:  strconv.Itoa(PreIdle),
+				ErrorM: "requires server or discovery",
+			}
+		}
+		if PreIdle > 0 && NoPing {
+			return ConfigEval{
+				Label:  "pre idle time",
+				Value:  strconv.Itoa(PreIdle),
+				ErrorM: "requires pings",
+			}
+		}
+
+		return ConfigEval{Label: "pre idle time", Value: strconv.Itoa(PreIdle)}
+	},
+
 	// IdleTime: log only
 	func() ConfigFinish {
 		return ConfigEval{Label: "idle time", Value: strconv.Itoa(IdleTime)}
 	},
+
+	// Upload: checkUpload: validate collector and set up spool directory
+	func() ConfigFinish {
+		if UploadURL == "" {
+			return nil
+		}
+
+		if u, err := url.Parse(UploadURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return ConfigEval{Label: "upload url", Value: UploadURL, ErrorM: "invalid http(s) url"}
+		}
+		for _, field := range UploadHeaders {
+			if key, _, ok := strings.Cut(field, ":"); !ok || strings.TrimSpace(key) == "" {
+				return ConfigEval{Label: "upload url", Value: UploadURL, ErrorM: "invalid header: " + strconv.Quote(field)}
+			}
+		}
+		if UploadRetries < 0 {
+			return ConfigEval{Label: "upload url", Value: UploadURL, ErrorM: "retries must not be negative"}
+		}
+
+		if UploadToken == "" {
+			UploadToken = os.Getenv("TRACENECK_UPLOAD_TOKEN")
+		}
+
+		if SpoolDir == "" {
+			cacheDir, err := os.UserCacheDir()
+			if err != nil {
+				return ConfigEval{Label: "upload url", Value: UploadURL, ErrorM: "spool directory: " + err.Error()}
+			}
+			SpoolDir = filepath.Join(cacheDir, "traceneck", "spool")
+		}
+		if err := osUtil.DirAvail(SpoolDir); err != nil {
+			return ConfigEval{Label: "upload url", Value: UploadURL, ErrorM: "spool directory: " + err.Error()}
+		}
+		if err := osUtil.DirWriteable(SpoolDir); err != nil {
+			return ConfigEval{Label: "upload url", Value: UploadURL, ErrorM: "spool directory requires write access"}
+		}
+
+		return ConfigEval{Label: "upload url", Value: UploadURL + " (spool: " + SpoolDir + ")"}
+	},
+
+	// Telemetry: checkTelemetry: validate influxdb and otlp endpoints
+	func() ConfigFinish {
+		if InfluxURL == "" && OtlpURL == "" {
+			return nil
+		}
+
+		var endpoints []string
+		for _, endpoint := range []string{InfluxURL, OtlpURL} {
+			if endpoint == "" {
+				continue
+			}
+			if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return ConfigEval{Label: "telemetry", Value: endpoint, ErrorM: "invalid http(s) url"}
+			}
+			endpoints = append(endpoints, endpoint)
+		}
+		for _, field := range OtlpHeaders {
+			if key, _, ok := strings.Cut(field, ":"); !ok || strings.TrimSpace(key) == "" {
+				return ConfigEval{Label: "telemetry", Value: OtlpURL, ErrorM: "invalid header: " + strconv.Quote(field)}
+			}
+		}
+
+		if InfluxToken == "" {
+			InfluxToken = os.Getenv("INFLUX_TOKEN")
+		}
+
+		return ConfigEval{Label: "telemetry", Value: strings.Join(endpoints, ",")}
+	},
+
+	// Daemon: checkDaemon: validate scheduling and retention, and set daemon file defaults
+	func() ConfigFinish {
+		if !Daemon {
+			return nil
+		}
+
+		value := "every " + Interval.String()
+		if Schedule != "" {
+			value = "schedule " + strconv.Quote(Schedule)
+		} else if RandomInterval {
+			value = "random, mean " + Interval.String()
+		}
+
+		if !osUtil.PathDirectoryLike(OutPath) || S3Output() {
+			return ConfigEval{Label: "daemon", Value: value, ErrorM: "requires output directory (path with trailing slash)"}
+		}
+		if Schedule != "" {
+			if _, err := daemon.ParseCron(Schedule); err != nil {
+				return ConfigEval{Label: "daemon", Value: value, ErrorM: "invalid schedule: " + err.Error()}
+			}
+		} else if Interval <= 0 {
+			return ConfigEval{Label: "daemon", Value: value, ErrorM: "interval must be positive"}
+		}
+		if Jitter < 0 {
+			return ConfigEval{Label: "daemon", Value: value, ErrorM: "jitter must not be negative"}
+		}
+		if RetainRuns < 0 || RetainDays < 0 {
+			return ConfigEval{Label: "daemon", Value: value, ErrorM: "retention must not be negative"}
+		}
+
+		if StatusFile == "" {
+			StatusFile = filepath.Join(OutPath, "status.json")
+		}
+		if LockFile == "" {
+			LockFile = filepath.Join(OutPath, ".traceneck.lock")
+		}
+
+		return ConfigEval{Label: "daemon", Value: value}
+	},
+}
+
+// defaultFlowPort: port of the latency flow, that of the speedtest server for tcp
+func defaultFlowPort() int {
+	if LatencyFlow == "udp" {
+		return twampPort
+	}
+
+	addr := Server
+	if Tool == "iperf" {
+		addr = IperfAddr
+	}
+	if _, port, err := net.SplitHostPort(addr); err == nil {
+		if p, err := strconv.Atoi(port); err == nil {
+			return p
+		}
+	}
+
+	switch Tool {
+	case "ookla", "ookla-http":
+		return ooklaPort
+	default:
+		return httpsPort
+	}
 }
@@ -2,22 +2,31 @@ package config
 
 import (
 	"bufio"
+	"net"
 	"os"
+	"strconv"
 	"strings"
 )
 
 const (
-	procNetRoutePath = "/proc/net/route"
-	zeros            = "00000000"
+	procNetRoutePath     = "/proc/net/route"
+	procNetIPv6RoutePath = "/proc/net/ipv6_route"
+	zeros                = "00000000"
+	zeros6               = "00000000000000000000000000000000"
+
+	// ipv6 route flags
+	rtfUp     = 0x0001
+	rtfReject = 0x0200
 )
 
-func readNetRoute() <-chan []string {
+// readNetRoute: fields of the routes of the routing table at path
+func readNetRoute(path string) <-chan []string {
 	ch := make(chan []string)
 
 	go func() {
 		defer close(ch)
 
-		f, err := os.Open(procNetRoutePath)
+		f, err := os.Open(path)
 		if err != nil {
 			return
 		}
@@ -35,12 +44,35 @@ func readNetRoute() <-chan []string {
 	return ch
 }
 
-func defaultInterface() string {
-	for route := range readNetRoute() {
+// DefaultInterface: interface of the ipv4 default route, else of the ipv6 default route
+func DefaultInterface() string {
+	for route := range readNetRoute(procNetRoutePath) {
 		if route[1] == zeros && route[7] == zeros {
 			return route[0]
 		}
 	}
 
+	// destination, prefix length, source, source prefix length, next hop, metric,
+	// reference count, use count, flags, interface
+	for route := range readNetRoute(procNetIPv6RoutePath) {
+		if len(route) < 10 || route[0] != zeros6 || route[1] != "00" {
+			continue
+		}
+		if flags, err := strconv.ParseUint(route[8], 16, 32); err == nil && flags&rtfUp != 0 && flags&rtfReject == 0 {
+			return route[9]
+		}
+	}
+
 	return ""
 }
+
+// InterfaceIPOf: global unicast address of the interface of ip version 4 or 6
+func InterfaceIPOf(version int) net.IP {
+	for _, ip := range InterfaceIP {
+		if ip.IsGlobalUnicast() && (version == 4) == (ip.To4() != nil) {
+			return ip
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+)
+
+// maxProbeSize: largest probe payload within an ethernet mtu (ipv6 and udp or icmp headers)
+const maxProbeSize = 1500 - 40 - 8
+
+// probePayload: payload of size bytes, of pattern repeated, or random where "random"
+func probePayload(size int, pattern string) ([]byte, error) {
+	if pattern == "random" {
+		payload := make([]byte, size)
+		rand.Read(payload)
+		return payload, nil
+	}
+
+	unit, err := hex.DecodeString(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(unit) == 0 {
+		return nil, errors.New("empty pattern")
+	}
+
+	return bytes.Repeat(unit, size/len(unit)+1)[:size], nil
+}
@@ -0,0 +1,150 @@
+/*
+ * cron: five-field cron schedules
+ *
+ * minute hour day-of-month month day-of-week, each field a list of values, ranges
+ * and steps (e.g. "5", "0-30/10", "1,3,5" or a step over "*"); as with cron, when both
+ * day fields are restricted a day matching either is scheduled
+ *
+ */
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type cronField struct {
+	min, max int
+}
+
+var cronFields = [5]cronField{
+	{0, 59}, // minute
+	{0, 23}, // hour
+	{1, 31}, // day of month
+	{1, 12}, // month
+	{0, 7},  // day of week (0 and 7 are sunday)
+}
+
+// Cron: parsed schedule as a set of allowed values per field
+type Cron struct {
+	minute, hour, dom, month, dow [64]bool
+
+	domAny, dowAny bool
+}
+
+// ParseCron: parse a five-field cron expression
+func ParseCron(expr string) (*Cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("expected 5 fields: minute hour day-of-month month day-of-week")
+	}
+
+	cron := &Cron{
+		domAny: strings.HasPrefix(fields[2], "*"),
+		dowAny: strings.HasPrefix(fields[4], "*"),
+	}
+	sets := [5]*[64]bool{&cron.minute, &cron.hour, &cron.dom, &cron.month, &cron.dow}
+
+	for i, field := range fields {
+		if err := parseCronField(field, cronFields[i], sets[i]); err != nil {
+			return nil, fmt.Errorf("field %d (%s): %w", i+1, field, err)
+		}
+	}
+
+	// sunday may be written as 7
+	if cron.dow[7] {
+		cron.dow[0] = true
+	}
+
+	return cron, nil
+}
+
+func parseCronField(field string, bounds cronField, set *[64]bool) error {
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return errors.New("invalid step")
+			}
+		}
+
+		lo, hi := bounds.min, bounds.max
+		if rangePart != "*" {
+			loPart, hiPart, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+			if lo, err = strconv.Atoi(loPart); err != nil {
+				return errors.New("invalid value")
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiPart); err != nil {
+					return errors.New("invalid value")
+				}
+			} else if hasStep {
+				// "a/n": from a to the end of the range
+				hi = bounds.max
+			}
+		}
+
+		if lo < bounds.min || hi > bounds.max || lo > hi {
+			return fmt.Errorf("not in range [%d, %d]", bounds.min, bounds.max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+
+	return nil
+}
+
+// dayMatches: whether t is a scheduled day
+//
+// as with cron, where either day field starts with "*" (including steps over it), days
+// must match both fields.
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom[t.Day()]
+	dow := c.dow[int(t.Weekday())]
+
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next: first scheduled minute strictly after t
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	// bounded search: any valid schedule matches within a few years
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !c.month[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.hour[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minute[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
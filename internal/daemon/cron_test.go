@@ -0,0 +1,87 @@
+package daemon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",       // too few fields
+		"* * * * * *",   // too many fields
+		"60 * * * *",    // minute out of range
+		"* 24 * * *",    // hour out of range
+		"* * 0 * *",     // day of month out of range
+		"* * * 13 *",    // month out of range
+		"* * * * 8",     // day of week out of range
+		"*/0 * * * *",   // zero step
+		"*/x * * * *",   // invalid step
+		"30-10 * * * *", // reversed range
+		"a * * * *",     // invalid value
+		"1-b * * * *",   // invalid range end
+		"1,,2 * * * *",  // empty list element
+	} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%q: want error", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		parsed, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		expr string
+		from string
+		want string
+	}{
+		{"* * * * *", "2024-01-01 00:00:00", "2024-01-01 00:01:00"},
+		{"* * * * *", "2024-01-01 00:00:59", "2024-01-01 00:01:00"},
+		{"*/30 * * * *", "2024-01-01 00:00:00", "2024-01-01 00:30:00"},
+		{"*/30 * * * *", "2024-01-01 00:30:00", "2024-01-01 01:00:00"},
+		{"0-30/10 * * * *", "2024-01-01 00:31:00", "2024-01-01 01:00:00"},
+		{"5/20 * * * *", "2024-01-01 00:26:00", "2024-01-01 00:45:00"},
+		{"15 9,17 * * *", "2024-01-01 09:15:00", "2024-01-01 17:15:00"},
+		{"0 0 * * *", "2024-12-31 23:59:00", "2025-01-01 00:00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"0 12 * * 1-5", "2024-01-05 12:00:00", "2024-01-08 12:00:00"}, // friday to monday
+		{"0 0 * * 7", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},    // sunday as 7
+		{"0 0 * * 0", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+		// either day field matches where both are restricted
+		{"0 0 13 * 5", "2024-01-01 00:00:00", "2024-01-05 00:00:00"},
+		{"0 0 13 * 5", "2024-01-05 00:00:00", "2024-01-12 00:00:00"},
+		{"0 0 13 * 5", "2024-01-12 00:00:00", "2024-01-13 00:00:00"},
+		// as in cron, a day field stepped over "*" combines with the other by both
+		{"0 0 */10 * 1", "2024-01-01 00:00:00", "2024-03-11 00:00:00"},
+		{"0 0 * * */2", "2024-01-01 00:00:00", "2024-01-02 00:00:00"},
+		{"0 0 1 1 *", "2024-06-15 10:00:00", "2025-01-01 00:00:00"},
+	}
+
+	for _, test := range tests {
+		cron, err := ParseCron(test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		if got := cron.Next(at(test.from)); !got.Equal(at(test.want)) {
+			t.Errorf("%q after %s: got %s, want %s", test.expr, test.from, got, test.want)
+		}
+	}
+}
+
+func TestCronNextNever(t *testing.T) {
+	cron, err := ParseCron("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cron.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("got %s, want zero time", got)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * daemon: repeated, scheduled measurement runs
+ *
+ * each run re-executes traceneck as a child process with a dedicated output directory,
+ * such that runs share no state; runs are serialized by a file lock, outputs are pruned
+ * according to retention limits and a status file reports daemon health
+ *
+ */
+package daemon
+
+import (
+	"log"
+	"math/rand/v2"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	timeUtil "github.com/internet-equity/traceneck/internal/util/time"
+)
+
+// Options: daemon configuration
+type Options struct {
+	Args           []string      // arguments for each run (excluding output path)
+	Env            []string      // environment of each run [nil: the daemon's]
+	OutDir         string        // directory under which per-run outputs are written
+	Schedule       *Cron         // cron schedule [nil for interval scheduling]
+	Interval       time.Duration // interval between runs
+	RandomInterval bool          // draw intervals from an exponential distribution
+	Jitter         time.Duration // maximum random delay added to each run
+	RetainRuns     int           // runs to retain [0: unlimited]
+	RetainDays     int           // days to retain runs [0: unlimited]
+	StatusFile     string        // status file path
+	LockFile       string        // lock file path
+	Version        string        // reported in status
+
+	Observe func(status *Status) // called on every status change [optional]
+}
+
+// Run: schedule runs until interrupted
+func Run(opts Options) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	status := &Status{
+		PID:     os.Getpid(),
+		Version: opts.Version,
+		Started: time.Now(),
+	}
+
+	log.Println("[daemon] started")
+
+	base := time.Now()
+	for {
+		next := opts.next(base)
+		base = next
+
+		// a run overrunning its successor's slot skips ahead
+		if now := time.Now(); next.Before(now) {
+			status.RunsSkipped++
+			log.Println("[daemon] skipped run scheduled during previous run:", next.Format(time.RFC3339))
+			base = now
+			continue
+		}
+
+		runAt := next.Add(opts.jitter())
+
+		status.State = "waiting"
+		status.NextRun = &runAt
+		opts.writeStatus(status)
+		log.Println("[daemon] next run:", runAt.Format(time.RFC3339))
+
+		select {
+		case <-time.After(time.Until(runAt)):
+		case sig := <-signals:
+			log.Println("[daemon] stopping:", sig)
+
+			status.State = "stopped"
+			status.NextRun = nil
+			opts.writeStatus(status)
+			return nil
+		}
+
+		status.State = "running"
+		status.NextRun = nil
+		opts.writeStatus(status)
+
+		opts.run(exe, status)
+		prune(opts.OutDir, opts.RetainRuns, opts.RetainDays)
+	}
+}
+
+// next: next scheduled time after base
+func (opts Options) next(base time.Time) time.Time {
+	if opts.Schedule != nil {
+		return opts.Schedule.Next(base)
+	}
+
+	interval := opts.Interval
+	if opts.RandomInterval {
+		interval = time.Duration(rand.ExpFloat64() * float64(opts.Interval))
+	}
+
+	return base.Add(interval)
+}
+
+func (opts Options) jitter() time.Duration {
+	if opts.Jitter <= 0 {
+		return 0
+	}
+	return rand.N(opts.Jitter)
+}
+
+// run: execute a single run under the lock
+func (opts Options) run(exe string, status *Status) {
+	lock, err := TryLock(opts.LockFile)
+	if err != nil {
+		status.RunsSkipped++
+		log.Println("[daemon] skipped run:", err)
+		return
+	}
+	defer lock.Unlock()
+
+	start := time.Now()
+	id := start.UTC().Format(timeUtil.RunIDFormat)
+
+	run := &RunStatus{
+		ID:     id,
+		Output: filepath.Join(opts.OutDir, id) + string(os.PathSeparator),
+		Start:  start,
+	}
+
+	log.Println("[daemon] run started:", id)
+
+	cmd := exec.Command(exe, append(opts.Args, "--out-path", run.Output)...)
+	cmd.Env = opts.Env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+
+	run.End = time.Now()
+	run.ExitCode = cmd.ProcessState.ExitCode()
+	if err != nil {
+		run.Error = err.Error()
+		status.RunsFailed++
+		log.Println("[daemon] run failed:", id, err)
+	} else {
+		log.Println("[daemon] run complete:", id)
+	}
+
+	status.RunsTotal++
+	status.LastRun = run
+}
+
+func (opts Options) writeStatus(status *Status) {
+	if err := status.write(opts.StatusFile); err != nil {
+		log.Println("[daemon] error writing status:", err)
+	}
+
+	if opts.Observe != nil {
+		opts.Observe(status)
+	}
+}
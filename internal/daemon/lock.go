@@ -0,0 +1,43 @@
+package daemon
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+var ErrLocked = errors.New("another run holds the lock")
+
+// Lock: exclusive advisory lock on a file
+type Lock struct {
+	file *os.File
+}
+
+// TryLock: acquire the lock at path without blocking
+func TryLock(path string) (*Lock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrLocked
+		}
+		return nil, err
+	}
+
+	// record holder for inspection
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+
+	return &Lock{file: file}, nil
+}
+
+// Unlock: release the lock
+func (l *Lock) Unlock() {
+	syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+	l.file.Close()
+}
@@ -0,0 +1,58 @@
+package daemon
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	timeUtil "github.com/internet-equity/traceneck/internal/util/time"
+)
+
+// prune: remove run outputs beyond the retained count or age
+//
+// only entries named by timeUtil.RunIDFormat are considered; other files are left untouched.
+func prune(outDir string, retainRuns, retainDays int) {
+	if retainRuns <= 0 && retainDays <= 0 {
+		return
+	}
+
+	entries, err := os.ReadDir(outDir)
+	if err != nil {
+		log.Println("[daemon] [retention] error reading output directory:", err)
+		return
+	}
+
+	type run struct {
+		name string
+		time time.Time
+	}
+	var runs []run
+
+	for _, entry := range entries {
+		if t, err := time.Parse(timeUtil.RunIDFormat, entry.Name()); err == nil {
+			runs = append(runs, run{entry.Name(), t})
+		}
+	}
+
+	// newest first
+	slices.SortFunc(runs, func(a, b run) int { return b.time.Compare(a.time) })
+
+	cutoff := time.Now().AddDate(0, 0, -retainDays)
+
+	for i, r := range runs {
+		expired := retainDays > 0 && r.time.Before(cutoff)
+		excess := retainRuns > 0 && i >= retainRuns
+
+		if !expired && !excess {
+			continue
+		}
+
+		if err := os.RemoveAll(filepath.Join(outDir, r.name)); err != nil {
+			log.Println("[daemon] [retention] error removing run:", err)
+		} else {
+			log.Println("[daemon] [retention] removed run:", r.name)
+		}
+	}
+}
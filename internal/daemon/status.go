@@ -0,0 +1,57 @@
+package daemon
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Status: daemon health, rewritten on every state change
+type Status struct {
+	PID         int        `json:"pid"`
+	Version     string     `json:"version"`
+	Started     time.Time  `json:"started"`
+	Updated     time.Time  `json:"updated"`
+	State       string     `json:"state"` // waiting, running or stopped
+	NextRun     *time.Time `json:"next_run,omitempty"`
+	LastRun     *RunStatus `json:"last_run,omitempty"`
+	RunsTotal   int        `json:"runs_total"`
+	RunsFailed  int        `json:"runs_failed"`
+	RunsSkipped int        `json:"runs_skipped"`
+}
+
+type RunStatus struct {
+	ID       string    `json:"id"`
+	Output   string    `json:"output"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// write: atomically replace the status file
+func (s *Status) write(path string) error {
+	s.Updated = time.Now()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".status-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
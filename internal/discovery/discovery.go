@@ -0,0 +1,199 @@
+/*
+ * discovery: select a speedtest server ahead of the test
+ *
+ * servers are queried from the tool's public directory (M-Lab Locate API for ndt7,
+ * speedtest.net server list for ookla); base urls are configurable such that a local
+ * stand-in may be used
+ *
+ */
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	LocateURL = "https://locate.measurementlab.net" // M-Lab Locate API
+	OoklaURL  = "https://www.speedtest.net"         // speedtest.net server list
+
+	locatePath      = "/v2/nearest/ndt/ndt7"
+	ooklaPath       = "/api/js/servers?engine=js&https_functional=true&limit=10"
+	ndt7Download    = "wss:///ndt/v7/download"
+	ndt7Upload      = "wss:///ndt/v7/upload"
+	requestTimeout  = 10 * time.Second
+	ooklaPortSuffix = ":8080"
+)
+
+// Server: selected speedtest server
+type Server struct {
+	Host string // host[:port]
+	IP   net.IP // resolved address
+	ID   string // server id (ookla)
+	URL  string // service url including access token (ndt7)
+	Name string // descriptive name
+}
+
+// Client: discovery client against a base url
+type Client struct {
+	BaseURL   string
+	UserAgent string
+	HTTP      *http.Client
+}
+
+type locateResponse struct {
+	Results []struct {
+		Machine  string `json:"machine"`
+		Location struct {
+			City    string `json:"city"`
+			Country string `json:"country"`
+		} `json:"location"`
+		URLs map[string]string `json:"urls"`
+	} `json:"results"`
+}
+
+type ooklaServer struct {
+	ID      string `json:"id"`
+	Host    string `json:"host"`
+	Name    string `json:"name"`
+	Country string `json:"country"`
+	Sponsor string `json:"sponsor"`
+}
+
+func (c Client) get(path string, v any) error {
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: requestTimeout}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(c.BaseURL, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Ndt7: nearest ndt7 server from the Locate API
+//
+// the returned URL targets the upload test if upload is set, else the download test;
+// the access token it carries is valid for the machine.
+func (c Client) Ndt7(upload bool) (Server, error) {
+	var located locateResponse
+	if err := c.get(locatePath, &located); err != nil {
+		return Server{}, err
+	}
+
+	key := ndt7Download
+	if upload {
+		key = ndt7Upload
+	}
+
+	for _, result := range located.Results {
+		serviceURL, ok := result.URLs[key]
+		if !ok {
+			continue
+		}
+
+		host, err := hostOf(serviceURL)
+		if err != nil {
+			continue
+		}
+
+		return Server{
+			Host: host,
+			URL:  serviceURL,
+			Name: strings.Trim(result.Machine+" "+result.Location.City+", "+result.Location.Country, " ,"),
+		}, nil
+	}
+
+	return Server{}, errors.New("no ndt7 server located")
+}
+
+// Ookla: nearest server from the speedtest.net server list
+func (c Client) Ookla() (Server, error) {
+	var servers []ooklaServer
+	if err := c.get(ooklaPath, &servers); err != nil {
+		return Server{}, err
+	}
+
+	for _, server := range servers {
+		if server.Host == "" || server.ID == "" {
+			continue
+		}
+
+		host := server.Host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host += ooklaPortSuffix
+		}
+
+		return Server{
+			Host: host,
+			ID:   server.ID,
+			Name: strings.Trim(server.Sponsor+" "+server.Name+", "+server.Country, " ,"),
+		}, nil
+	}
+
+	return Server{}, errors.New("no ookla server listed")
+}
+
+// Resolve: resolve the server host to an address of ip version 4 or 6 [0: either]
+func (s *Server) Resolve(version int) error {
+	host, _, err := net.SplitHostPort(s.Host)
+	if err != nil {
+		host = s.Host
+	}
+
+	s.IP, err = LookupIP(host, version)
+	return err
+}
+
+// LookupIP: first address of host of ip version 4 or 6 [0: either]
+func LookupIP(host string, version int) (net.IP, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		if version == 0 || (version == 4) == (ip.To4() != nil) {
+			return ip, nil
+		}
+	}
+
+	if version == 0 {
+		return nil, errors.New("no address found for " + host)
+	}
+	return nil, fmt.Errorf("no ipv%d address found for %s", version, host)
+}
+
+func hostOf(rawURL string) (string, error) {
+	serviceURL, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if serviceURL.Host == "" {
+		return "", errors.New("no host in service url")
+	}
+
+	return serviceURL.Host, nil
+}
@@ -0,0 +1,181 @@
+package discovery
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// standIn: local stand-in of a directory serving body at path
+func standIn(t *testing.T, path, body string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != path {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("User-Agent") != "traceneck/test" {
+			t.Errorf("user agent: got %q", r.Header.Get("User-Agent"))
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+const locateBody = `{"results": [
+	{"machine": "mlab1-nop01", "urls": {"wss:///ndt/v7/upload": "wss://ndt-nop01.example.net/ndt/v7/upload?access_token=u1"}},
+	{"machine": "mlab1-abc01", "location": {"city": "Chicago", "country": "US"}, "urls": {
+		"wss:///ndt/v7/download": "wss://ndt-abc01.example.net/ndt/v7/download?access_token=d2",
+		"wss:///ndt/v7/upload": "wss://ndt-abc01.example.net/ndt/v7/upload?access_token=u2"
+	}}
+]}`
+
+func TestNdt7(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		upload bool
+		want   Server
+		err    bool
+	}{
+		{
+			name: "skips results lacking the download url",
+			body: locateBody,
+			want: Server{
+				Host: "ndt-abc01.example.net",
+				URL:  "wss://ndt-abc01.example.net/ndt/v7/download?access_token=d2",
+				Name: "mlab1-abc01 Chicago, US",
+			},
+		},
+		{
+			name:   "upload url of the first result",
+			body:   locateBody,
+			upload: true,
+			want: Server{
+				Host: "ndt-nop01.example.net",
+				URL:  "wss://ndt-nop01.example.net/ndt/v7/upload?access_token=u1",
+				Name: "mlab1-nop01",
+			},
+		},
+		{
+			name: "no result with the url",
+			body: `{"results": [{"machine": "m", "urls": {"wss:///ndt/v7/upload": "wss://h/ndt/v7/upload"}}]}`,
+			err:  true,
+		},
+		{
+			name: "url without host",
+			body: `{"results": [{"machine": "m", "urls": {"wss:///ndt/v7/download": "/ndt/v7/download"}}]}`,
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := standIn(t, locatePath, test.body)
+			client := Client{BaseURL: server.URL + "/", UserAgent: "traceneck/test"}
+
+			got, err := client.Ndt7(test.upload)
+			if test.err {
+				if err == nil {
+					t.Fatalf("got %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Host != test.want.Host || got.URL != test.want.URL || got.Name != test.want.Name {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestOokla(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want Server
+		err  bool
+	}{
+		{
+			name: "appends the default port",
+			body: `[{"id": "", "host": "skipped.example.net"},
+				{"id": "1234", "host": "speedtest.example.net", "name": "Chicago, IL", "country": "US", "sponsor": "Example"}]`,
+			want: Server{Host: "speedtest.example.net:8080", ID: "1234", Name: "Example Chicago, IL, US"},
+		},
+		{
+			name: "keeps a listed port",
+			body: `[{"id": "5678", "host": "speedtest.example.net:5060"}]`,
+			want: Server{Host: "speedtest.example.net:5060", ID: "5678"},
+		},
+		{
+			name: "no usable server",
+			body: `[{"id": "1234", "host": ""}]`,
+			err:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := standIn(t, ooklaPath, test.body)
+			client := Client{BaseURL: server.URL, UserAgent: "traceneck/test"}
+
+			got, err := client.Ookla()
+			if test.err {
+				if err == nil {
+					t.Fatalf("got %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Host != test.want.Host || got.ID != test.want.ID || got.Name != test.want.Name {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	server := standIn(t, "/elsewhere", "")
+	client := Client{BaseURL: server.URL, UserAgent: "traceneck/test"}
+
+	if _, err := client.Ookla(); err == nil {
+		t.Error("want error on unexpected status")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		host    string
+		version int
+		want    string
+		err     bool
+	}{
+		{host: "127.0.0.1:8080", want: "127.0.0.1"},
+		{host: "127.0.0.1", version: 4, want: "127.0.0.1"},
+		{host: "[::1]:8080", version: 6, want: "::1"},
+		{host: "127.0.0.1:8080", version: 6, err: true},
+	}
+
+	for _, test := range tests {
+		server := Server{Host: test.host}
+		err := server.Resolve(test.version)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s v%d: got %s, want error", test.host, test.version, server.IP)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s v%d: %v", test.host, test.version, err)
+		} else if server.IP.String() != test.want {
+			t.Errorf("%s v%d: got %s, want %s", test.host, test.version, server.IP, test.want)
+		}
+	}
+}
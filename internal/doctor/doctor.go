@@ -0,0 +1,200 @@
+/*
+ * doctor: report whether this host can run measurements
+ *
+ * checks the privileges of pings (raw or unprivileged datagram sockets) and of packet
+ * capture, the sysctls granting them, the installed speedtest clients and tshark, and
+ * the state of the interface; exits 1 where pings or the interface are unusable
+ *
+ */
+package doctor
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+
+	"github.com/internet-equity/traceneck/internal/capability"
+	"github.com/internet-equity/traceneck/internal/config"
+)
+
+// check statuses
+const (
+	statusOK   = "ok"
+	statusWarn = "warn"
+	statusFail = "fail"
+)
+
+// tools: commands of the speedtest clients and tshark, with a harmless argument
+var tools = [][]string{
+	{"ndt7-client", "--help"},
+	{"speedtest", "--version"},
+	{"tools/ookla-http/speedtest.py", "--version"},
+	{"tshark", "--version"},
+}
+
+// report: table of checks, and whether any failed
+type report struct {
+	tw     *tabwriter.Writer
+	failed bool
+}
+
+func (r *report) add(check, status, detail string) {
+	if status == statusFail {
+		r.failed = true
+	}
+	fmt.Fprintf(r.tw, "%s\t%s\t%s\n", check, status, detail)
+}
+
+// Main: run the doctor command, returning the exit status
+func Main(name string, args []string) int {
+	flags := pflag.NewFlagSet("doctor", pflag.ContinueOnError)
+
+	var iface string
+	flags.StringVarP(&iface, "interface", "I", config.DefaultInterface(), "Interface")
+	flags.SortFlags = false
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\nUsage: %s doctor [-I <interface>]\n\nOptions:\n", name)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+
+	r := &report{tw: tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)}
+	fmt.Fprintln(r.tw, "check\tstatus\tdetail")
+
+	checkPrivileges(r)
+	checkPings(r)
+	checkInterface(r, iface)
+	checkTools(r)
+
+	r.tw.Flush()
+	if r.failed {
+		return 1
+	}
+	return 0
+}
+
+// checkPrivileges: user and effective capabilities
+func checkPrivileges(r *report) {
+	r.add("uid", statusOK, strconv.Itoa(os.Geteuid()))
+
+	held, err := capability.Effective()
+	switch {
+	case err != nil:
+		r.add("capabilities", statusWarn, err.Error())
+	case len(held) == 0:
+		r.add("capabilities", statusWarn, "none of cap_net_raw, cap_net_admin")
+	default:
+		r.add("capabilities", statusOK, strings.Join(held, ","))
+	}
+}
+
+// checkPings: raw and datagram sockets, and the ping group range permitting the latter
+func checkPings(r *report) {
+	raw := capability.RawSockets(false)
+	if raw == nil {
+		r.add("raw sockets", statusOK, "pings with all features")
+	} else {
+		r.add("raw sockets", statusWarn, raw.Error())
+	}
+
+	low, high, err := capability.PingGroupRange()
+	switch {
+	case err != nil:
+		r.add("ping_group_range", statusWarn, err.Error())
+	case capability.InPingGroup(low, high):
+		r.add("ping_group_range", statusOK, fmt.Sprintf("%d %d: includes a group of the user", low, high))
+	default:
+		r.add("ping_group_range", statusWarn, fmt.Sprintf("%d %d: excludes the groups of the user", low, high))
+	}
+
+	datagram := capability.DatagramSockets(false)
+	switch {
+	case datagram == nil && raw == nil:
+		r.add("datagram sockets", statusOK, "available")
+	case datagram == nil:
+		r.add("datagram sockets", statusOK, "unprivileged icmp pings, without reply headers, icmp extensions nor udp pings")
+	case raw == nil:
+		r.add("datagram sockets", statusWarn, datagram.Error())
+	default:
+		r.add("datagram sockets", statusFail, "no pings: requires CAP_NET_RAW or net.ipv4.ping_group_range")
+	}
+
+	if capability.RawSockets(true) != nil && capability.DatagramSockets(true) != nil {
+		r.add("ipv6 pings", statusWarn, "no ipv6 icmp sockets")
+	} else {
+		r.add("ipv6 pings", statusOK, "available")
+	}
+}
+
+// checkInterface: state and addresses of iface, and capture permission on it
+func checkInterface(r *report, name string) {
+	if name == "" {
+		r.add("interface", statusFail, "no default route")
+		return
+	}
+
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		r.add("interface", statusFail, name+": not found")
+		return
+	}
+
+	switch {
+	case iface.Flags&net.FlagLoopback != 0:
+		r.add("interface", statusFail, name+": loopback interface")
+	case iface.Flags&net.FlagUp == 0:
+		r.add("interface", statusFail, name+": down")
+	case iface.Flags&net.FlagRunning == 0:
+		r.add("interface", statusFail, name+": not running")
+	default:
+		detail := name + ": up, running"
+		if name == config.DefaultInterface() {
+			detail += ", default route"
+		}
+		r.add("interface", statusOK, detail)
+	}
+
+	addrs, err := iface.Addrs()
+	switch {
+	case err != nil:
+		r.add("addresses", statusFail, err.Error())
+	case len(addrs) == 0:
+		r.add("addresses", statusFail, "none")
+	default:
+		var ips []string
+		for _, addr := range addrs {
+			ips = append(ips, addr.String())
+		}
+		r.add("addresses", statusOK, strings.Join(ips, " "))
+	}
+
+	if err := capability.Capture(name); err != nil {
+		r.add("capture", statusWarn, "capture skipped (or --tshark): "+err.Error())
+	} else {
+		r.add("capture", statusOK, "permitted")
+	}
+}
+
+// checkTools: installed speedtest clients and tshark
+func checkTools(r *report) {
+	for _, tool := range tools {
+		if exec.Command(tool[0], tool[1:]...).Run() != nil {
+			r.add(tool[0], statusWarn, "not installed")
+		} else {
+			r.add(tool[0], statusOK, "installed")
+		}
+	}
+}
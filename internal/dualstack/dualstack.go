@@ -0,0 +1,300 @@
+/*
+ * dualstack: compare the bottleneck over ipv4 and over ipv6
+ *
+ * the measurement is run once per ip version, one after the other such that the runs do
+ * not load each other, re-executing traceneck (as the daemon does) with --ip-version, an
+ * output directory of its own and an ip_version tag, against the same server host; the
+ * runs' metadata are then combined into dualstack.json: per ip version the server
+ * address, path, throughputs and bottleneck verdicts, and whether the verdicts agree
+ *
+ */
+package dualstack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/internet-equity/traceneck/internal/export"
+	"github.com/internet-equity/traceneck/internal/meta"
+)
+
+// ResultFile: name of the comparison in the output directory
+const ResultFile = "dualstack.json"
+
+// versions: ip versions in the order run
+var versions = []int{4, 6}
+
+// Options: dual-stack configuration
+type Options struct {
+	Args   []string // arguments for each run (excluding ip version and output path)
+	Env    []string // environment of each run [nil: this process's]
+	OutDir string   // directory under which per-version outputs are written
+	Server string   // server host of both runs [empty: selected per run]
+}
+
+// Result: runs of either ip version, and their comparison
+type Result struct {
+	Server     string     `json:"server,omitempty" desc:"server host of both runs [absent: selected per run]"`
+	Runs       []Run      `json:"runs" desc:"run per ip version"`
+	Comparison Comparison `json:"comparison" desc:"agreement of the runs' verdicts"`
+}
+
+// Run: outcome of the run of an ip version
+type Run struct {
+	IPVersion     int     `json:"ip_version" desc:"ip version: 4 or 6"`
+	Output        string  `json:"output" desc:"output directory of the run"`
+	Error         string  `json:"error,omitempty" desc:"run failure [absent: complete]"`
+	RunID         string  `json:"run_id,omitempty" desc:"run identifier"`
+	ServerIP      net.IP  `json:"server_ip,omitempty" desc:"server address pinged"`
+	Path          []Hop   `json:"path,omitempty" desc:"replying address per hop"`
+	Download      float64 `json:"download_bps,omitempty" desc:"download throughput (bit/s)"`
+	Upload        float64 `json:"upload_bps,omitempty" desc:"upload throughput (bit/s)"`
+	Bufferbloat   string  `json:"bufferbloat_grade,omitempty" desc:"bufferbloat grade"`
+	BottleneckHop int     `json:"bottleneck_hop,omitempty" desc:"far hop of the link of largest queuing delay under load [absent: none]"`
+	BottleneckIP  net.IP  `json:"bottleneck_ip,omitempty" desc:"address of the bottleneck hop"`
+	Aqm           string  `json:"aqm,omitempty" desc:"likely queue discipline of the bottleneck"`
+	AqmConfidence float64 `json:"aqm_confidence,omitempty" desc:"confidence of the aqm verdict"`
+
+	Metadata *meta.Metadata `json:"-"` // [nil: run failed]
+}
+
+// Hop: most frequent replying address of a hop
+type Hop struct {
+	TTL int    `json:"ttl" desc:"probe ttl"`
+	IP  net.IP `json:"ip" desc:"replying address"`
+}
+
+// Comparison: agreement of the ipv4 and ipv6 verdicts [absent: a verdict undetermined]
+type Comparison struct {
+	SameBottleneckHop *bool    `json:"same_bottleneck_hop,omitempty" desc:"whether the bottleneck is at the same hop"`
+	SameAqm           *bool    `json:"same_aqm,omitempty" desc:"whether the bottleneck aqm is the same"`
+	DownloadRatio     *float64 `json:"download_ratio,omitempty" desc:"ipv6 over ipv4 download throughput"`
+	UploadRatio       *float64 `json:"upload_ratio,omitempty" desc:"ipv6 over ipv4 upload throughput"`
+}
+
+// Compare: run the measurement over either ip version and write their comparison
+func Compare(opts Options) (*Result, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Server: opts.Server}
+	for _, version := range versions {
+		result.Runs = append(result.Runs, opts.run(exe, version))
+	}
+	result.Comparison = compare(result.Runs[0], result.Runs[1])
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return result, err
+	}
+	path := filepath.Join(opts.OutDir, ResultFile)
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return result, err
+	}
+	log.Println("[dual stack] comparison written to:", path)
+
+	return result, nil
+}
+
+// run: execute the run of an ip version and collect its verdicts
+func (opts Options) run(exe string, version int) Run {
+	run := Run{
+		IPVersion: version,
+		Output:    filepath.Join(opts.OutDir, "ipv"+strconv.Itoa(version)) + string(os.PathSeparator),
+	}
+
+	args := append(slices.Clone(opts.Args),
+		"--ip-version", strconv.Itoa(version),
+		"--out-path", run.Output,
+		"--tag", "ip_version="+strconv.Itoa(version),
+	)
+	if opts.Server != "" {
+		args = append(args, "--server", opts.Server)
+	}
+
+	log.Println("[dual stack] ipv"+strconv.Itoa(version), "run started")
+
+	cmd := exec.Command(exe, args...)
+	cmd.Env = opts.Env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		run.Error = err.Error()
+		log.Println("[dual stack] ipv"+strconv.Itoa(version), "run failed:", err)
+		return run
+	}
+
+	var found bool
+	err := export.Walk("dual stack", []string{run.Output}, func(_ string, metadata meta.Metadata) error {
+		run.collect(metadata)
+		found = true
+		return nil
+	})
+	if err == nil && !found {
+		err = errors.New("no metadata")
+	}
+	if err != nil {
+		run.Error = err.Error()
+		log.Println("[dual stack] ipv"+strconv.Itoa(version), "run unreadable:", err)
+		return run
+	}
+
+	log.Println("[dual stack] ipv"+strconv.Itoa(version), "run complete")
+	return run
+}
+
+// collect: verdicts of the run's metadata
+func (run *Run) collect(metadata meta.Metadata) {
+	run.Metadata = &metadata
+	run.RunID = meta.RunID(metadata)
+	run.ServerIP = metadata.Meta.ServerIP
+	run.Path = pathOf(metadata.Measurements.RttSamples)
+	run.Download, run.Upload, _ = meta.BitsPerSecond(metadata)
+
+	if a := metadata.Analysis; a != nil {
+		if a.Bufferbloat != nil {
+			run.Bufferbloat = a.Bufferbloat.Grade
+		}
+		if a.Queuing != nil && a.Queuing.Bottleneck != 0 {
+			run.BottleneckHop = a.Queuing.Bottleneck
+			for _, hop := range run.Path {
+				if hop.TTL == run.BottleneckHop {
+					run.BottleneckIP = hop.IP
+				}
+			}
+		}
+	}
+	if fingerprint := meta.FingerprintOf(metadata); fingerprint != nil {
+		run.Aqm, run.AqmConfidence = fingerprint.AQM, fingerprint.Confidence
+	}
+}
+
+// Metadata: metadata of the completed runs, by ip version
+func (result *Result) Metadata() map[int]meta.Metadata {
+	runs := make(map[int]meta.Metadata, len(result.Runs))
+	for _, run := range result.Runs {
+		if run.Metadata != nil {
+			runs[run.IPVersion] = *run.Metadata
+		}
+	}
+	return runs
+}
+
+// pathOf: most frequent replying address per hop of rtt samples
+func pathOf(samples []meta.RttSample) []Hop {
+	counts := make(map[int]map[string]int)
+	for _, sample := range samples {
+		if sample.Round == 0 || sample.ReplyIP == nil || sample.ReplyIP.IsUnspecified() {
+			continue
+		}
+		if counts[sample.TTL] == nil {
+			counts[sample.TTL] = make(map[string]int)
+		}
+		counts[sample.TTL][sample.ReplyIP.String()]++
+	}
+
+	var path []Hop
+	for _, ttl := range slices.Sorted(maps.Keys(counts)) {
+		var top string
+		for _, ip := range slices.Sorted(maps.Keys(counts[ttl])) {
+			if counts[ttl][ip] > counts[ttl][top] {
+				top = ip
+			}
+		}
+		path = append(path, Hop{TTL: ttl, IP: net.ParseIP(top)})
+	}
+
+	return path
+}
+
+// compare: agreement of the verdicts of the ipv4 and ipv6 runs
+func compare(v4, v6 Run) (c Comparison) {
+	if v4.BottleneckHop != 0 && v6.BottleneckHop != 0 {
+		same := v4.BottleneckHop == v6.BottleneckHop
+		c.SameBottleneckHop = &same
+	}
+	if v4.Aqm != "" && v6.Aqm != "" {
+		same := v4.Aqm == v6.Aqm
+		c.SameAqm = &same
+	}
+	if v4.Download > 0 && v6.Download > 0 {
+		ratio := v6.Download / v4.Download
+		c.DownloadRatio = &ratio
+	}
+	if v4.Upload > 0 && v6.Upload > 0 {
+		ratio := v6.Upload / v4.Upload
+		c.UploadRatio = &ratio
+	}
+	return c
+}
+
+// WriteSummary: human-readable comparison of the runs
+func (r *Result) WriteSummary(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nDual stack\tipv4\tipv6\t")
+
+	rows := []struct {
+		label string
+		value func(Run) string
+	}{
+		{"server ip", func(run Run) string { return ipString(run.ServerIP) }},
+		{"hops", func(run Run) string { return strconv.Itoa(len(run.Path)) }},
+		{"download (Mbit/s)", func(run Run) string { return fmt.Sprintf("%.1f", run.Download/1e6) }},
+		{"upload (Mbit/s)", func(run Run) string { return fmt.Sprintf("%.1f", run.Upload/1e6) }},
+		{"bufferbloat", func(run Run) string { return orDash(run.Bufferbloat) }},
+		{"bottleneck", func(run Run) string {
+			if run.BottleneckHop == 0 {
+				return "-"
+			}
+			return fmt.Sprintf("hop %d (%s)", run.BottleneckHop, ipString(run.BottleneckIP))
+		}},
+		{"aqm", func(run Run) string { return orDash(run.Aqm) }},
+	}
+	for _, row := range rows {
+		line := row.label
+		for _, run := range r.Runs {
+			if run.Error != "" {
+				line += "\tfailed"
+			} else {
+				line += "\t" + row.value(run)
+			}
+		}
+		fmt.Fprintln(tw, line+"\t")
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if same := r.Comparison.SameBottleneckHop; same != nil && !*same {
+		_, err := fmt.Fprintln(w, "  Bottleneck differs by ip version")
+		return err
+	}
+	return nil
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return "-"
+	}
+	return ip.String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
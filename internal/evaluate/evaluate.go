@@ -0,0 +1,261 @@
+/*
+ * evaluate: validate the aqm fingerprint against labelled runs
+ *
+ * runs are labelled by their aqm tag (--tag aqm=<aqm>), else by the aqm suffix of a
+ * directory of their path as named by the aqm/ sweeps (<iface>_<bw>_<latency>_<loss>_<aqm>);
+ * fingerprints are those recorded, else of the runs' rtt samples alone
+ *
+ */
+package evaluate
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+
+	"github.com/internet-equity/traceneck/internal/analysis"
+	"github.com/internet-equity/traceneck/internal/export"
+	"github.com/internet-equity/traceneck/internal/meta"
+)
+
+const undetermined = "undetermined"
+
+// labels: aqm classes by sweep label, longest suffix first
+var labels = []struct {
+	label, class string
+}{
+	{"fq_codel", analysis.AqmFqCodel},
+	{"tail-drop", analysis.AqmTailDrop},
+	{"tail_drop", analysis.AqmTailDrop},
+	{"no_aqm", analysis.AqmTailDrop},
+	{"pfifo", analysis.AqmTailDrop},
+	{"codel", analysis.AqmCodel},
+	{"cake", analysis.AqmCake},
+	{"pie", analysis.AqmPie},
+	{"sfq", analysis.AqmSfq},
+}
+
+type result struct {
+	label, predicted string
+	confidence       float64
+	features         *analysis.AqmFeatures // [nil: no fingerprint]
+}
+
+// Main: run the evaluate command, returning the exit status
+func Main(name string, args []string) int {
+	flags := pflag.NewFlagSet("evaluate", pflag.ContinueOnError)
+
+	var (
+		tag     string
+		verbose bool
+	)
+	flags.StringVar(&tag, "label-tag", "aqm", "Tag of the run's aqm label")
+	flags.BoolVarP(&verbose, "verbose", "v", false, "Print the fingerprint of each run")
+	flags.SortFlags = false
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\nUsage: %s evaluate <path>...\n\nOptions:\n", name)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "[evaluate] input paths required")
+		return 1
+	}
+
+	var results []result
+	err := export.Walk("evaluate", flags.Args(), func(source string, metadata meta.Metadata) error {
+		label := labelOf(source, metadata.Meta.Tags[tag])
+		if label == "" {
+			log.Println("[evaluate] skipping", source+":", "no aqm label")
+			return nil
+		}
+
+		r := result{label: label, predicted: undetermined}
+		if fingerprint := meta.FingerprintOf(metadata); fingerprint != nil {
+			if fingerprint.AQM != "" {
+				r.predicted, r.confidence = fingerprint.AQM, fingerprint.Confidence
+			}
+			r.features = &fingerprint.Features
+		}
+		results = append(results, r)
+
+		if verbose {
+			fmt.Printf("%s: %s -> %s (%.2f)\n", source, r.label, r.predicted, r.confidence)
+		}
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "[evaluate]", err)
+		return 1
+	}
+	if len(results) == 0 {
+		fmt.Fprintln(os.Stderr, "[evaluate] no labelled runs")
+		return 1
+	}
+
+	if err := writeReport(os.Stdout, results); err != nil {
+		fmt.Fprintln(os.Stderr, "[evaluate]", err)
+		return 1
+	}
+
+	return 0
+}
+
+// labelOf: aqm class of the tag value, else of the path's sweep directory [empty: unlabelled]
+func labelOf(source, value string) string {
+	if value != "" {
+		return classOf(value, func(label string) bool { return strings.EqualFold(value, label) })
+	}
+
+	for dir := filepath.Dir(source); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		base := filepath.Base(dir)
+		if class := classOf(base, func(label string) bool { return strings.HasSuffix(base, "_"+label) }); class != "" {
+			return class
+		}
+	}
+
+	return ""
+}
+
+func classOf(value string, match func(label string) bool) string {
+	for _, l := range labels {
+		if match(l.label) {
+			return l.class
+		}
+	}
+	return ""
+}
+
+// writeReport: accuracy, coverage, confusion matrix and median features per label of results
+func writeReport(w io.Writer, results []result) error {
+	var (
+		determined, correct, family int
+		hit, miss                   []float64
+		confusion                   = make(map[string]map[string]int)
+		predicted                   = map[string]bool{undetermined: true}
+	)
+
+	for _, r := range results {
+		if confusion[r.label] == nil {
+			confusion[r.label] = make(map[string]int)
+		}
+		confusion[r.label][r.predicted]++
+		predicted[r.predicted] = true
+
+		if r.predicted == undetermined {
+			continue
+		}
+		determined++
+
+		switch {
+		case r.predicted == r.label:
+			correct++
+			family++
+			hit = append(hit, r.confidence)
+		case isFqCodel(r.predicted) && isFqCodel(r.label):
+			// told apart by the bulk flow's queue alone
+			family++
+			miss = append(miss, r.confidence)
+		default:
+			miss = append(miss, r.confidence)
+		}
+	}
+
+	fmt.Fprintf(w, "Runs: %d, determined: %d (%.1f%%)\n", len(results), determined, percent(determined, len(results)))
+	fmt.Fprintf(w, "Accuracy: %.1f%% (fq_codel and cake as one: %.1f%%)\n", percent(correct, determined), percent(family, determined))
+	fmt.Fprintf(w, "Mean confidence: correct %s, incorrect %s\n\n", mean(hit), mean(miss))
+
+	columns := slices.Sorted(maps.Keys(predicted))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "label \\ predicted\t"+strings.Join(columns, "\t")+"\t")
+	for _, label := range slices.Sorted(maps.Keys(confusion)) {
+		row := label
+		for _, column := range columns {
+			row += fmt.Sprintf("\t%d", confusion[label][column])
+		}
+		fmt.Fprintln(tw, row+"\t")
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	return writeFeatures(w, results)
+}
+
+// writeFeatures: median features of the fingerprints per label, against which the class
+// profiles are calibrated
+func writeFeatures(w io.Writer, results []result) error {
+	features := make(map[string][]*analysis.AqmFeatures)
+	for _, r := range results {
+		if r.features != nil {
+			features[r.label] = append(features[r.label], r.features)
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "label\truns\tprobe queue\tbulk queue\tsawtooth\tplateau\tloss burstiness\t")
+	for _, label := range slices.Sorted(maps.Keys(features)) {
+		var probe, bulk, sawtooth, plateau, burstiness []float64
+		for _, f := range features[label] {
+			probe = append(probe, f.ProbeQueueMedian)
+			sawtooth = append(sawtooth, f.Sawtooth)
+			plateau = append(plateau, f.Plateau)
+			if f.BulkQueueMedian != nil {
+				bulk = append(bulk, *f.BulkQueueMedian)
+			}
+			if f.LossBurstiness != nil {
+				burstiness = append(burstiness, *f.LossBurstiness)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t\n", label, len(features[label]),
+			median(probe), median(bulk), median(sawtooth), median(plateau), median(burstiness))
+	}
+
+	return tw.Flush()
+}
+
+func isFqCodel(class string) bool {
+	return class == analysis.AqmFqCodel || class == analysis.AqmCake
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+func median(values []float64) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	slices.Sort(values)
+	return fmt.Sprintf("%.2f", analysis.Quantile(values, 0.5))
+}
+
+func mean(values []float64) string {
+	if len(values) == 0 {
+		return "-"
+	}
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	return fmt.Sprintf("%.2f", sum/float64(len(values)))
+}
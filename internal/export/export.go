@@ -0,0 +1,227 @@
+/*
+ * export: convert traceneck outputs into a partitioned parquet dataset
+ *
+ * metadata is read from output directories (metadata.json, metadata-<time>.json) and
+ * archives (.tar, .tar.gz, .tgz), upgraded to the current schema version; rtt samples and
+ * run summaries are written to rtt_samples/ and summary/ tables, hive-partitioned by tool
+ * and date (or as configured)
+ *
+ */
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/pflag"
+
+	"github.com/internet-equity/traceneck/internal/meta"
+	"github.com/internet-equity/traceneck/internal/parquet"
+)
+
+const (
+	rttTable     = "rtt_samples"
+	summaryTable = "summary"
+	partFile     = "part-00000.parquet"
+
+	rowGroupSize = 1 << 17 // rows buffered per open partition table
+)
+
+var partitionKeys = []string{"tool", "date", "interface"}
+
+type partition struct {
+	files   []*os.File
+	rtt     *parquet.Writer
+	summary *parquet.Writer
+}
+
+type exporter struct {
+	outDir     string
+	partitions []string
+	open       map[string]*partition
+	runs       int
+}
+
+// Main: run the export command, returning the exit status
+func Main(name string, args []string) int {
+	flags := pflag.NewFlagSet("export", pflag.ContinueOnError)
+
+	var (
+		outDir     string
+		partitions []string
+		quiet      bool
+	)
+	flags.StringVarP(&outDir, "out-path", "o", "", "Output directory of parquet dataset [must not exist or be empty]")
+	flags.StringSliceVar(&partitions, "partition", []string{"tool", "date"}, "Partition keys: tool, date, interface [comma-separated; empty for none]")
+	flags.BoolVarP(&quiet, "quiet", "q", false, "Minimize logging")
+	flags.SortFlags = false
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\nUsage: %s export -o <directory> <path>...\n\nOptions:\n", name)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+
+	if quiet {
+		log.SetOutput(io.Discard)
+	}
+
+	if err := run(outDir, partitions, flags.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "[export]", err)
+		return 1
+	}
+
+	return 0
+}
+
+func run(outDir string, partitions, inputs []string) error {
+	if outDir == "" {
+		return errors.New("output directory required")
+	}
+	if len(inputs) == 0 {
+		return errors.New("input paths required")
+	}
+	for _, key := range partitions {
+		if !slices.Contains(partitionKeys, key) {
+			return fmt.Errorf("invalid partition key: %s", key)
+		}
+	}
+
+	if entries, err := os.ReadDir(outDir); err == nil && len(entries) > 0 {
+		return fmt.Errorf("output directory not empty: %s", outDir)
+	}
+
+	e := &exporter{
+		outDir:     outDir,
+		partitions: partitions,
+		open:       make(map[string]*partition),
+	}
+
+	if err := Walk("export", inputs, e.add); err != nil {
+		e.close()
+		return err
+	}
+
+	if err := e.close(); err != nil {
+		return err
+	}
+
+	log.Printf("[export] %d run(s) in %d partition(s) exported to: %s", e.runs, len(e.open), outDir)
+	return nil
+}
+
+// add: write rows of a run's metadata to its partition
+func (e *exporter) add(source string, metadata meta.Metadata) error {
+	p, err := e.partition(metadata)
+	if err != nil {
+		return err
+	}
+
+	samples := metadata.Measurements.RttSamples
+	meta.SortSamples(samples)
+
+	for _, row := range meta.RttRows(metadata, samples) {
+		if err := p.rtt.Write(row); err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+	}
+	if err := p.summary.Write(meta.SummaryRow(metadata, len(samples))); err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+
+	e.runs++
+	log.Println("[export] exported:", source)
+	return nil
+}
+
+// partition: writers of the run's partition, opened on first use
+func (e *exporter) partition(metadata meta.Metadata) (*partition, error) {
+	var dirs []string
+	for _, key := range e.partitions {
+		var value string
+		switch key {
+		case "tool":
+			value = meta.ToolOf(metadata)
+		case "date":
+			value = meta.RunTime(metadata).UTC().Format("2006-01-02")
+		case "interface":
+			value = metadata.Meta.Interface
+		}
+		dirs = append(dirs, key+"="+partitionValue(value))
+	}
+	key := filepath.Join(dirs...)
+
+	if p, ok := e.open[key]; ok {
+		return p, nil
+	}
+
+	p := &partition{}
+	e.open[key] = p
+
+	for _, table := range []struct {
+		name   string
+		schema []parquet.Column
+		writer **parquet.Writer
+	}{
+		{rttTable, meta.RttSchema, &p.rtt},
+		{summaryTable, meta.SummarySchema, &p.summary},
+	} {
+		dir := filepath.Join(e.outDir, table.name, key)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+
+		file, err := os.Create(filepath.Join(dir, partFile))
+		if err != nil {
+			return nil, err
+		}
+		p.files = append(p.files, file)
+
+		writer, err := parquet.NewWriter(file, table.schema)
+		if err != nil {
+			return nil, err
+		}
+		writer.CreatedBy = "traceneck"
+		writer.RowGroupSize = rowGroupSize
+		*table.writer = writer
+	}
+
+	return p, nil
+}
+
+// close: write parquet footers and close files
+func (e *exporter) close() error {
+	var errs []error
+
+	for _, p := range e.open {
+		for _, writer := range []*parquet.Writer{p.rtt, p.summary} {
+			if writer != nil {
+				errs = append(errs, writer.Close())
+			}
+		}
+		for _, file := range p.files {
+			errs = append(errs, file.Close())
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// partitionValue: value safe for use as a path component
+func partitionValue(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return strings.NewReplacer("/", "_", "=", "_", string(filepath.Separator), "_").Replace(value)
+}
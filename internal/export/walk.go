@@ -0,0 +1,140 @@
+package export
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/internet-equity/traceneck/internal/meta"
+	"github.com/internet-equity/traceneck/internal/schema"
+)
+
+// Visit: handler of a run's metadata read from source
+type Visit func(source string, metadata meta.Metadata) error
+
+type walker struct {
+	command string
+	visit   Visit
+}
+
+// Walk: call visit with the metadata of output directories (metadata.json,
+// metadata-<time>.json) and archives (.tar, .tar.gz, .tgz) under inputs, upgraded to
+// the current schema version; unreadable metadata is logged as skipped by command
+func Walk(command string, inputs []string, visit Visit) error {
+	w := walker{command: command, visit: visit}
+
+	for _, input := range inputs {
+		if err := filepath.WalkDir(input, w.walk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walk: visit metadata found at path
+func (w walker) walk(path string, entry fs.DirEntry, err error) error {
+	if err != nil {
+		return err
+	}
+	if entry.IsDir() {
+		return nil
+	}
+
+	name := entry.Name()
+
+	switch {
+	case isMetadataFile(name):
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return w.read(path, file)
+
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return w.readArchive(path)
+	}
+
+	return nil
+}
+
+func isMetadataFile(name string) bool {
+	return name == "metadata.json" || (strings.HasPrefix(name, "metadata-") && strings.HasSuffix(name, ".json"))
+}
+
+func (w walker) readArchive(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if !strings.HasSuffix(path, ".tar") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			w.skip(path, err)
+			return nil
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			w.skip(path, err)
+			return nil
+		}
+
+		if isMetadataFile(filepath.Base(header.Name)) {
+			if err := w.read(path+":"+header.Name, archive); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// read: visit a run's metadata read from r
+func (w walker) read(source string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+
+	// upgrade metadata of earlier versions
+	data, _, err = schema.MigrateJSON(data)
+	if err != nil {
+		w.skip(source, err)
+		return nil
+	}
+
+	var metadata meta.Metadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		w.skip(source, err)
+		return nil
+	}
+	if metadata.Meta.Time == 0 {
+		w.skip(source, "not traceneck metadata")
+		return nil
+	}
+
+	return w.visit(source, metadata)
+}
+
+func (w walker) skip(source string, reason any) {
+	log.Println("["+w.command+"] skipping", source+":", reason)
+}
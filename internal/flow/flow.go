@@ -0,0 +1,208 @@
+/*
+ * flow: sparse latency flow to the server alongside the speedtest
+ *
+ * a low-rate flow, separate from the speedtest's bulk flows, probes the server until
+ * stopped: tcp probes time the handshake of a new connection to the speedtest port (each
+ * thereby hashed to a flow queue of its own), and udp probes are reflected by a twamp-light
+ * reflector (rfc 5357), whose timestamps yield one-way delays besides round-trip time
+ *
+ */
+package flow
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/internet-equity/traceneck/internal/channel"
+	"github.com/internet-equity/traceneck/internal/config"
+	"github.com/internet-equity/traceneck/internal/meta"
+	timeUtil "github.com/internet-equity/traceneck/internal/util/time"
+)
+
+const (
+	packetSendDelay  = 100 * time.Millisecond
+	replyTimeout     = time.Second // of tcp probes, short of syn retransmission
+	replyListenDelay = time.Second
+)
+
+var (
+	mu      sync.Mutex
+	samples []meta.FlowSample
+	sent    []time.Time
+)
+
+// Process: probe the server by the configured latency flow until stopped
+func Process() {
+	defer close(channel.FlowDone)
+
+	select {
+	case <-channel.IPGrabbed:
+	case <-channel.Stop:
+		// speedtest ended without a server to probe
+		log.Println("[flow] no server ip grabbed")
+		return
+	}
+
+	addr := net.JoinHostPort(config.ServerIP.String(), strconv.Itoa(config.LatencyFlowPort))
+	log.Println("[flow] started:", config.LatencyFlow, addr)
+
+	var err error
+	if config.LatencyFlow == "tcp" {
+		err = runTCP(addr)
+	} else {
+		err = runUDP(addr)
+	}
+	if err != nil {
+		log.Println("[flow] error:", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	var dropped int
+	for _, sample := range samples {
+		if sample.RecvTime == 0 {
+			dropped++
+		}
+	}
+
+	meta.MFlow = &meta.LatencyFlow{Protocol: config.LatencyFlow, Address: addr, Samples: samples}
+	log.Println("[flow] stopped, total:", len(samples), "dropped:", dropped)
+}
+
+// runTCP: time the handshake of a connection per probe
+//
+// refused connections are replied by reset, and as such timed alike.
+func runTCP(addr string) error {
+	dialer := net.Dialer{Timeout: replyTimeout}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		select {
+		case <-channel.Stop:
+			return nil
+		case <-time.After(packetSendDelay):
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				seq := send(time.Now())
+				conn, err := dialer.Dial("tcp", addr)
+				recvTime := time.Now()
+
+				if err == nil {
+					// reset rather than linger in close
+					conn.(*net.TCPConn).SetLinger(0)
+					conn.Close()
+				} else if !errors.Is(err, syscall.ECONNREFUSED) {
+					return
+				}
+				reply(seq, recvTime, nil)
+			}()
+		}
+	}
+}
+
+// runUDP: send twamp-light test packets, timing those reflected
+func runUDP(addr string) error {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	receiverDone := make(channel.Type)
+	go func() {
+		defer close(receiverDone)
+		receive(conn)
+	}()
+
+	for {
+		select {
+		case <-channel.Stop:
+			time.Sleep(replyListenDelay)
+			conn.SetReadDeadline(time.Now())
+			<-receiverDone
+			return nil
+		case <-time.After(packetSendDelay):
+			now := time.Now()
+			seq := send(now)
+			if _, err := conn.Write(senderPacket(uint32(seq), now)); err != nil {
+				log.Println("[flow] error sending packet:", err)
+			}
+		}
+	}
+}
+
+// receive: record reflected test packets until the read deadline
+func receive(conn *net.UDPConn) {
+	buffer := make([]byte, 2*packetSize)
+
+	for {
+		n, err := conn.Read(buffer)
+		recvTime := time.Now()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// e.g. port unreachable
+			continue
+		}
+
+		packet, ok := parseReflected(buffer[:n])
+		if !ok {
+			continue
+		}
+
+		forward := milliseconds(packet.ReceiveTime.Sub(packet.SenderTimestamp))
+		reverse := milliseconds(recvTime.Sub(packet.Timestamp))
+		reply(int(packet.SenderSeq), recvTime, func(sample *meta.FlowSample) {
+			sample.ForwardDelay, sample.ReverseDelay = &forward, &reverse
+		})
+	}
+}
+
+// send: record a probe sent at t, returning its sequence number
+func send(t time.Time) int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	seq := len(samples)
+	samples = append(samples, meta.FlowSample{Seq: seq, SendTime: timeUtil.UnixPrecise(t)})
+	sent = append(sent, t)
+	return seq
+}
+
+// reply: record the first reply to probe seq, received at recvTime
+func reply(seq int, recvTime time.Time, update func(*meta.FlowSample)) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if seq < 0 || seq >= len(samples) || samples[seq].RecvTime != 0 {
+		return
+	}
+
+	sample := &samples[seq]
+	sample.RecvTime = timeUtil.UnixPrecise(recvTime)
+	sample.RTT = milliseconds(recvTime.Sub(sent[seq]))
+	if update != nil {
+		update(sample)
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Nanoseconds()) / 1000000
+}
@@ -0,0 +1,128 @@
+package flow
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// ReflectMain: run the twamp-light reflector command, returning the exit status
+func ReflectMain(name string, args []string) int {
+	flags := pflag.NewFlagSet("reflect", pflag.ContinueOnError)
+
+	var (
+		listen string
+		quiet  bool
+	)
+	flags.StringVarP(&listen, "listen", "l", ":862", "Address (<host>:<port>) at which to reflect test packets")
+	flags.BoolVarP(&quiet, "quiet", "q", false, "Minimize logging")
+	flags.SortFlags = false
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\nUsage: %s reflect [-l <host>:<port>]\n\nOptions:\n", name)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+
+	if quiet {
+		log.SetOutput(io.Discard)
+	}
+
+	if err := reflect(listen); err != nil {
+		fmt.Fprintln(os.Stderr, "[reflect]", err)
+		return 1
+	}
+
+	return 0
+}
+
+// reflect: reflect test packets received at addr until interrupted
+func reflect(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Println("[reflect] stopping:", sig)
+		conn.Close()
+	}()
+
+	log.Println("[reflect] listening:", conn.LocalAddr())
+
+	return reflectOn(conn)
+}
+
+// reflectOn: reflect test packets received on conn until it is closed
+func reflectOn(conn net.PacketConn) error {
+	read := readFunc(conn)
+
+	buffer := make([]byte, 1<<16)
+	var seq uint32
+
+	for {
+		n, ttl, peer, err := read(buffer)
+		recvTime := time.Now()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if n < senderHeaderSize {
+			continue
+		}
+
+		if _, err := conn.WriteTo(reflectorPacket(seq, buffer[:n], recvTime, ttl), peer); err != nil {
+			log.Println("[reflect] error sending packet:", err)
+			continue
+		}
+		seq++
+	}
+}
+
+// readFunc: read of a test packet with its ttl (hop limit) [255: unknown]
+func readFunc(conn net.PacketConn) func(b []byte) (int, int, net.Addr, error) {
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		p := ipv4.NewPacketConn(conn)
+		p.SetControlMessage(ipv4.FlagTTL, true)
+
+		return func(b []byte) (int, int, net.Addr, error) {
+			n, cm, peer, err := p.ReadFrom(b)
+			if cm == nil || cm.TTL == 0 {
+				return n, 255, peer, err
+			}
+			return n, cm.TTL, peer, err
+		}
+	}
+
+	p := ipv6.NewPacketConn(conn)
+	p.SetControlMessage(ipv6.FlagHopLimit, true)
+
+	return func(b []byte) (int, int, net.Addr, error) {
+		n, cm, peer, err := p.ReadFrom(b)
+		if cm == nil || cm.HopLimit == 0 {
+			return n, 255, peer, err
+		}
+		return n, cm.HopLimit, peer, err
+	}
+}
@@ -0,0 +1,77 @@
+package flow
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// twamp-light unauthenticated test packets (rfc 5357 4.1.2, 4.2.1)
+const (
+	senderHeaderSize    = 14 // sequence, timestamp, error estimate
+	reflectorHeaderSize = 41 // ... receive timestamp, sender sequence, timestamp, error estimate, ttl
+	packetSize          = 64 // of both, padded such that the flow is symmetric
+
+	// errorEstimate: unsynchronized clock, multiplier 1 (scale 0)
+	errorEstimate = 0x0001
+
+	// ntpEpochOffset: seconds from the ntp epoch (1900) to the unix epoch
+	ntpEpochOffset = 2208988800
+)
+
+// reflected: fields of a reflector's test packet
+type reflected struct {
+	Timestamp       time.Time // reflector send time
+	ReceiveTime     time.Time // reflector receive time
+	SenderSeq       uint32
+	SenderTimestamp time.Time
+}
+
+// senderPacket: test packet of sequence seq, sent at t
+func senderPacket(seq uint32, t time.Time) []byte {
+	packet := make([]byte, packetSize)
+	binary.BigEndian.PutUint32(packet[0:4], seq)
+	putTimestamp(packet[4:12], t)
+	binary.BigEndian.PutUint16(packet[12:14], errorEstimate)
+	return packet
+}
+
+// reflectorPacket: reflection of a sender's test packet, received at recvTime with ttl
+func reflectorPacket(seq uint32, request []byte, recvTime time.Time, ttl int) []byte {
+	packet := make([]byte, max(len(request), reflectorHeaderSize))
+	binary.BigEndian.PutUint32(packet[0:4], seq)
+	binary.BigEndian.PutUint16(packet[12:14], errorEstimate)
+	putTimestamp(packet[16:24], recvTime)
+	copy(packet[24:38], request[:senderHeaderSize])
+	packet[40] = byte(ttl)
+
+	// timestamped last, as close to sending as may be
+	putTimestamp(packet[4:12], time.Now())
+	return packet
+}
+
+// parseReflected: fields of a reflector's test packet [false: too short]
+func parseReflected(packet []byte) (reflected, bool) {
+	if len(packet) < reflectorHeaderSize {
+		return reflected{}, false
+	}
+
+	return reflected{
+		Timestamp:       timestamp(packet[4:12]),
+		ReceiveTime:     timestamp(packet[16:24]),
+		SenderSeq:       binary.BigEndian.Uint32(packet[24:28]),
+		SenderTimestamp: timestamp(packet[28:36]),
+	}, true
+}
+
+// putTimestamp: write t in ntp format (seconds, 32-bit fraction)
+func putTimestamp(b []byte, t time.Time) {
+	binary.BigEndian.PutUint32(b[0:4], uint32(t.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(b[4:8], uint32((uint64(t.Nanosecond())<<32)/1e9))
+}
+
+// timestamp: time of an ntp format timestamp
+func timestamp(b []byte) time.Time {
+	seconds := int64(binary.BigEndian.Uint32(b[0:4])) - ntpEpochOffset
+	nanos := (uint64(binary.BigEndian.Uint32(b[4:8])) * 1e9) >> 32
+	return time.Unix(seconds, int64(nanos))
+}
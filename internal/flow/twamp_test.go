@@ -0,0 +1,144 @@
+package flow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTimestamp(t *testing.T) {
+	tests := []struct {
+		t    time.Time
+		want []byte
+	}{
+		{time.Unix(0, 0), []byte{0x83, 0xaa, 0x7e, 0x80, 0, 0, 0, 0}},
+		{time.Unix(0, 500000000), []byte{0x83, 0xaa, 0x7e, 0x80, 0x80, 0, 0, 0}},
+		{time.Unix(1700000000, 250000000), []byte{0xe8, 0xfe, 0x6f, 0x80, 0x40, 0, 0, 0}},
+	}
+
+	for _, test := range tests {
+		b := make([]byte, 8)
+		putTimestamp(b, test.t)
+		if !bytes.Equal(b, test.want) {
+			t.Errorf("%v: got % x, want % x", test.t, b, test.want)
+		}
+		if got := timestamp(b); !got.Equal(test.t) {
+			t.Errorf("%v: read back %v", test.t, got)
+		}
+	}
+
+	// 32-bit fractions resolve to below a nanosecond: round trips truncate by at most 1ns
+	in := time.Unix(1700000000, 123456789)
+	b := make([]byte, 8)
+	putTimestamp(b, in)
+	if !within(timestamp(b), in) {
+		t.Errorf("round trip of %v: got %v", in, timestamp(b))
+	}
+}
+
+// within: whether a timestamp read back is that written, truncated to the fraction
+func within(read, written time.Time) bool {
+	d := written.Sub(read)
+	return d >= 0 && d <= time.Nanosecond
+}
+
+func TestPackets(t *testing.T) {
+	sent := time.Unix(1700000000, 100000000)
+	request := senderPacket(42, sent)
+
+	if len(request) != packetSize {
+		t.Fatalf("sender packet: %d bytes", len(request))
+	}
+	if seq := binary.BigEndian.Uint32(request[0:4]); seq != 42 {
+		t.Errorf("sender sequence: got %d", seq)
+	}
+	if estimate := binary.BigEndian.Uint16(request[12:14]); estimate != errorEstimate {
+		t.Errorf("error estimate: got %#x", estimate)
+	}
+
+	received := sent.Add(10 * time.Millisecond)
+	reflection := reflectorPacket(7, request, received, 61)
+	if len(reflection) != packetSize {
+		t.Errorf("reflector packet: %d bytes, want symmetric %d", len(reflection), packetSize)
+	}
+	if reflection[40] != 61 {
+		t.Errorf("sender ttl: got %d", reflection[40])
+	}
+	if seq := binary.BigEndian.Uint32(reflection[0:4]); seq != 7 {
+		t.Errorf("reflector sequence: got %d", seq)
+	}
+
+	r, ok := parseReflected(reflection)
+	if !ok {
+		t.Fatal("reflection not parsed")
+	}
+	if r.SenderSeq != 42 || !within(r.SenderTimestamp, sent) || !within(r.ReceiveTime, received) {
+		t.Errorf("got %+v", r)
+	}
+	if r.Timestamp.Before(received) {
+		t.Errorf("reflector timestamp %v before receive time %v", r.Timestamp, received)
+	}
+
+	// short requests are padded to the reflector header
+	if short := reflectorPacket(0, request[:senderHeaderSize], received, 64); len(short) != reflectorHeaderSize {
+		t.Errorf("short reflection: %d bytes", len(short))
+	}
+	if _, ok := parseReflected(make([]byte, reflectorHeaderSize-1)); ok {
+		t.Error("truncated reflection parsed")
+	}
+}
+
+func TestReflector(t *testing.T) {
+	reflector, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- reflectOn(reflector) }()
+
+	conn, err := net.Dial("udp", reflector.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// packets shorter than the sender header are dropped
+	if _, err := conn.Write(make([]byte, senderHeaderSize-1)); err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := make([]byte, 1500)
+	for seq := uint32(0); seq < 3; seq++ {
+		sent := time.Now()
+		if _, err := conn.Write(senderPacket(100+seq, sent)); err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := conn.Read(buffer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, ok := parseReflected(buffer[:n])
+		if !ok {
+			t.Fatalf("reflection of %d bytes", n)
+		}
+
+		if n != packetSize || r.SenderSeq != 100+seq || binary.BigEndian.Uint32(buffer[0:4]) != seq {
+			t.Errorf("reflection %d: %d bytes, %+v", seq, n, r)
+		}
+		if r.SenderTimestamp.Sub(sent).Abs() > time.Microsecond || r.ReceiveTime.Before(r.SenderTimestamp) || r.Timestamp.Before(r.ReceiveTime) {
+			t.Errorf("reflection %d timestamps: %+v", seq, r)
+		}
+		if ttl := buffer[40]; ttl == 0 {
+			t.Errorf("reflection %d: no ttl", seq)
+		}
+	}
+
+	reflector.Close()
+	if err := <-done; err != nil {
+		t.Errorf("reflector stopped: %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const requestTimeout = time.Minute
+
+// Client: write endpoint, including query parameters (e.g. bucket, org, precision=ns)
+type Client struct {
+	URL   string
+	Token string // api token [optional]
+	HTTP  *http.Client
+}
+
+// Write: post points to the write endpoint
+func (c Client) Write(points []Point) error {
+	var body bytes.Buffer
+	if err := Write(&body, points); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Token "+c.Token)
+	}
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: requestTimeout}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// error responses carry a short json or text explanation
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
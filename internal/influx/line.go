@@ -0,0 +1,97 @@
+/*
+ * influx: influxdb line protocol
+ *
+ * points are encoded as line protocol for files or for the write endpoint of an
+ * influxdb (v1 /write or v2 /api/v2/write) with nanosecond precision
+ *
+ */
+package influx
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Point: measurement point, with fields of type float64, int, int64, bool or string
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]any
+	Time        float64 // unix seconds
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// Write: write points as line protocol, one per line
+//
+// tags are sorted by key, as recommended for write performance; empty tag values are omitted.
+func Write(w io.Writer, points []Point) error {
+	bw := bufio.NewWriter(w)
+
+	for _, point := range points {
+		bw.WriteString(Encode(point))
+		bw.WriteByte('\n')
+	}
+
+	return bw.Flush()
+}
+
+// Encode: line of a point
+func Encode(point Point) string {
+	var line strings.Builder
+
+	line.WriteString(measurementEscaper.Replace(point.Measurement))
+
+	for _, key := range sortedKeys(point.Tags) {
+		if value := point.Tags[key]; value != "" {
+			line.WriteString("," + tagEscaper.Replace(key) + "=" + tagEscaper.Replace(value))
+		}
+	}
+
+	for i, key := range sortedKeys(point.Fields) {
+		if i == 0 {
+			line.WriteByte(' ')
+		} else {
+			line.WriteByte(',')
+		}
+		line.WriteString(tagEscaper.Replace(key) + "=" + fieldValue(point.Fields[key]))
+	}
+
+	line.WriteString(" " + strconv.FormatInt(int64(math.Round(point.Time*1e9)), 10))
+
+	return line.String()
+}
+
+func fieldValue(value any) string {
+	switch v := value.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int:
+		return strconv.Itoa(v) + "i"
+	case int64:
+		return strconv.FormatInt(v, 10) + "i"
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return `"` + stringEscaper.Replace(v) + `"`
+	default:
+		panic("influx: unsupported field type")
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,102 @@
+package influx
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name  string
+		point Point
+		want  string
+	}{
+		{
+			name: "sorted tags and fields",
+			point: Point{
+				Measurement: "rtt",
+				Tags:        map[string]string{"tool": "ookla", "hop": "1", "phase": ""},
+				Fields:      map[string]any{"rtt": 12.5, "ttl": 1, "bytes": int64(1500), "lost": false, "reply_ip": "192.0.2.1"},
+				Time:        1700000000,
+			},
+			want: `rtt,hop=1,tool=ookla bytes=1500i,lost=false,reply_ip="192.0.2.1",rtt=12.5,ttl=1i 1700000000000000000`,
+		},
+		{
+			name: "escapes",
+			point: Point{
+				Measurement: "my measurement,x",
+				Tags:        map[string]string{"tag key": "a=b,c d"},
+				Fields:      map[string]any{"field,key": `say "hi" \o/`},
+				Time:        1,
+			},
+			want: `my\ measurement\,x,tag\ key=a\=b\,c\ d field\,key="say \"hi\" \\o/" 1000000000`,
+		},
+		{
+			name: "float formatting",
+			point: Point{
+				Measurement: "throughput",
+				Fields:      map[string]any{"bps": 1e8, "small": 0.000001, "neg": -2.5},
+				Time:        1.5,
+			},
+			want: `throughput bps=100000000,neg=-2.5,small=0.000001 1500000000`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Encode(test.point); got != test.want {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestClientWrite(t *testing.T) {
+	var (
+		body, auth, contentType string
+		status                  = http.StatusNoContent
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		auth = r.Header.Get("Authorization")
+		contentType = r.Header.Get("Content-Type")
+		if r.URL.Query().Get("precision") != "ns" {
+			t.Errorf("query: %s", r.URL.RawQuery)
+		}
+
+		w.WriteHeader(status)
+		if status != http.StatusNoContent {
+			w.Write([]byte(`{"code":"invalid","message":"unable to parse"}` + "\n"))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client := Client{URL: server.URL + "/api/v2/write?bucket=b&org=o&precision=ns", Token: "secret"}
+	points := []Point{
+		{Measurement: "a", Fields: map[string]any{"v": 1.0}, Time: 1},
+		{Measurement: "b", Fields: map[string]any{"v": 2.0}, Time: 2},
+	}
+
+	if err := client.Write(points); err != nil {
+		t.Fatal(err)
+	}
+	if want := "a v=1 1000000000\nb v=2 2000000000\n"; body != want {
+		t.Errorf("body: got %q, want %q", body, want)
+	}
+	if auth != "Token secret" {
+		t.Errorf("authorization: got %q", auth)
+	}
+	if contentType != "text/plain; charset=utf-8" {
+		t.Errorf("content type: got %q", contentType)
+	}
+
+	status = http.StatusBadRequest
+	err := client.Write(points)
+	if err == nil || !strings.Contains(err.Error(), "400") || !strings.Contains(err.Error(), "unable to parse") {
+		t.Errorf("got %v, want status and message", err)
+	}
+}
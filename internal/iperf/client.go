@@ -0,0 +1,468 @@
+/*
+ * client: native iperf3 client
+ *
+ * drives the iperf3 control protocol against a stock iperf3 server: cookie, parameter
+ * exchange, stream creation, timed test, results exchange and teardown
+ *
+ */
+package iperf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	dialTimeout    = 10 * time.Second
+	connectTimeout = 10 * time.Second
+	maxParallel    = 128
+)
+
+type Direction string
+
+const (
+	Upload   Direction = "upload"   // client sends
+	Download Direction = "download" // server sends
+)
+
+// Client: iperf3 test configuration and callbacks
+type Client struct {
+	Address    string        // server host:port
+	Parallel   int           // number of parallel streams (per direction)
+	Reverse    bool          // server sends
+	Bidir      bool          // both directions simultaneously
+	Duration   time.Duration // test duration
+	UDP        bool          // use udp rather than tcp
+	Bitrate    uint64        // target bits per second per stream [0: unlimited tcp, 1 Mbit/s udp]
+	Congestion string        // tcp congestion control algorithm
+	BlockSize  int           // read/write block (or datagram) size
+	Interval   time.Duration // reporting interval
+
+	OnConnect  func(serverIP net.IP) // control connection established
+	OnStart    func(start time.Time) // streams started
+	OnInterval func(Interval)        // interval completed
+}
+
+// Interval: per-direction throughput summed over streams
+type Interval struct {
+	Direction     Direction
+	Start         time.Time
+	End           time.Time
+	Bytes         uint64
+	BitsPerSecond float64
+}
+
+// Summary: end-of-test results for one direction
+type Summary struct {
+	BytesSent     uint64
+	BytesReceived uint64
+	BitsPerSecond float64 // as measured by the receiver
+	Retransmits   int     // tcp sender retransmits [-1 if unavailable]
+	Jitter        float64 // udp jitter in milliseconds
+	Lost          int64   // udp datagrams lost
+	Packets       int64   // udp datagrams expected
+}
+
+// Result: end-of-test results
+type Result struct {
+	ServerIP       net.IP
+	Start          time.Time
+	End            time.Time
+	Streams        int
+	CongestionUsed string
+	Upload         *Summary
+	Download       *Summary
+}
+
+func (c *Client) defaults() error {
+	if c.Parallel == 0 {
+		c.Parallel = 1
+	}
+	if c.Parallel < 0 || c.Parallel > maxParallel {
+		return fmt.Errorf("parallel streams not in range [1, %d]", maxParallel)
+	}
+	if c.Reverse && c.Bidir {
+		return errors.New("reverse and bidirectional modes are exclusive")
+	}
+	if c.Duration <= 0 {
+		c.Duration = 10 * time.Second
+	}
+	if c.Interval <= 0 {
+		c.Interval = time.Second
+	}
+	if c.BlockSize == 0 {
+		if c.UDP {
+			c.BlockSize = defaultUDPBlockSize
+		} else {
+			c.BlockSize = defaultTCPBlockSize
+		}
+	}
+	if c.UDP && c.Bitrate == 0 {
+		c.Bitrate = defaultUDPRate
+	}
+
+	return nil
+}
+
+// Run: run a test to completion
+func (c *Client) Run() (*Result, error) {
+	if err := c.defaults(); err != nil {
+		return nil, err
+	}
+
+	ctrl, err := net.DialTimeout("tcp", c.Address, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer ctrl.Close()
+
+	result := &Result{ServerIP: ctrl.RemoteAddr().(*net.TCPAddr).IP}
+	if c.OnConnect != nil {
+		c.OnConnect(result.ServerIP)
+	}
+
+	cookie, err := newCookie()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ctrl.Write(cookie); err != nil {
+		return nil, err
+	}
+
+	var (
+		streams     []*stream
+		stopSenders = make(chan struct{})
+		stopReport  = make(chan struct{})
+		reportDone  = make(chan struct{})
+		running     bool
+		serverRes   results
+	)
+
+	defer func() {
+		for _, s := range streams {
+			s.conn.Close()
+		}
+	}()
+
+	for {
+		st, err := readState(ctrl)
+
+		if running && isTimeout(err) {
+			// test duration elapsed
+			running = false
+			result.End = time.Now()
+
+			close(stopSenders)
+			for _, s := range streams {
+				if s.sender {
+					<-s.done
+					if !s.udp {
+						s.retransmits = tcpRetransmits(s.conn)
+					}
+				}
+			}
+
+			close(stopReport)
+			<-reportDone
+
+			if err := ctrl.SetReadDeadline(time.Time{}); err != nil {
+				return nil, err
+			}
+			if err := writeState(ctrl, stateTestEnd); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch st {
+		case stateParamExchange:
+			if err := writeJSON(ctrl, c.params()); err != nil {
+				return nil, err
+			}
+
+		case stateCreateStreams:
+			if streams, err = c.createStreams(ctrl.RemoteAddr().String(), cookie); err != nil {
+				return nil, err
+			}
+			result.Streams = len(streams)
+
+		case stateTestStart:
+			// nothing to prepare: streams start on test running
+
+		case stateTestRunning:
+			running = true
+			result.Start = time.Now()
+			if c.OnStart != nil {
+				c.OnStart(result.Start)
+			}
+
+			for _, s := range streams {
+				go s.run(c.BlockSize, c.Bitrate, stopSenders)
+			}
+			go c.report(streams, result.Start, stopReport, reportDone)
+
+			if err := ctrl.SetReadDeadline(result.Start.Add(c.Duration)); err != nil {
+				return nil, err
+			}
+
+		case stateExchangeResults:
+			if err := writeJSON(ctrl, c.results(streams, result)); err != nil {
+				return nil, err
+			}
+			if err := readJSON(ctrl, &serverRes); err != nil {
+				return nil, err
+			}
+
+		case stateDisplayResults:
+			if err := writeState(ctrl, stateIperfDone); err != nil {
+				return nil, err
+			}
+			c.summarize(streams, serverRes, result)
+			return result, nil
+
+		case stateAccessDenied:
+			return nil, errors.New("access denied: server busy")
+
+		case stateServerError:
+			return nil, readServerError(ctrl)
+
+		case stateServerTerminate:
+			return nil, errors.New("server terminated test")
+
+		default:
+			return nil, fmt.Errorf("unexpected state: %d", st)
+		}
+	}
+}
+
+func (c *Client) params() params {
+	return params{
+		TCP:           !c.UDP,
+		UDP:           c.UDP,
+		Time:          c.Duration.Seconds(),
+		Parallel:      c.Parallel,
+		Reverse:       c.Reverse,
+		Bidirectional: c.Bidir,
+		Len:           c.BlockSize,
+		Bandwidth:     c.Bitrate,
+		PacingTimer:   1000,
+		Congestion:    c.Congestion,
+		ClientVersion: clientVersion,
+	}
+}
+
+// createStreams: connect data streams in the order expected by the server
+//
+// stream ids follow the server's numbering (1, 3, 4, ...); bidirectional tests
+// connect sending streams before receiving streams.
+func (c *Client) createStreams(address string, cookie []byte) ([]*stream, error) {
+	var senders []bool
+
+	for range c.Parallel {
+		senders = append(senders, !c.Reverse)
+	}
+	if c.Bidir {
+		for range c.Parallel {
+			senders = append(senders, false)
+		}
+	}
+
+	streams := make([]*stream, 0, len(senders))
+
+	for _, sender := range senders {
+		conn, err := c.dialStream(address, cookie)
+		if err != nil {
+			for _, s := range streams {
+				s.conn.Close()
+			}
+			return nil, err
+		}
+
+		id := 1
+		if len(streams) > 0 {
+			id = len(streams) + 2
+		}
+		streams = append(streams, newStream(id, conn, sender, c.UDP))
+	}
+
+	return streams, nil
+}
+
+func (c *Client) dialStream(address string, cookie []byte) (net.Conn, error) {
+	if !c.UDP {
+		dialer := net.Dialer{Timeout: dialTimeout}
+		if c.Congestion != "" {
+			dialer.Control = congestionControl(c.Congestion)
+		}
+
+		conn, err := dialer.Dial("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(cookie); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	conn, err := net.DialTimeout("udp", address, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// udp "connect": the server replies on the datagram socket it binds for the stream
+	msg := binary.NativeEndian.AppendUint32(nil, udpConnectMsg)
+	if _, err := conn.Write(msg); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(connectTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reply := make([]byte, 4)
+	if _, err := conn.Read(reply); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("udp connect: %w", err)
+	}
+	if r := binary.NativeEndian.Uint32(reply); r != udpConnectReply && r != legacyUDPConnectReply {
+		conn.Close()
+		return nil, errors.New("udp connect: unexpected reply")
+	}
+
+	return conn, conn.SetReadDeadline(time.Time{})
+}
+
+// report: emit per-direction intervals until stop is closed
+func (c *Client) report(streams []*stream, start time.Time, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+
+	last := make(map[*stream]uint64, len(streams))
+	lastTime := start
+
+	emit := func(now time.Time) {
+		bytes := map[Direction]uint64{}
+
+		for _, s := range streams {
+			total := s.bytes.Load()
+			bytes[streamDirection(s)] += total - last[s]
+			last[s] = total
+		}
+
+		elapsed := now.Sub(lastTime).Seconds()
+		for _, dir := range []Direction{Upload, Download} {
+			if _, ok := bytes[dir]; !ok || c.OnInterval == nil || elapsed <= 0 {
+				continue
+			}
+			c.OnInterval(Interval{
+				Direction:     dir,
+				Start:         lastTime,
+				End:           now,
+				Bytes:         bytes[dir],
+				BitsPerSecond: float64(bytes[dir]*8) / elapsed,
+			})
+		}
+
+		lastTime = now
+	}
+
+	for {
+		select {
+		case <-stop:
+			emit(time.Now())
+			return
+		case now := <-ticker.C:
+			emit(now)
+		}
+	}
+}
+
+func (c *Client) results(streams []*stream, result *Result) results {
+	elapsed := result.End.Sub(result.Start).Seconds()
+
+	res := results{SenderHasRetransmits: -1}
+
+	for _, s := range streams {
+		if s.sender {
+			if !s.udp && s.retransmits >= 0 {
+				res.SenderHasRetransmits = 1
+			} else if res.SenderHasRetransmits < 0 {
+				res.SenderHasRetransmits = 0
+			}
+		}
+		res.Streams = append(res.Streams, s.result(elapsed))
+	}
+
+	if !c.UDP && len(streams) > 0 {
+		res.CongestionUsed = tcpCongestion(streams[0].conn)
+		result.CongestionUsed = res.CongestionUsed
+	}
+
+	return res
+}
+
+// summarize: combine local and server stream results per direction
+func (c *Client) summarize(streams []*stream, serverRes results, result *Result) {
+	elapsed := result.End.Sub(result.Start).Seconds()
+
+	remote := make(map[int]streamResult, len(serverRes.Streams))
+	for _, r := range serverRes.Streams {
+		remote[r.ID] = r
+	}
+
+	if result.CongestionUsed == "" {
+		result.CongestionUsed = serverRes.CongestionUsed
+	}
+
+	for _, s := range streams {
+		local := s.result(elapsed)
+		peer := remote[s.id]
+
+		summary := &result.Upload
+		sender, receiver := local, peer
+		if !s.sender {
+			summary = &result.Download
+			sender, receiver = peer, local
+		}
+
+		if *summary == nil {
+			*summary = &Summary{Retransmits: -1}
+		}
+		sum := *summary
+
+		sum.BytesSent += sender.Bytes
+		sum.BytesReceived += receiver.Bytes
+		if sender.Retransmits >= 0 {
+			sum.Retransmits = max(sum.Retransmits, 0) + sender.Retransmits
+		}
+		if s.udp {
+			sum.Jitter = max(sum.Jitter, receiver.Jitter*1000)
+			sum.Lost += receiver.Errors
+			sum.Packets += receiver.Packets
+		}
+	}
+
+	for _, sum := range []*Summary{result.Upload, result.Download} {
+		if sum != nil && elapsed > 0 {
+			sum.BitsPerSecond = float64(sum.BytesReceived*8) / elapsed
+		}
+	}
+}
+
+func streamDirection(s *stream) Direction {
+	if s.sender {
+		return Upload
+	}
+	return Download
+}
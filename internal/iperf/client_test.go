@@ -0,0 +1,363 @@
+package iperf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJSONFraming(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, params{TCP: true, Time: 1.5, Parallel: 2, Len: 1024, ClientVersion: "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := `{"tcp":true,"omit":0,"time":1.5,"parallel":2,"len":1024,"pacing_timer":0,"client_version":"x"}`
+	want := append(binary.BigEndian.AppendUint32(nil, uint32(len(msg))), msg...)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("got  %q\nwant %q", buf.Bytes(), want)
+	}
+
+	var got params
+	if err := readJSON(&buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Time != 1.5 || got.Parallel != 2 || !got.TCP {
+		t.Errorf("got %+v", got)
+	}
+
+	if err := readJSON(bytes.NewReader([]byte{0, 0, 0, 10, '{'}), &got); err == nil {
+		t.Error("truncated message: want error")
+	}
+}
+
+func TestStates(t *testing.T) {
+	var buf bytes.Buffer
+	for _, s := range []state{stateParamExchange, stateAccessDenied, stateServerError} {
+		if err := writeState(&buf, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []byte{9, 0xff, 0xfe}; !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("got % x, want % x", buf.Bytes(), want)
+	}
+
+	for _, want := range []state{stateParamExchange, stateAccessDenied, stateServerError} {
+		if got, err := readState(&buf); err != nil || got != want {
+			t.Errorf("got %d %v, want %d", got, err, want)
+		}
+	}
+
+	codes := binary.BigEndian.AppendUint32(nil, 111)
+	codes = binary.BigEndian.AppendUint32(codes, 98)
+	if err := readServerError(bytes.NewReader(codes)); err != (ServerError{Code: 111, Errno: 98}) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestCookie(t *testing.T) {
+	cookie, err := newCookie()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cookie) != cookieSize || cookie[cookieSize-1] != 0 {
+		t.Fatalf("got %q", cookie)
+	}
+	for _, c := range cookie[:cookieSize-1] {
+		if !bytes.ContainsRune([]byte(cookieChars), rune(c)) {
+			t.Fatalf("invalid character %q in %q", c, cookie)
+		}
+	}
+}
+
+// standIn: local stand-in of an iperf3 server running one tcp test
+type standIn struct {
+	listener net.Listener
+	params   params
+	received atomic.Uint64 // bytes read from client streams
+	client   results
+	reject   state // state sent in place of the test [0: none]
+	err      chan error
+}
+
+func newStandIn(t *testing.T) *standIn {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	return &standIn{listener: listener, err: make(chan error, 1)}
+}
+
+func (s *standIn) serve() {
+	s.err <- s.test()
+}
+
+func (s *standIn) test() error {
+	ctrl, err := s.listener.Accept()
+	if err != nil {
+		return err
+	}
+	defer ctrl.Close()
+
+	cookie := make([]byte, cookieSize)
+	if _, err := io.ReadFull(ctrl, cookie); err != nil {
+		return err
+	}
+
+	switch s.reject {
+	case 0:
+	case stateServerError:
+		writeState(ctrl, stateServerError)
+		ctrl.Write(binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 111), 98))
+		return nil
+	default:
+		return writeState(ctrl, s.reject)
+	}
+
+	writeState(ctrl, stateParamExchange)
+	if err := readJSON(ctrl, &s.params); err != nil {
+		return err
+	}
+
+	writeState(ctrl, stateCreateStreams)
+	n := s.params.Parallel
+	if s.params.Bidirectional {
+		n *= 2
+	}
+	var conns []net.Conn
+	for range n {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		streamCookie := make([]byte, cookieSize)
+		if _, err := io.ReadFull(conn, streamCookie); err != nil || !bytes.Equal(streamCookie, cookie) {
+			return errors.New("stream cookie mismatch")
+		}
+		conns = append(conns, conn)
+	}
+
+	writeState(ctrl, stateTestStart)
+	writeState(ctrl, stateTestRunning)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		// client streams send unless reverse, and the second half of bidirectional streams receive
+		clientSends := !s.params.Reverse && !(s.params.Bidirectional && i >= s.params.Parallel)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			block := make([]byte, s.params.Len)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if clientSends {
+					conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+					n, err := conn.Read(block)
+					s.received.Add(uint64(n))
+					if err != nil && !isTimeout(err) {
+						return
+					}
+				} else {
+					conn.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+					if _, err := conn.Write(block); err != nil && !isTimeout(err) {
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	st, err := readState(ctrl)
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if st != stateTestEnd {
+		return errors.New("expected test end")
+	}
+
+	writeState(ctrl, stateExchangeResults)
+	if err := readJSON(ctrl, &s.client); err != nil {
+		return err
+	}
+
+	server := results{SenderHasRetransmits: 0, CongestionUsed: "cubic"}
+	for i, r := range s.client.Streams {
+		server.Streams = append(server.Streams, streamResult{ID: r.ID, Bytes: s.received.Load() / uint64(len(conns)) * uint64(i+1)})
+	}
+	if err := writeJSON(ctrl, server); err != nil {
+		return err
+	}
+
+	writeState(ctrl, stateDisplayResults)
+	if st, err := readState(ctrl); err != nil || st != stateIperfDone {
+		return errors.New("expected iperf done")
+	}
+	return nil
+}
+
+func TestClientRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		client   Client
+		upload   bool
+		download bool
+	}{
+		{name: "upload", client: Client{Parallel: 2}, upload: true},
+		{name: "download", client: Client{Reverse: true}, download: true},
+		{name: "bidirectional", client: Client{Bidir: true}, upload: true, download: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := newStandIn(t)
+			go server.serve()
+
+			client := test.client
+			client.Address = server.listener.Addr().String()
+			client.Duration = 300 * time.Millisecond
+			client.Interval = 100 * time.Millisecond
+			client.BlockSize = 16 * 1024
+
+			var (
+				mu        sync.Mutex
+				intervals []Interval
+				connected net.IP
+				started   time.Time
+			)
+			client.OnConnect = func(ip net.IP) { connected = ip }
+			client.OnStart = func(start time.Time) { started = start }
+			client.OnInterval = func(interval Interval) {
+				mu.Lock()
+				intervals = append(intervals, interval)
+				mu.Unlock()
+			}
+
+			result, err := client.Run()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := <-server.err; err != nil {
+				t.Fatal("server:", err)
+			}
+
+			if !connected.Equal(net.IPv4(127, 0, 0, 1)) || !result.ServerIP.Equal(connected) {
+				t.Errorf("server ip: got %v, %v", connected, result.ServerIP)
+			}
+			if started.IsZero() || !result.Start.Equal(started) || result.End.Sub(result.Start) < client.Duration {
+				t.Errorf("times: start %v, end %v", result.Start, result.End)
+			}
+
+			p := server.params
+			if !p.TCP || p.Time != 0.3 || p.Len != 16*1024 || p.Reverse != client.Reverse || p.Bidirectional != client.Bidir || p.ClientVersion != clientVersion {
+				t.Errorf("params: got %+v", p)
+			}
+
+			wantStreams := client.Parallel
+			if client.Bidir {
+				wantStreams *= 2
+			}
+			if result.Streams != wantStreams || len(server.client.Streams) != wantStreams {
+				t.Errorf("streams: got %d, reported %d, want %d", result.Streams, len(server.client.Streams), wantStreams)
+			}
+			for i, r := range server.client.Streams {
+				want := i + 2 // 1, 3, 4, ...
+				if i == 0 {
+					want = 1
+				}
+				if r.ID != want {
+					t.Errorf("stream %d: id %d, want %d", i, r.ID, want)
+				}
+			}
+
+			if (result.Upload != nil) != test.upload || (result.Download != nil) != test.download {
+				t.Fatalf("directions: upload %v, download %v", result.Upload, result.Download)
+			}
+			if sum := result.Upload; sum != nil {
+				if sum.BytesSent == 0 || sum.BytesReceived == 0 || sum.BitsPerSecond <= 0 {
+					t.Errorf("upload: got %+v", sum)
+				}
+			}
+			if sum := result.Download; sum != nil {
+				if sum.BytesReceived == 0 || sum.BitsPerSecond <= 0 {
+					t.Errorf("download: got %+v", sum)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(intervals) == 0 {
+				t.Error("no intervals reported")
+			}
+			for _, interval := range intervals {
+				wrongDirection := (interval.Direction == Upload && !test.upload) || (interval.Direction == Download && !test.download)
+				if interval.End.Before(interval.Start) || wrongDirection {
+					t.Errorf("interval: got %+v", interval)
+				}
+			}
+		})
+	}
+}
+
+func TestClientRejected(t *testing.T) {
+	tests := []struct {
+		reject state
+		want   string
+	}{
+		{stateAccessDenied, "access denied: server busy"},
+		{stateServerError, "server error: code 111, errno 98"},
+		{stateServerTerminate, "server terminated test"},
+	}
+
+	for _, test := range tests {
+		server := newStandIn(t)
+		server.reject = test.reject
+		go server.serve()
+
+		client := Client{Address: server.listener.Addr().String()}
+		_, err := client.Run()
+		if err == nil || err.Error() != test.want {
+			t.Errorf("state %d: got %v, want %q", test.reject, err, test.want)
+		}
+		<-server.err
+	}
+}
+
+func TestClientDefaults(t *testing.T) {
+	for _, client := range []Client{
+		{Parallel: -1},
+		{Parallel: maxParallel + 1},
+		{Reverse: true, Bidir: true},
+	} {
+		if err := client.defaults(); err == nil {
+			t.Errorf("%+v: want error", client)
+		}
+	}
+
+	udp := Client{UDP: true}
+	if err := udp.defaults(); err != nil {
+		t.Fatal(err)
+	}
+	if udp.Parallel != 1 || udp.BlockSize != defaultUDPBlockSize || udp.Bitrate != defaultUDPRate || udp.Duration != 10*time.Second {
+		t.Errorf("udp defaults: got %+v", udp)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * protocol: iperf3 control protocol primitives
+ *
+ * the control connection carries single-byte test states and length-prefixed json messages;
+ * data streams are opened separately and identified to the server by the session cookie
+ *
+ */
+package iperf
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const (
+	DefaultPort = 5201
+
+	cookieSize  = 37 // 36 characters and NUL terminator
+	cookieChars = "abcdefghijklmnopqrstuvwxyz234567"
+
+	defaultTCPBlockSize = 128 * 1024
+	defaultUDPBlockSize = 1400
+	defaultUDPRate      = 1000000 // bits per second
+
+	udpConnectMsg         = 0x36373839
+	udpConnectReply       = 0x39383736
+	legacyUDPConnectReply = 987654321
+
+	clientVersion = "traceneck"
+)
+
+// test states as written to the control connection
+type state int8
+
+const (
+	stateTestStart       state = 1
+	stateTestRunning     state = 2
+	stateTestEnd         state = 4
+	stateParamExchange   state = 9
+	stateCreateStreams   state = 10
+	stateServerTerminate state = 11
+	stateClientTerminate state = 12
+	stateExchangeResults state = 13
+	stateDisplayResults  state = 14
+	stateIperfStart      state = 15
+	stateIperfDone       state = 16
+	stateAccessDenied    state = -1
+	stateServerError     state = -2
+)
+
+// params: test parameters sent to the server during parameter exchange
+type params struct {
+	TCP           bool    `json:"tcp,omitempty"`
+	UDP           bool    `json:"udp,omitempty"`
+	Omit          int     `json:"omit"`
+	Time          float64 `json:"time"`
+	Parallel      int     `json:"parallel"`
+	Reverse       bool    `json:"reverse,omitempty"`
+	Bidirectional bool    `json:"bidirectional,omitempty"`
+	Len           int     `json:"len"`
+	Bandwidth     uint64  `json:"bandwidth,omitempty"`
+	PacingTimer   int     `json:"pacing_timer"`
+	Congestion    string  `json:"congestion,omitempty"`
+	ClientVersion string  `json:"client_version"`
+}
+
+// results: end-of-test results exchanged with the server
+type results struct {
+	CPUUtilTotal         float64        `json:"cpu_util_total"`
+	CPUUtilUser          float64        `json:"cpu_util_user"`
+	CPUUtilSystem        float64        `json:"cpu_util_system"`
+	SenderHasRetransmits int            `json:"sender_has_retransmits"`
+	CongestionUsed       string         `json:"congestion_used,omitempty"`
+	Streams              []streamResult `json:"streams"`
+}
+
+type streamResult struct {
+	ID             int     `json:"id"`
+	Bytes          uint64  `json:"bytes"`
+	Retransmits    int     `json:"retransmits"`
+	Jitter         float64 `json:"jitter"`
+	Errors         int64   `json:"errors"`
+	OmittedErrors  int64   `json:"omitted_errors"`
+	Packets        int64   `json:"packets"`
+	OmittedPackets int64   `json:"omitted_packets"`
+	StartTime      float64 `json:"start_time"`
+	EndTime        float64 `json:"end_time"`
+}
+
+// ServerError: error state reported by the server
+type ServerError struct {
+	Code  int32 // iperf3 error code (i_errno)
+	Errno int32 // server errno
+}
+
+func (err ServerError) Error() string {
+	return fmt.Sprintf("server error: code %d, errno %d", err.Code, err.Errno)
+}
+
+func newCookie() ([]byte, error) {
+	cookie := make([]byte, cookieSize)
+	if _, err := rand.Read(cookie[:cookieSize-1]); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < cookieSize-1; i++ {
+		cookie[i] = cookieChars[int(cookie[i])%len(cookieChars)]
+	}
+	cookie[cookieSize-1] = 0
+
+	return cookie, nil
+}
+
+func readState(r io.Reader) (state, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return state(int8(b[0])), nil
+}
+
+func writeState(w io.Writer, s state) error {
+	_, err := w.Write([]byte{byte(s)})
+	return err
+}
+
+// readServerError: read error codes following a server error state
+func readServerError(r io.Reader) error {
+	var codes [2]int32
+	if err := binary.Read(r, binary.BigEndian, &codes); err != nil {
+		return err
+	}
+	return ServerError{Code: codes[0], Errno: codes[1]}
+}
+
+// writeJSON: write v as json prefixed by its 4-byte big-endian length
+func writeJSON(w io.Writer, v any) error {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4+len(msg))
+	binary.BigEndian.PutUint32(buf, uint32(len(msg)))
+	copy(buf[4:], msg)
+
+	_, err = w.Write(buf)
+	return err
+}
+
+// readJSON: read length-prefixed json into v
+func readJSON(r io.Reader, v any) error {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return err
+	}
+
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(msg, v)
+}
@@ -0,0 +1,64 @@
+package iperf
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// congestionControl: dialer control setting the tcp congestion control algorithm
+func congestionControl(algorithm string) func(string, string, syscall.RawConn) error {
+	return func(network, address string, rawConn syscall.RawConn) error {
+		var sockErr error
+		err := rawConn.Control(func(fd uintptr) {
+			sockErr = unix.SetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION, algorithm)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+// tcpRetransmits: total retransmits of a tcp connection, or -1 if unavailable
+func tcpRetransmits(conn net.Conn) int {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return -1
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return -1
+	}
+
+	retransmits := -1
+	rawConn.Control(func(fd uintptr) {
+		if info, err := unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO); err == nil {
+			retransmits = int(info.Total_retrans)
+		}
+	})
+
+	return retransmits
+}
+
+// tcpCongestion: congestion control algorithm in use by a tcp connection
+func tcpCongestion(conn net.Conn) string {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return ""
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return ""
+	}
+
+	var algorithm string
+	rawConn.Control(func(fd uintptr) {
+		algorithm, _ = unix.GetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION)
+	})
+
+	return algorithm
+}
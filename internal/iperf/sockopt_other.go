@@ -0,0 +1,23 @@
+//go:build !linux
+
+package iperf
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func congestionControl(algorithm string) func(string, string, syscall.RawConn) error {
+	return func(string, string, syscall.RawConn) error {
+		return errors.New("congestion control not supported on this platform")
+	}
+}
+
+func tcpRetransmits(net.Conn) int {
+	return -1
+}
+
+func tcpCongestion(net.Conn) string {
+	return ""
+}
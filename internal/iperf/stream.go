@@ -0,0 +1,220 @@
+/*
+ * stream: iperf3 data streams
+ *
+ * tcp streams write or read blocks of the negotiated length; udp streams carry a
+ * timestamp and packet counter per datagram from which the receiver derives jitter and loss
+ *
+ */
+package iperf
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const udpHeaderSize = 12 // sec, usec, packet count
+
+type stream struct {
+	id     int
+	sender bool
+	udp    bool
+	conn   net.Conn
+
+	bytes   atomic.Uint64
+	packets atomic.Int64
+
+	// udp receiver statistics
+	mu          sync.Mutex
+	jitter      float64
+	lost        int64
+	lastCount   int64
+	prevTransit float64
+
+	// tcp sender retransmits (when available)
+	retransmits int
+
+	done chan struct{}
+}
+
+func newStream(id int, conn net.Conn, sender, udp bool) *stream {
+	return &stream{
+		id:          id,
+		sender:      sender,
+		udp:         udp,
+		conn:        conn,
+		retransmits: -1,
+		done:        make(chan struct{}),
+	}
+}
+
+// run: send or receive until stop is closed or the connection fails
+func (s *stream) run(blockSize int, rate uint64, stop <-chan struct{}) {
+	defer close(s.done)
+
+	go func() {
+		select {
+		case <-stop:
+			if s.sender {
+				// unblock pending writes; receivers keep draining until the server closes
+				s.conn.SetWriteDeadline(time.Now())
+			}
+		case <-s.done:
+			// the connection failed before stop
+		}
+	}()
+
+	switch {
+	case s.sender && s.udp:
+		s.sendUDP(blockSize, rate, stop)
+	case s.sender:
+		s.sendTCP(blockSize, stop)
+	case s.udp:
+		s.recvUDP(blockSize)
+	default:
+		s.recvTCP(blockSize)
+	}
+}
+
+func (s *stream) sendTCP(blockSize int, stop <-chan struct{}) {
+	block := make([]byte, blockSize)
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+			n, err := s.conn.Write(block)
+			s.bytes.Add(uint64(n))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *stream) recvTCP(blockSize int) {
+	block := make([]byte, blockSize)
+
+	for {
+		n, err := s.conn.Read(block)
+		s.bytes.Add(uint64(n))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// sendUDP: send datagrams paced to rate (bits per second)
+func (s *stream) sendUDP(blockSize int, rate uint64, stop <-chan struct{}) {
+	block := make([]byte, max(blockSize, udpHeaderSize))
+	start := time.Now()
+
+	var count uint32
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		now := time.Now()
+		allowed := uint64(now.Sub(start).Seconds() * float64(rate) / 8)
+		if s.bytes.Load() >= allowed {
+			time.Sleep(time.Millisecond)
+			continue
+		}
+
+		count++
+		binary.BigEndian.PutUint32(block[0:4], uint32(now.Unix()))
+		binary.BigEndian.PutUint32(block[4:8], uint32(now.Nanosecond()/1000))
+		binary.BigEndian.PutUint32(block[8:12], count)
+
+		n, err := s.conn.Write(block)
+		s.bytes.Add(uint64(n))
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || isTimeout(err) {
+				return
+			}
+			continue
+		}
+		s.packets.Store(int64(count))
+	}
+}
+
+// recvUDP: receive datagrams, tracking jitter (RFC 1889) and loss from packet counters
+func (s *stream) recvUDP(blockSize int) {
+	block := make([]byte, max(blockSize, udpHeaderSize)+udpHeaderSize)
+
+	for {
+		n, err := s.conn.Read(block)
+		if err != nil {
+			return
+		}
+		if n < udpHeaderSize {
+			continue
+		}
+		arrival := time.Now()
+		s.bytes.Add(uint64(n))
+
+		sent := time.Unix(
+			int64(binary.BigEndian.Uint32(block[0:4])),
+			int64(binary.BigEndian.Uint32(block[4:8]))*1000,
+		)
+		count := int64(binary.BigEndian.Uint32(block[8:12]))
+
+		s.mu.Lock()
+		if count >= s.lastCount+1 {
+			if count > s.lastCount+1 {
+				s.lost += count - 1 - s.lastCount
+			}
+			s.lastCount = count
+		} else if s.lost > 0 {
+			// out of order: previously counted as lost
+			s.lost--
+		}
+
+		transit := arrival.Sub(sent).Seconds()
+		if s.packets.Load() > 0 {
+			d := transit - s.prevTransit
+			if d < 0 {
+				d = -d
+			}
+			s.jitter += (d - s.jitter) / 16
+		}
+		s.prevTransit = transit
+		s.mu.Unlock()
+
+		s.packets.Add(1)
+	}
+}
+
+func (s *stream) result(elapsed float64) streamResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	packets := s.packets.Load()
+	if s.udp && !s.sender {
+		// receivers report the highest packet counter seen
+		packets = s.lastCount
+	}
+
+	return streamResult{
+		ID:          s.id,
+		Bytes:       s.bytes.Load(),
+		Retransmits: s.retransmits,
+		Jitter:      s.jitter,
+		Errors:      s.lost,
+		Packets:     packets,
+		EndTime:     elapsed,
+	}
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,182 @@
+package meta
+
+import (
+	"log"
+	"net"
+	"os"
+
+	"github.com/internet-equity/traceneck/internal/analysis"
+	"github.com/internet-equity/traceneck/internal/config"
+)
+
+// Analysis: measures derived from the rtt samples
+type Analysis struct {
+	Bufferbloat  *analysis.Bufferbloat    `json:"bufferbloat,omitempty" desc:"latency under load report [absent: no ping replies]"`
+	Aqm          *analysis.AqmFingerprint `json:"aqm,omitempty" desc:"likely queue discipline of the bottleneck [absent: no ping replies]"`
+	LatencyFlow  *analysis.FlowLatency    `json:"latency_flow,omitempty" desc:"latency of the sparse flow to the server [absent: no latency flow]"`
+	Queuing      *analysis.QueuingDelay   `json:"queuing,omitempty" desc:"per-hop queuing delay estimate [absent: no ping replies]"`
+	HopResponses []analysis.HopResponse   `json:"hop_responses,omitempty" desc:"icmp rate limiting and slow-path generation per hop"`
+	Path         *analysis.PathReport     `json:"path,omitempty" desc:"hidden hops, path asymmetry and tos remarking [absent: no reply headers]"`
+}
+
+// Analyze: analyses of rtt samples and of the latency flow, the ping target being target
+// and pings sent with tos probeTOS
+func Analyze(samples []RttSample, flow *LatencyFlow, target net.IP, probeTOS int) Analysis {
+	probes := Probes(samples, target)
+	responses := analysis.AnalyzeHopResponses(probes)
+
+	return Analysis{
+		Bufferbloat:  analysis.AnalyzeBufferbloat(probes),
+		LatencyFlow:  analysis.AnalyzeFlow(FlowProbes(flow)),
+		Queuing:      analysis.EstimateQueuing(probes, analysis.SlowPathHops(responses)),
+		HopResponses: responses,
+		Path:         analysis.AnalyzePath(probes, probeTOS),
+	}
+}
+
+// Probes: analysis probes of rtt samples
+func Probes(samples []RttSample, target net.IP) []analysis.Probe {
+	probes := make([]analysis.Probe, 0, len(samples))
+
+	for _, sample := range samples {
+		if sample.SendTime == 0 {
+			// placeholder of unresolved direct hop
+			continue
+		}
+
+		probe := analysis.Probe{
+			Hop:    sample.TTL,
+			Round:  sample.Round,
+			Time:   sample.SendTime,
+			RTT:    sample.RTT,
+			Lost:   sample.RecvTime == 0,
+			Target: target != nil && sample.ReplyIP.Equal(target),
+		}
+		switch sample.Phase {
+		case PhaseDownload, PhaseUpload, PhaseBidir:
+			probe.Load = sample.Phase
+		}
+		if sample.ReplyTTL != nil {
+			probe.ReplyTTL = *sample.ReplyTTL
+		}
+		if sample.QuotedTTL != nil && sample.QuotedTOS != nil {
+			probe.Quoted, probe.QuotedTTL, probe.QuotedTOS = true, *sample.QuotedTTL, *sample.QuotedTOS
+		}
+		probe.Mpls, probe.Interfaces = sample.Mpls, sample.Interfaces
+
+		probes = append(probes, probe)
+	}
+
+	return probes
+}
+
+// FlowProbes: analysis probes of the latency flow's round-trip, forward and reverse delays
+func FlowProbes(flow *LatencyFlow) (rtt, forward, reverse []analysis.Probe) {
+	if flow == nil {
+		return nil, nil, nil
+	}
+
+	for _, sample := range flow.Samples {
+		probe := analysis.Probe{
+			Time:   sample.SendTime,
+			RTT:    sample.RTT,
+			Lost:   sample.RecvTime == 0,
+			Target: true,
+		}
+		switch sample.Phase {
+		case PhaseDownload, PhaseUpload, PhaseBidir:
+			probe.Load = sample.Phase
+		}
+		rtt = append(rtt, probe)
+
+		if sample.ForwardDelay != nil && sample.ReverseDelay != nil {
+			probe.RTT = *sample.ForwardDelay
+			forward = append(forward, probe)
+			probe.RTT = *sample.ReverseDelay
+			reverse = append(reverse, probe)
+		}
+	}
+
+	return rtt, forward, reverse
+}
+
+// FingerprintAqm: fingerprint the bottleneck aqm of collected metadata, with speedtest
+// flow observations of the capture at capFile
+func FingerprintAqm(capFile string) {
+	if MetaD.Analysis == nil || MetaD.Analysis.Bufferbloat == nil {
+		return
+	}
+
+	var windows []analysis.Window
+	for _, span := range MetaD.Meta.Phases {
+		switch span.Phase {
+		case PhaseDownload, PhaseUpload, PhaseBidir:
+			windows = append(windows, analysis.Window{Start: span.StartTime, End: span.EndTime})
+		}
+	}
+
+	var (
+		capture *analysis.CaptureStats
+		err     error
+	)
+	// fingerprint by probes alone without a capture
+	if capFile != "" {
+		capture, err = analysis.AnalyzeCapture(capFile, MetaD.Meta.InterfaceIP, windows)
+	}
+	if err != nil {
+		// fingerprint by probes alone
+		log.Println("[analysis] error reading capture:", err)
+	}
+
+	samples := SortedSamples()
+	flow, _, _ := FlowProbes(MetaD.Measurements.LatencyFlow)
+	slowPath := analysis.SlowPathHops(MetaD.Analysis.HopResponses)
+	MetaD.Analysis.Aqm = analysis.FingerprintAqm(Probes(samples, config.ServerIP), flow, MetaD.Analysis.Bufferbloat, capture, slowPath)
+
+	log.Println("[analysis] aqm fingerprinted")
+}
+
+// FingerprintOf: aqm fingerprint of a run, as recorded, else of its rtt samples alone
+// [nil: neither recorded nor rtt samples]
+func FingerprintOf(metadata Metadata) *analysis.AqmFingerprint {
+	if metadata.Analysis != nil && metadata.Analysis.Aqm != nil {
+		return metadata.Analysis.Aqm
+	}
+
+	samples := metadata.Measurements.RttSamples
+	if len(samples) == 0 {
+		return nil
+	}
+	SortSamples(samples)
+
+	// the speedtest server address pinged, else (before it was recorded) an address of the
+	// tool's server, which ndt and ookla report by host name
+	server := metadata.Meta.ServerIP
+	if server == nil {
+		server = net.ParseIP(ServerOf(metadata))
+	}
+	probes := Probes(samples, server)
+	flow, _, _ := FlowProbes(metadata.Measurements.LatencyFlow)
+	slowPath := analysis.SlowPathHops(analysis.AnalyzeHopResponses(probes))
+	return analysis.FingerprintAqm(probes, flow, analysis.AnalyzeBufferbloat(probes), nil, slowPath)
+}
+
+// PrintSummary: print the latency under load report of collected metadata
+func PrintSummary() {
+	if MetaD.Analysis == nil {
+		return
+	}
+
+	os.Stderr.WriteString("\n")
+	if !config.NoPing {
+		analysis.WriteSummary(os.Stderr, MetaD.Analysis.Bufferbloat)
+		analysis.WriteHopResponses(os.Stderr, MetaD.Analysis.HopResponses)
+		analysis.WriteQueuing(os.Stderr, MetaD.Analysis.Queuing)
+		analysis.WritePath(os.Stderr, MetaD.Analysis.Path)
+		analysis.WriteAqm(os.Stderr, MetaD.Analysis.Aqm)
+	}
+	if flow := MetaD.Measurements.LatencyFlow; flow != nil {
+		analysis.WriteFlow(os.Stderr, flow.Protocol, MetaD.Analysis.LatencyFlow)
+	}
+	os.Stderr.WriteString("\n")
+}
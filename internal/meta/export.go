@@ -0,0 +1,271 @@
+/*
+ * export: flat exports of collected metadata for analysis tools
+ *
+ * rtt samples (sorted by send time) and throughput samples are written as csv, ndjson
+ * and/or influxdb line protocol, alongside a single-row csv summary of the run, and rtt
+ * samples and run summary as parquet; files are recorded in ExportFiles for archival
+ *
+ */
+package meta
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/internet-equity/traceneck/internal/analysis"
+	"github.com/internet-equity/traceneck/internal/config"
+	"github.com/internet-equity/traceneck/internal/influx"
+)
+
+var ExportFiles []string
+
+var (
+	rttColumns = []string{
+		"ttl", "round", "reply_ip", "send_time", "recv_time", "rtt", "phase", "icmp_seq_no", "udp_dest_port",
+		"reply_ttl", "reply_tos", "reply_ip_id", "quoted_ttl", "quoted_tos", "mpls_labels", "timestamp_source",
+	}
+	throughputColumns = []string{
+		"direction", "start_time", "end_time", "bytes", "bits_per_second",
+	}
+	summaryColumns = []string{
+		"time", "interface", "tool", "direction", "tool_start_time", "tool_end_time",
+		"speedtest_start_time", "speedtest_end_time", "ping_start_time", "ping_end_time",
+		"rtt_samples", "throughput_samples", "test_bytes_consumed", "bufferbloat_grade", "rpm",
+		"aqm", "aqm_confidence",
+	}
+)
+
+// SortedSamples: rtt samples ordered by send time, ttl and round
+func SortedSamples() []RttSample {
+	return slices.SortedFunc(maps.Values(MSamples), compareSamples)
+}
+
+// SortSamples: order rtt samples by send time, ttl and round
+func SortSamples(samples []RttSample) {
+	slices.SortFunc(samples, compareSamples)
+}
+
+func compareSamples(a, b RttSample) int {
+	return cmp.Or(
+		cmp.Compare(a.SendTime, b.SendTime),
+		cmp.Compare(a.TTL, b.TTL),
+		cmp.Compare(a.Round, b.Round),
+	)
+}
+
+// WriteExports: write exports in the configured formats
+func WriteExports() {
+	samples := SortedSamples()
+
+	for _, format := range config.Formats {
+		switch format {
+		case "csv":
+			writeExport("rtt_samples.csv", func(w io.Writer) error { return writeRttCSV(w, samples) })
+			writeExport("throughput.csv", func(w io.Writer) error { return writeThroughputCSV(w, MThroughput) })
+			writeExport("summary.csv", func(w io.Writer) error { return writeSummaryCSV(w, MetaD, len(samples)) })
+		case "parquet":
+			writeExport("rtt_samples.parquet", func(w io.Writer) error {
+				return writeParquet(w, RttSchema, RttRows(MetaD, samples))
+			})
+			writeExport("summary.parquet", func(w io.Writer) error {
+				return writeParquet(w, SummarySchema, [][]any{SummaryRow(MetaD, len(samples))})
+			})
+		case "influx":
+			writeExport("samples.lp", func(w io.Writer) error {
+				return influx.Write(w, InfluxPoints(MetaD, samples))
+			})
+		case "ndjson":
+			writeExport("rtt_samples.ndjson", func(w io.Writer) error { return writeNDJSON(w, samples) })
+			writeExport("throughput.ndjson", func(w io.Writer) error { return writeNDJSON(w, MThroughput) })
+		}
+	}
+}
+
+func writeExport(fileName string, write func(io.Writer) error) {
+	path := config.GetFilePath(fileName)
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatalln("[metadata] error opening export file:", err.Error())
+	}
+	defer file.Close()
+
+	if err := write(file); err != nil {
+		log.Fatalln("[metadata] error writing", fileName+":", err.Error())
+	}
+
+	ExportFiles = append(ExportFiles, path)
+	log.Println("[metadata] export written to:", path)
+}
+
+func writeRttCSV(w io.Writer, samples []RttSample) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(rttColumns); err != nil {
+		return err
+	}
+
+	for _, sample := range samples {
+		writer.Write([]string{
+			strconv.Itoa(sample.TTL),
+			strconv.Itoa(sample.Round),
+			ipString(sample.ReplyIP),
+			formatFloat(sample.SendTime),
+			formatFloat(sample.RecvTime),
+			formatFloat(sample.RTT),
+			sample.Phase,
+			optionalInt(sample.IcmpSeqNo),
+			optionalInt(sample.UdpDestPort),
+			optionalInt(sample.ReplyTTL),
+			optionalInt(sample.ReplyTOS),
+			optionalInt(sample.ReplyIPID),
+			optionalInt(sample.QuotedTTL),
+			optionalInt(sample.QuotedTOS),
+			analysis.LabelStack(sample.Mpls),
+			sample.TimestampSource,
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeThroughputCSV(w io.Writer, samples []ThroughputSample) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(throughputColumns); err != nil {
+		return err
+	}
+
+	for _, sample := range samples {
+		writer.Write([]string{
+			sample.Direction,
+			formatFloat(sample.StartTime),
+			formatFloat(sample.EndTime),
+			strconv.FormatUint(sample.Bytes, 10),
+			formatFloat(sample.BitsPerSecond),
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeSummaryCSV: header and single row of run metadata and tool measurements
+//
+// tool measurement columns take the json names of the tool's measurement fields.
+func writeSummaryCSV(w io.Writer, metadata Metadata, samples int) error {
+	m := metadata.Meta
+	grade, rpm := bufferbloatOf(metadata)
+	aqm, confidence := aqmOf(metadata)
+
+	header := slices.Clone(summaryColumns)
+	row := []string{
+		formatFloat(m.Time),
+		m.Interface,
+		config.Tool,
+		m.Direction,
+		formatFloat(m.ToolStartTime),
+		formatFloat(m.ToolEndTime),
+		formatFloat(m.SpeedtestStartTime),
+		formatFloat(m.SpeedtestEndTime),
+		formatFloat(m.PingStartTime),
+		formatFloat(m.PingEndTime),
+		strconv.Itoa(samples),
+		strconv.Itoa(len(metadata.Measurements.Throughput)),
+		strconv.FormatInt(metadata.Measurements.BytesConsumed, 10),
+		grade,
+		formatFloat(rpm),
+		aqm,
+		formatFloat(confidence),
+	}
+
+	for _, measure := range []any{
+		metadata.Measurements.Ndt7,
+		metadata.Measurements.Ookla,
+		metadata.Measurements.OoklaHttp,
+		metadata.Measurements.Iperf,
+	} {
+		names, values := flatten(measure)
+		header = append(header, names...)
+		row = append(row, values...)
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Write(header)
+	writer.Write(row)
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeNDJSON[T any](w io.Writer, samples []T) error {
+	encoder := json.NewEncoder(w)
+
+	for _, sample := range samples {
+		if err := encoder.Encode(sample); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// flatten: json field names and formatted values of a (non-nil) measurement struct
+func flatten(measure any) (names, values []string) {
+	v := reflect.ValueOf(measure)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return nil, nil
+	}
+	v = v.Elem()
+
+	for i := range v.NumField() {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		var value string
+		switch field := v.Field(i).Interface().(type) {
+		case float64:
+			value = formatFloat(field)
+		case net.IP:
+			value = ipString(field)
+		default:
+			value = fmt.Sprint(field)
+		}
+
+		names = append(names, name)
+		values = append(values, value)
+	}
+
+	return names, values
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func optionalInt(i *int) string {
+	if i == nil {
+		return ""
+	}
+	return strconv.Itoa(*i)
+}
@@ -1,98 +1,189 @@
 package meta
 
 import (
+	"crypto/rand"
+	"crypto/sha1"
 	"encoding/json"
+	"fmt"
 	"log"
-	"maps"
 	"net"
 	"os"
 	"slices"
 
+	"github.com/internet-equity/traceneck/internal/analysis"
 	"github.com/internet-equity/traceneck/internal/config"
 	timeUtil "github.com/internet-equity/traceneck/internal/util/time"
 )
 
+// SchemaVersion: version of the metadata format, incremented on any change to its keys
+//
+// version 1 (unversioned) files are upgraded by the migrate command.
+const SchemaVersion = 14
+
+// struct tags `desc` document fields in the generated json schema
+
 type MeasureNdt struct {
-	Download        float64 `json:"speedtest_ndt7_download"`
-	DownloadLatency float64 `json:"speedtest_ndt7_downloadlatency"`
-	DownloadRetrans float64 `json:"speedtest_ndt7_downloadretrans"`
-	Server          string  `json:"speedtest_ndt7_server"`
-	ServerIP        net.IP  `json:"speedtest_ndt7_server_ip"`
-	Upload          float64 `json:"speedtest_ndt7_upload"`
+	Download        float64 `json:"speedtest_ndt7_download" desc:"download throughput (Mbit/s)"`
+	DownloadLatency float64 `json:"speedtest_ndt7_downloadlatency" desc:"download latency (ms)"`
+	DownloadRetrans float64 `json:"speedtest_ndt7_downloadretrans" desc:"download retransmission rate (fraction)"`
+	Server          string  `json:"speedtest_ndt7_server" desc:"server hostname"`
+	ServerIP        net.IP  `json:"speedtest_ndt7_server_ip" desc:"server address"`
+	Upload          float64 `json:"speedtest_ndt7_upload" desc:"upload throughput (Mbit/s)"`
 }
 
 type MeasureOokla struct {
-	Download   float64 `json:"speedtest_ookla_download"`
-	Jitter     float64 `json:"speedtest_ookla_jitter"`
-	Latency    float64 `json:"speedtest_ookla_latency"`
-	PktLoss2   float64 `json:"speedtest_ookla_pktloss2"`
-	ServerHost string  `json:"speedtest_ookla_server_host"`
-	ServerId   int     `json:"speedtest_ookla_server_id"`
-	ServerName string  `json:"speedtest_ookla_server_name"`
-	Upload     float64 `json:"speedtest_ookla_upload"`
+	Download   float64 `json:"speedtest_ookla_download" desc:"download throughput (Mbit/s)"`
+	Jitter     float64 `json:"speedtest_ookla_jitter" desc:"idle latency jitter (ms)"`
+	Latency    float64 `json:"speedtest_ookla_latency" desc:"idle latency (ms)"`
+	PktLoss2   float64 `json:"speedtest_ookla_pktloss2" desc:"packet loss (percent)"`
+	ServerHost string  `json:"speedtest_ookla_server_host" desc:"server host:port"`
+	ServerId   int     `json:"speedtest_ookla_server_id" desc:"speedtest.net server id"`
+	ServerName string  `json:"speedtest_ookla_server_name" desc:"server name"`
+	Upload     float64 `json:"speedtest_ookla_upload" desc:"upload throughput (Mbit/s)"`
 }
 
 type MeasureOoklaHttp struct {
-	Download   float64 `json:"speedtest_ookla_download"`
-	Latency    float64 `json:"speedtest_ookla_latency"`
-	ServerHost string  `json:"speedtest_ookla_server_host"`
-	ServerId   string  `json:"speedtest_ookla_server_id"`
-	ServerName string  `json:"speedtest_ookla_server_name"`
-	Upload     float64 `json:"speedtest_ookla_upload"`
+	Download   float64 `json:"speedtest_ooklahttp_download" desc:"download throughput (Mbit/s)"`
+	Latency    float64 `json:"speedtest_ooklahttp_latency" desc:"idle latency (ms)"`
+	ServerHost string  `json:"speedtest_ooklahttp_server_host" desc:"server host:port"`
+	ServerId   string  `json:"speedtest_ooklahttp_server_id" desc:"speedtest.net server id"`
+	ServerName string  `json:"speedtest_ooklahttp_server_name" desc:"server name"`
+	Upload     float64 `json:"speedtest_ooklahttp_upload" desc:"upload throughput (Mbit/s)"`
 }
 
 type MeasureIperf struct {
-	Download float64 `json:"speedtest_iperf_download"`
-	Upload   float64 `json:"speedtest_iperf_upload"`
+	Download        float64 `json:"speedtest_iperf_download" desc:"download throughput (bit/s)"`
+	DownloadRetrans int     `json:"speedtest_iperf_downloadretrans" desc:"download tcp retransmits [-1: unavailable]"`
+	DownloadJitter  float64 `json:"speedtest_iperf_downloadjitter,omitempty" desc:"download udp jitter (ms)"`
+	DownloadLoss    float64 `json:"speedtest_iperf_downloadloss,omitempty" desc:"download udp loss (percent)"`
+	Upload          float64 `json:"speedtest_iperf_upload" desc:"upload throughput (bit/s)"`
+	UploadRetrans   int     `json:"speedtest_iperf_uploadretrans" desc:"upload tcp retransmits [-1: unavailable]"`
+	UploadJitter    float64 `json:"speedtest_iperf_uploadjitter,omitempty" desc:"upload udp jitter (ms)"`
+	UploadLoss      float64 `json:"speedtest_iperf_uploadloss,omitempty" desc:"upload udp loss (percent)"`
+	Protocol        string  `json:"speedtest_iperf_protocol" desc:"tcp or udp"`
+	Streams         int     `json:"speedtest_iperf_streams" desc:"number of data streams"`
+	Congestion      string  `json:"speedtest_iperf_congestion,omitempty" desc:"tcp congestion control algorithm"`
+	ServerIP        net.IP  `json:"speedtest_iperf_server_ip" desc:"server address"`
+}
+
+// ThroughputSample: throughput over one reporting interval of the speedtest
+type ThroughputSample struct {
+	Direction     string  `json:"direction" desc:"download or upload"`
+	StartTime     float64 `json:"start_time" desc:"interval start (unix seconds)"`
+	EndTime       float64 `json:"end_time" desc:"interval end (unix seconds)"`
+	Bytes         uint64  `json:"bytes" desc:"bytes transferred over the interval"`
+	BitsPerSecond float64 `json:"bits_per_second" desc:"throughput over the interval (bit/s)"`
 }
 
 type RttSample struct {
-	TTL         int     `json:"ttl"`
-	Round       int     `json:"round"`
-	ReplyIP     net.IP  `json:"reply_ip"`
-	SendTime    float64 `json:"send_time"`
-	RecvTime    float64 `json:"recv_time"`
-	RTT         float64 `json:"rtt"`
-	IcmpSeqNo   *int    `json:"icmp_seq_no,omitempty"`
-	UdpDestPort *int    `json:"udp_dest_port,omitempty"`
+	TTL         int     `json:"ttl" desc:"probe ttl (hop)"`
+	Round       int     `json:"round" desc:"probe round [0: direct hop summary]"`
+	ReplyIP     net.IP  `json:"reply_ip" desc:"address of the replying hop [empty: no reply]"`
+	SendTime    float64 `json:"send_time" desc:"probe send time (unix seconds)"`
+	RecvTime    float64 `json:"recv_time" desc:"reply receive time (unix seconds) [0: no reply]"`
+	RTT         float64 `json:"rtt" desc:"round-trip time (ms) [0: no reply]"`
+	Phase       string  `json:"phase,omitempty" desc:"test phase in which the probe was sent"`
+	IcmpSeqNo   *int    `json:"icmp_seq_no,omitempty" desc:"icmp echo sequence number (icmp pings)"`
+	UdpDestPort *int    `json:"udp_dest_port,omitempty" desc:"udp destination port (udp pings)"`
+
+	TimestampSource string `json:"timestamp_source,omitempty" desc:"source of the send and receive timestamps: hardware (nic, rtt only), software (kernel), user (user space) or mixed [absent: no reply]"`
+
+	ReplyTTL  *int `json:"reply_ttl,omitempty" desc:"remaining ttl (hop limit) of the reply [absent: no reply or unavailable]"`
+	ReplyTOS  *int `json:"reply_tos,omitempty" desc:"tos (traffic class) of the reply [absent: no reply or unavailable]"`
+	ReplyIPID *int `json:"reply_ip_id,omitempty" desc:"ip id of the reply [absent: no reply or ipv6]"`
+	QuotedTTL *int `json:"quoted_ttl,omitempty" desc:"ttl (hop limit) of the probe as quoted by the hop [absent: no reply or echo reply]"`
+	QuotedTOS *int `json:"quoted_tos,omitempty" desc:"tos (traffic class) of the probe as quoted by the hop [absent: no reply or echo reply]"`
+
+	Mpls       []analysis.MplsLabel    `json:"mpls,omitempty" desc:"mpls label stack of the probe as received by the hop, from icmp extensions (rfc 4950)"`
+	Interfaces []analysis.HopInterface `json:"interfaces,omitempty" desc:"interfaces of the hop, from icmp extensions (rfc 5837)"`
+}
+
+// HopProbes: probes sent to a hop, and those without reply
+type HopProbes struct {
+	TTL     int  `json:"ttl" desc:"probe ttl (hop)"`
+	Direct  bool `json:"direct,omitempty" desc:"icmp echo probes of the direct hop"`
+	Total   int  `json:"total" desc:"probes sent"`
+	Dropped int  `json:"dropped" desc:"probes without reply"`
+}
+
+// FlowSample: probe of the sparse latency flow to the server
+type FlowSample struct {
+	Seq          int      `json:"seq" desc:"probe sequence number"`
+	SendTime     float64  `json:"send_time" desc:"probe send time (unix seconds)"`
+	RecvTime     float64  `json:"recv_time" desc:"reply receive time (unix seconds) [0: no reply]"`
+	RTT          float64  `json:"rtt" desc:"round-trip time (ms) [0: no reply]"`
+	ForwardDelay *float64 `json:"forward_delay,omitempty" desc:"one-way delay to the reflector (ms), offset by the clock difference [absent: tcp or no reply]"`
+	ReverseDelay *float64 `json:"reverse_delay,omitempty" desc:"one-way delay from the reflector (ms), offset by the clock difference [absent: tcp or no reply]"`
+	Phase        string   `json:"phase,omitempty" desc:"test phase in which the probe was sent"`
+}
+
+// LatencyFlow: sparse flow to the server alongside the speedtest
+type LatencyFlow struct {
+	Protocol string       `json:"protocol" desc:"tcp (handshake rtt) or udp (twamp-light)"`
+	Address  string       `json:"address" desc:"server address (<ip>:<port>)"`
+	Samples  []FlowSample `json:"samples" desc:"probes in send order"`
 }
 
 type Measurements struct {
-	Ndt7          *MeasureNdt   	`json:"ndt7,omitempty"`
-	Ookla         *MeasureOokla 	`json:"ookla,omitempty"`
-	OoklaHttp     *MeasureOoklaHttp `json:"ooklahttp,omitempty"`
-	Iperf         *MeasureIperf 	`json:"iperf,omitempty"`
-	RttSamples    []RttSample   	`json:"rtt_samples"`
-	BytesConsumed int64         	`json:"test_bytes_consumed"`
+	Ndt7          *MeasureNdt        `json:"ndt7,omitempty" desc:"ndt7 measurements"`
+	Ookla         *MeasureOokla      `json:"ookla,omitempty" desc:"ookla cli measurements"`
+	OoklaHttp     *MeasureOoklaHttp  `json:"ooklahttp,omitempty" desc:"ookla http measurements"`
+	Iperf         *MeasureIperf      `json:"iperf,omitempty" desc:"iperf3 measurements"`
+	RttSamples    []RttSample        `json:"rtt_samples" desc:"rtt samples [null: terse metadata]"`
+	Throughput    []ThroughputSample `json:"throughput_samples,omitempty" desc:"throughput per reporting interval"`
+	BytesConsumed int64              `json:"test_bytes_consumed" desc:"bytes transferred by the speedtest"`
+
+	LatencyFlow *LatencyFlow `json:"latency_flow,omitempty" desc:"sparse latency flow measurements [absent: no latency flow]"`
+	HopProbes   []HopProbes  `json:"hop_probes,omitempty" desc:"probes sent and dropped per hop [absent: no pings]"`
 }
 
 type Meta struct {
-	ID                 string   `json:"Id"`
-	Time               float64  `json:"Time"`
-	ToolStartTime      float64  `json:"Tool_start_time"`
-	ToolEndTime        float64  `json:"Tool_end_time"`
-	SpeedtestStartTime float64  `json:"Speedtest_start_time"`
-	SpeedtestEndTime   float64  `json:"Speedtest_end_time"`
-	PingStartTime      float64  `json:"Ping_start_time"`
-	PingEndTime        float64  `json:"Ping_end_time"`
-	Interface          string   `json:"Interface"`
-	InterfaceIP        []net.IP `json:"Interface_ip"`
+	ID                 string      `json:"id" desc:"unique run id"`
+	Time               float64     `json:"time" desc:"run timestamp (unix seconds)"`
+	ToolStartTime      float64     `json:"tool_start_time" desc:"traceneck start (unix seconds)"`
+	ToolEndTime        float64     `json:"tool_end_time" desc:"traceneck end (unix seconds)"`
+	SpeedtestStartTime float64     `json:"speedtest_start_time" desc:"speedtest start (unix seconds)"`
+	SpeedtestEndTime   float64     `json:"speedtest_end_time" desc:"speedtest end (unix seconds)"`
+	PingStartTime      float64     `json:"ping_start_time" desc:"pings start (unix seconds)"`
+	PingEndTime        float64     `json:"ping_end_time" desc:"pings end (unix seconds)"`
+	Interface          string      `json:"interface" desc:"measurement interface"`
+	InterfaceIP        []net.IP    `json:"interface_ip" desc:"interface addresses"`
+	Direction          string      `json:"direction" desc:"test direction: download, upload or both"`
+	Phases             []PhaseSpan `json:"phases" desc:"test phase timeline"`
+	ProbeTOS           int         `json:"probe_tos,omitempty" desc:"tos (traffic class) of pings [absent: 0]"`
+	ProbeInterval      float64     `json:"probe_interval,omitempty" desc:"(mean) interval between probes of a hop (ms) [absent: no pings]"`
+	ProbeSpacing       string      `json:"probe_spacing,omitempty" desc:"probe spacing: periodic or poisson [absent: no pings]"`
+	ProbeSize          int         `json:"probe_size,omitempty" desc:"probe payload size (bytes) [absent: 0]"`
+	PingSocket         string      `json:"ping_socket,omitempty" desc:"ping socket: raw, or unprivileged datagram [absent: no pings]"`
+	NoCapture          bool        `json:"no_capture,omitempty" desc:"whether capture was skipped, lacking permission"`
+	ServerIP           net.IP      `json:"server_ip,omitempty" desc:"server address pinged [absent: no server address grabbed]"`
+
+	Tags map[string]string `json:"tags,omitempty" desc:"user-supplied key=value tags"`
 }
 
 type Metadata struct {
-	Measurements Measurements `json:"Measurements"`
-	Meta         Meta         `json:"Meta"`
+	SchemaVersion int          `json:"schema_version" desc:"metadata format version"`
+	Measurements  Measurements `json:"measurements"`
+	Meta          Meta         `json:"meta"`
+
+	Analysis *Analysis `json:"analysis,omitempty" desc:"measures derived from rtt samples"`
 }
 
 var (
-	MNdt   MeasureNdt
-	MOokla MeasureOokla
+	MNdt       MeasureNdt
+	MOokla     MeasureOokla
 	MOoklaHttp MeasureOoklaHttp
-	MIperf MeasureIperf
+	MIperf     MeasureIperf
 
 	MSamples       = make(map[int]RttSample)
 	MBytes   int64 = 0
 
+	MThroughput []ThroughputSample
+
+	MFlow *LatencyFlow
+
+	MHopProbes []HopProbes
+
 	MMeta Meta
 	MetaD Metadata
 
@@ -101,37 +192,104 @@ var (
 
 func Init() {
 	MMeta = Meta{
+		ID:            NewRunID(),
 		Time:          timeUtil.UnixPrecise(config.Timestamp),
 		ToolStartTime: timeUtil.UnixNow(),
 		Interface:     config.Interface,
 		InterfaceIP:   config.InterfaceIP,
+		Direction:     config.Direction,
+		ProbeTOS:      config.ProbeTOS,
+		NoCapture:     config.NoCapture,
+		Tags:          config.Tags,
+	}
+
+	if !config.NoPing {
+		MMeta.ProbeInterval = float64(config.ProbeInterval.Nanoseconds()) / 1e6
+		MMeta.ProbeSpacing = "periodic"
+		if config.ProbePoisson {
+			MMeta.ProbeSpacing = "poisson"
+		}
+		MMeta.ProbeSize = config.ProbeSize
+		MMeta.PingSocket = config.PingSocket
 	}
 
 	log.Println("[metadata] init")
 }
 
+// runIDNamespace: uuid namespace of run ids derived from run time and interface
+var runIDNamespace = []byte{0x35, 0x49, 0x4e, 0xf5, 0xa8, 0x9d, 0x40, 0x65, 0xa2, 0x0d, 0x7c, 0xbd, 0x13, 0x8a, 0xb4, 0xde}
+
+// NewRunID: random (version 4) uuid identifying a run
+func NewRunID() string {
+	var id [16]byte
+	rand.Read(id[:])
+
+	return formatUUID(id, 4)
+}
+
+// DerivedRunID: name-based (version 5) uuid identifying a run of unrecorded id, of its
+// time and interface, such that the run's files are given the same id
+func DerivedRunID(t float64, iface string) string {
+	name := timeUtil.FromUnix(t).UTC().Format(timeUtil.RunIDFormat) + "-" + iface
+	sum := sha1.Sum(append(slices.Clone(runIDNamespace), name...))
+
+	return formatUUID([16]byte(sum[:16]), 5)
+}
+
+func formatUUID(id [16]byte, version byte) string {
+	id[6] = id[6]&0x0f | version<<4
+	id[8] = id[8]&0x3f | 0x80 // rfc 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
 func Collect() {
 	MMeta.ToolEndTime = timeUtil.UnixNow()
+	MMeta.Phases = phases(MMeta.ToolStartTime, MMeta.ToolEndTime)
+	MMeta.ServerIP = config.ServerIP
+
+	for pktNo, sample := range MSamples {
+		if sample.SendTime != 0 {
+			sample.Phase = phaseAt(MMeta.Phases, sample.SendTime)
+			MSamples[pktNo] = sample
+		}
+	}
+
+	if MFlow != nil {
+		for i, sample := range MFlow.Samples {
+			MFlow.Samples[i].Phase = phaseAt(MMeta.Phases, sample.SendTime)
+		}
+	}
 
 	MetaD = Metadata{
+		SchemaVersion: SchemaVersion,
 		Measurements: Measurements{
 			BytesConsumed: MBytes,
+			Throughput:    MThroughput,
+			LatencyFlow:   MFlow,
+			HopProbes:     MHopProbes,
 		},
 		Meta: MMeta,
 	}
 
 	if config.Tool == "ndt" {
 		MetaD.Measurements.Ndt7 = &MNdt
-	} else if config.Tool == "ookla" {	
+	} else if config.Tool == "ookla" {
 		MetaD.Measurements.Ookla = &MOokla
-	} else if config.Tool == "ookla-http" {	
+	} else if config.Tool == "ookla-http" {
 		MetaD.Measurements.OoklaHttp = &MOoklaHttp
-	}else if config.Tool == "iperf" {
+	} else if config.Tool == "iperf" {
 		MetaD.Measurements.Iperf = &MIperf
 	}
 
+	samples := SortedSamples()
 	if !config.Terse {
-		MetaD.Measurements.RttSamples = slices.Collect(maps.Values(MSamples))
+		MetaD.Measurements.RttSamples = samples
+	}
+
+	if !config.NoPing || MFlow != nil {
+		analyses := Analyze(samples, MFlow, config.ServerIP, MMeta.ProbeTOS)
+		MetaD.Analysis = &analyses
 	}
 
 	log.Println("[metadata] collected")
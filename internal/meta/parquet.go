@@ -0,0 +1,276 @@
+/*
+ * parquet: rtt sample and run summary tables
+ *
+ * schemas are stable across tools: tool measurements are normalized into common summary
+ * columns (throughput in Mbit/s, latency in ms) and tags are encoded as a json object
+ *
+ */
+package meta
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"time"
+
+	"github.com/internet-equity/traceneck/internal/analysis"
+	"github.com/internet-equity/traceneck/internal/parquet"
+	timeUtil "github.com/internet-equity/traceneck/internal/util/time"
+)
+
+var RttSchema = []parquet.Column{
+	{Name: "run_id", Type: parquet.String},
+	{Name: "run_time", Type: parquet.Double},
+	{Name: "tool", Type: parquet.String},
+	{Name: "interface", Type: parquet.String},
+	{Name: "ttl", Type: parquet.Int32},
+	{Name: "round", Type: parquet.Int32},
+	{Name: "reply_ip", Type: parquet.String, Optional: true},
+	{Name: "send_time", Type: parquet.Double},
+	{Name: "recv_time", Type: parquet.Double, Optional: true},
+	{Name: "rtt", Type: parquet.Double, Optional: true},
+	{Name: "phase", Type: parquet.String, Optional: true},
+	{Name: "icmp_seq_no", Type: parquet.Int32, Optional: true},
+	{Name: "udp_dest_port", Type: parquet.Int32, Optional: true},
+	{Name: "reply_ttl", Type: parquet.Int32, Optional: true},
+	{Name: "reply_tos", Type: parquet.Int32, Optional: true},
+	{Name: "reply_ip_id", Type: parquet.Int32, Optional: true},
+	{Name: "quoted_ttl", Type: parquet.Int32, Optional: true},
+	{Name: "quoted_tos", Type: parquet.Int32, Optional: true},
+	{Name: "mpls_labels", Type: parquet.String, Optional: true},
+	{Name: "timestamp_source", Type: parquet.String, Optional: true},
+	{Name: "tags", Type: parquet.String, Optional: true},
+}
+
+var SummarySchema = []parquet.Column{
+	{Name: "run_id", Type: parquet.String},
+	{Name: "run_time", Type: parquet.Double},
+	{Name: "tool", Type: parquet.String},
+	{Name: "interface", Type: parquet.String},
+	{Name: "direction", Type: parquet.String, Optional: true},
+	{Name: "server", Type: parquet.String, Optional: true},
+	{Name: "download_mbps", Type: parquet.Double, Optional: true},
+	{Name: "upload_mbps", Type: parquet.Double, Optional: true},
+	{Name: "latency_ms", Type: parquet.Double, Optional: true},
+	{Name: "speedtest_start_time", Type: parquet.Double},
+	{Name: "speedtest_end_time", Type: parquet.Double},
+	{Name: "ping_start_time", Type: parquet.Double},
+	{Name: "ping_end_time", Type: parquet.Double},
+	{Name: "test_bytes_consumed", Type: parquet.Int64},
+	{Name: "rtt_samples", Type: parquet.Int64},
+	{Name: "throughput_samples", Type: parquet.Int64},
+	{Name: "bufferbloat_grade", Type: parquet.String, Optional: true},
+	{Name: "rpm", Type: parquet.Double, Optional: true},
+	{Name: "aqm", Type: parquet.String, Optional: true},
+	{Name: "aqm_confidence", Type: parquet.Double, Optional: true},
+	{Name: "tags", Type: parquet.String, Optional: true},
+}
+
+// ToolOf: tool by which the measurements were taken
+func ToolOf(metadata Metadata) string {
+	switch m := metadata.Measurements; {
+	case m.Ndt7 != nil:
+		return "ndt"
+	case m.Ookla != nil:
+		return "ookla"
+	case m.OoklaHttp != nil:
+		return "ookla-http"
+	case m.Iperf != nil:
+		return "iperf"
+	default:
+		return ""
+	}
+}
+
+// ServerOf: server against which the measurements were taken
+func ServerOf(metadata Metadata) string {
+	switch m := metadata.Measurements; {
+	case m.Ndt7 != nil:
+		return m.Ndt7.Server
+	case m.Ookla != nil:
+		return m.Ookla.ServerHost
+	case m.OoklaHttp != nil:
+		return m.OoklaHttp.ServerHost
+	case m.Iperf != nil && m.Iperf.ServerIP != nil:
+		return m.Iperf.ServerIP.String()
+	default:
+		return ""
+	}
+}
+
+// BitsPerSecond: download and upload throughput of the tool measurements
+func BitsPerSecond(metadata Metadata) (download, upload float64, ok bool) {
+	switch m := metadata.Measurements; {
+	case m.Ndt7 != nil:
+		return m.Ndt7.Download * 1e6, m.Ndt7.Upload * 1e6, true
+	case m.Ookla != nil:
+		return m.Ookla.Download * 1e6, m.Ookla.Upload * 1e6, true
+	case m.OoklaHttp != nil:
+		return m.OoklaHttp.Download * 1e6, m.OoklaHttp.Upload * 1e6, true
+	case m.Iperf != nil:
+		// iperf throughput in bits per second
+		return m.Iperf.Download, m.Iperf.Upload, true
+	}
+	return 0, 0, false
+}
+
+// RunID: run identifier, derived from run time and interface where not recorded
+func RunID(metadata Metadata) string {
+	if metadata.Meta.ID != "" {
+		return metadata.Meta.ID
+	}
+	return DerivedRunID(metadata.Meta.Time, metadata.Meta.Interface)
+}
+
+// RunTime: run timestamp
+func RunTime(metadata Metadata) time.Time {
+	return timeUtil.FromUnix(metadata.Meta.Time)
+}
+
+// RttRows: rtt sample rows of RttSchema
+func RttRows(metadata Metadata, samples []RttSample) [][]any {
+	runID, tool, tags := RunID(metadata), ToolOf(metadata), tagsValue(metadata.Meta.Tags)
+
+	rows := make([][]any, 0, len(samples))
+	for _, sample := range samples {
+		rows = append(rows, []any{
+			runID,
+			metadata.Meta.Time,
+			tool,
+			metadata.Meta.Interface,
+			sample.TTL,
+			sample.Round,
+			ipValue(sample.ReplyIP),
+			sample.SendTime,
+			nonZero(sample.RecvTime),
+			nonZero(sample.RTT),
+			stringValue(sample.Phase),
+			intValue(sample.IcmpSeqNo),
+			intValue(sample.UdpDestPort),
+			intValue(sample.ReplyTTL),
+			intValue(sample.ReplyTOS),
+			intValue(sample.ReplyIPID),
+			intValue(sample.QuotedTTL),
+			intValue(sample.QuotedTOS),
+			stringValue(analysis.LabelStack(sample.Mpls)),
+			stringValue(sample.TimestampSource),
+			tags,
+		})
+	}
+
+	return rows
+}
+
+// SummaryRow: run summary row of SummarySchema
+func SummaryRow(metadata Metadata, samples int) []any {
+	var download, upload, latency any
+
+	switch m := metadata.Measurements; {
+	case m.Ndt7 != nil:
+		download, upload, latency = m.Ndt7.Download, m.Ndt7.Upload, m.Ndt7.DownloadLatency
+	case m.Ookla != nil:
+		download, upload, latency = m.Ookla.Download, m.Ookla.Upload, m.Ookla.Latency
+	case m.OoklaHttp != nil:
+		download, upload, latency = m.OoklaHttp.Download, m.OoklaHttp.Upload, m.OoklaHttp.Latency
+	case m.Iperf != nil:
+		// iperf throughput in bits per second
+		download, upload = m.Iperf.Download/1e6, m.Iperf.Upload/1e6
+	}
+
+	m := metadata.Meta
+	grade, rpm := bufferbloatOf(metadata)
+	aqm, confidence := aqmOf(metadata)
+
+	return []any{
+		RunID(metadata),
+		m.Time,
+		ToolOf(metadata),
+		m.Interface,
+		stringValue(m.Direction),
+		stringValue(ServerOf(metadata)),
+		download,
+		upload,
+		latency,
+		m.SpeedtestStartTime,
+		m.SpeedtestEndTime,
+		m.PingStartTime,
+		m.PingEndTime,
+		metadata.Measurements.BytesConsumed,
+		int64(samples),
+		int64(len(metadata.Measurements.Throughput)),
+		stringValue(grade),
+		nonZero(rpm),
+		stringValue(aqm),
+		nonZero(confidence),
+		tagsValue(m.Tags),
+	}
+}
+
+// bufferbloatOf: bufferbloat grade and responsiveness (rpm) where analyzed
+func bufferbloatOf(metadata Metadata) (grade string, rpm float64) {
+	if metadata.Analysis == nil || metadata.Analysis.Bufferbloat == nil {
+		return "", 0
+	}
+	return metadata.Analysis.Bufferbloat.Grade, metadata.Analysis.Bufferbloat.RPM
+}
+
+// aqmOf: likely bottleneck aqm and its confidence where fingerprinted
+func aqmOf(metadata Metadata) (aqm string, confidence float64) {
+	if metadata.Analysis == nil || metadata.Analysis.Aqm == nil {
+		return "", 0
+	}
+	return metadata.Analysis.Aqm.AQM, metadata.Analysis.Aqm.Confidence
+}
+
+// writeParquet: write rows of schema as a parquet file
+func writeParquet(w io.Writer, schema []parquet.Column, rows [][]any) error {
+	writer, err := parquet.NewWriter(w, schema)
+	if err != nil {
+		return err
+	}
+	writer.CreatedBy = "traceneck"
+
+	for _, row := range rows {
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+func nonZero(f float64) any {
+	if f == 0 {
+		return nil
+	}
+	return f
+}
+
+func stringValue(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func intValue(i *int) any {
+	if i == nil {
+		return nil
+	}
+	return *i
+}
+
+func ipValue(ip net.IP) any {
+	if ip == nil {
+		return nil
+	}
+	return ip.String()
+}
+
+func tagsValue(tags map[string]string) any {
+	if len(tags) == 0 {
+		return nil
+	}
+	encoded, _ := json.Marshal(tags)
+	return string(encoded)
+}
@@ -0,0 +1,134 @@
+/*
+ * phase: speedtest phase timeline
+ *
+ * baseline and load phases are marked as they are observed; idle phases are derived
+ * around them when metadata is collected, and rtt samples are tagged by the phase in
+ * which they were sent; marks are also published to the packet capture, which annotates
+ * the capture with them
+ *
+ */
+package meta
+
+import (
+	timeUtil "github.com/internet-equity/traceneck/internal/util/time"
+)
+
+const (
+	PhaseIdlePre  = "idle-pre"      // before any load
+	PhaseBaseline = "baseline"      // pre-speedtest idle window with pings running
+	PhaseDownload = "download"      // server to client load
+	PhaseUpload   = "upload"        // client to server load
+	PhaseBidir    = "bidirectional" // simultaneous download and upload load
+	PhaseIdle     = "idle"          // between load phases
+	PhaseIdlePost = "idle-post"     // after all load
+)
+
+type PhaseSpan struct {
+	Phase     string  `json:"phase" desc:"idle-pre, baseline, download, upload, bidirectional, idle or idle-post"`
+	StartTime float64 `json:"start_time" desc:"phase start (unix seconds)"`
+	EndTime   float64 `json:"end_time" desc:"phase end (unix seconds)"`
+}
+
+// MMarked: baseline and load phases as marked by traceneck and speedtest clients
+var MMarked []PhaseSpan
+
+// PhaseMark: start or end of a marked phase
+type PhaseMark struct {
+	Phase string
+	Start bool
+	Time  float64 // unix seconds
+}
+
+// PhaseMarks: marks as they are made, those beyond its capacity being dropped
+var PhaseMarks = make(chan PhaseMark, 16)
+
+// publish: publish a mark, without blocking on a full channel
+func publish(mark PhaseMark) {
+	select {
+	case PhaseMarks <- mark:
+	default:
+	}
+}
+
+// PhaseStart: mark the start of a phase
+func PhaseStart(phase string) {
+	PhaseStartAt(phase, timeUtil.UnixNow())
+}
+
+// PhaseStartAt: mark the start of a phase at unix time t
+func PhaseStartAt(phase string, t float64) {
+	if n := len(MMarked); n > 0 && MMarked[n-1].EndTime == 0 {
+		// implicitly end a still-open phase
+		MMarked[n-1].EndTime = t
+	}
+	MMarked = append(MMarked, PhaseSpan{Phase: phase, StartTime: t})
+	publish(PhaseMark{Phase: phase, Start: true, Time: t})
+}
+
+// PhaseEnd: mark the end of a phase
+func PhaseEnd(phase string) {
+	PhaseEndAt(phase, timeUtil.UnixNow())
+}
+
+// PhaseEndAt: mark the end of a phase at unix time t
+func PhaseEndAt(phase string, t float64) {
+	for i := len(MMarked) - 1; i >= 0; i-- {
+		if MMarked[i].Phase == phase && MMarked[i].EndTime == 0 {
+			MMarked[i].EndTime = t
+			publish(PhaseMark{Phase: phase, Time: t})
+			return
+		}
+	}
+}
+
+// phases: complete timeline from start to end, with idle phases around marked phases
+func phases(start, end float64) []PhaseSpan {
+	var timeline []PhaseSpan
+
+	cursor := start
+	for i, marked := range MMarked {
+		if marked.EndTime == 0 {
+			// unterminated: assume marked until the speedtest ended
+			marked.EndTime = max(MMeta.SpeedtestEndTime, marked.StartTime)
+		}
+
+		if marked.StartTime > cursor {
+			idle := PhaseIdle
+			if i == 0 {
+				idle = PhaseIdlePre
+			}
+			timeline = append(timeline, PhaseSpan{Phase: idle, StartTime: cursor, EndTime: marked.StartTime})
+		}
+
+		timeline = append(timeline, marked)
+		cursor = max(cursor, marked.EndTime)
+	}
+
+	idle := PhaseIdlePost
+	if len(MMarked) == 0 {
+		// nothing observed: the whole run is idle
+		idle = PhaseIdle
+	}
+	if end > cursor {
+		timeline = append(timeline, PhaseSpan{Phase: idle, StartTime: cursor, EndTime: end})
+	}
+
+	return timeline
+}
+
+// phaseAt: phase of timeline in which unix time t falls
+func phaseAt(timeline []PhaseSpan, t float64) string {
+	for _, span := range timeline {
+		if t >= span.StartTime && t < span.EndTime {
+			return span.Phase
+		}
+	}
+
+	if len(timeline) > 0 && t < timeline[0].StartTime {
+		return timeline[0].Phase
+	}
+	if len(timeline) > 0 {
+		return timeline[len(timeline)-1].Phase
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+/*
+ * telemetry: rtt and throughput samples as timeseries points
+ *
+ * samples are converted to influxdb line protocol points and to otlp gauge data points,
+ * tagged (or attributed) by interface, tool and server, such that per-hop latency under
+ * load may be graphed alongside throughput
+ *
+ */
+package meta
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/internet-equity/traceneck/internal/influx"
+	"github.com/internet-equity/traceneck/internal/otlp"
+)
+
+const (
+	influxRtt        = "traceneck_rtt"
+	influxThroughput = "traceneck_throughput"
+
+	otlpScope = "traceneck"
+)
+
+// InfluxPoints: line protocol points of rtt samples (at send time) and throughput
+// samples (at interval end)
+func InfluxPoints(metadata Metadata, samples []RttSample) []influx.Point {
+	runID := RunID(metadata)
+
+	// user tags, not overriding those of traceneck
+	tags := func(extra map[string]string) map[string]string {
+		t := maps.Clone(metadata.Meta.Tags)
+		if t == nil {
+			t = make(map[string]string)
+		}
+		t["interface"] = metadata.Meta.Interface
+		t["tool"] = ToolOf(metadata)
+		t["server"] = ServerOf(metadata)
+		maps.Copy(t, extra)
+		return t
+	}
+
+	var points []influx.Point
+
+	for _, sample := range samples {
+		if sample.SendTime == 0 {
+			// placeholder of unresolved direct hop
+			continue
+		}
+
+		fields := map[string]any{
+			"run_id": runID,
+			"round":  sample.Round,
+			"lost":   sample.RecvTime == 0,
+		}
+		if sample.RecvTime != 0 {
+			fields["rtt"] = sample.RTT
+		}
+		if sample.IcmpSeqNo != nil {
+			fields["icmp_seq_no"] = *sample.IcmpSeqNo
+		}
+		if sample.UdpDestPort != nil {
+			fields["udp_dest_port"] = *sample.UdpDestPort
+		}
+
+		points = append(points, influx.Point{
+			Measurement: influxRtt,
+			Tags: tags(map[string]string{
+				"hop":      strconv.Itoa(sample.TTL),
+				"phase":    sample.Phase,
+				"reply_ip": ipString(sample.ReplyIP),
+			}),
+			Fields: fields,
+			Time:   sample.SendTime,
+		})
+	}
+
+	for _, sample := range metadata.Measurements.Throughput {
+		points = append(points, influx.Point{
+			Measurement: influxThroughput,
+			Tags:        tags(map[string]string{"direction": sample.Direction}),
+			Fields: map[string]any{
+				"run_id":          runID,
+				"bits_per_second": sample.BitsPerSecond,
+				"bytes":           int64(sample.Bytes),
+			},
+			Time: sample.EndTime,
+		})
+	}
+
+	return points
+}
+
+// OtlpRequest: otlp export of rtt samples with replies (at receive time) and throughput
+// samples (at interval end), under a resource of the run
+func OtlpRequest(metadata Metadata, samples []RttSample, version string) otlp.ExportRequest {
+	attributes := []otlp.KeyValue{
+		otlp.String("service.name", "traceneck"),
+		otlp.String("service.version", version),
+		otlp.String("network.interface.name", metadata.Meta.Interface),
+		otlp.String("traceneck.tool", ToolOf(metadata)),
+		otlp.String("traceneck.run_id", RunID(metadata)),
+	}
+	if server := ServerOf(metadata); server != "" {
+		attributes = append(attributes, otlp.String("server.address", server))
+	}
+	if host, err := os.Hostname(); err == nil {
+		attributes = append(attributes, otlp.String("host.name", host))
+	}
+	for _, key := range sortedKeys(metadata.Meta.Tags) {
+		attributes = append(attributes, otlp.String("traceneck.tag."+key, metadata.Meta.Tags[key]))
+	}
+
+	rtt := otlp.Metric{
+		Name:        "traceneck.hop.rtt",
+		Description: "Round-trip time of probes to each hop",
+		Unit:        "ms",
+	}
+	for _, sample := range samples {
+		if sample.RecvTime == 0 {
+			continue
+		}
+
+		rtt.Gauge.DataPoints = append(rtt.Gauge.DataPoints, otlp.Point(sample.RecvTime, sample.RTT,
+			otlp.Int("hop", sample.TTL),
+			otlp.Int("round", sample.Round),
+			otlp.String("phase", sample.Phase),
+			otlp.String("reply_ip", ipString(sample.ReplyIP)),
+		))
+	}
+
+	throughput := otlp.Metric{
+		Name:        "traceneck.throughput",
+		Description: "Speedtest throughput per reporting interval",
+		Unit:        "bit/s",
+	}
+	for _, sample := range metadata.Measurements.Throughput {
+		throughput.Gauge.DataPoints = append(throughput.Gauge.DataPoints, otlp.Point(sample.EndTime, sample.BitsPerSecond,
+			otlp.String("direction", sample.Direction),
+		))
+	}
+
+	return otlp.ExportRequest{
+		ResourceMetrics: []otlp.ResourceMetrics{{
+			Resource: otlp.Resource{Attributes: attributes},
+			ScopeMetrics: []otlp.ScopeMetrics{{
+				Scope:   otlp.Scope{Name: otlpScope, Version: version},
+				Metrics: []otlp.Metric{rtt, throughput},
+			}},
+		}},
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	return slices.Sorted(maps.Keys(m))
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/internet-equity/traceneck/internal/daemon"
+	"github.com/internet-equity/traceneck/internal/meta"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Exporter: metrics of the daemon and its last successful run, served over http
+//
+// run metrics are retained across failed runs; traceneck_last_run_success reports failure.
+type Exporter struct {
+	version string
+
+	mu     sync.Mutex
+	status daemon.Status
+	run    []*Family
+	lastID string
+}
+
+func NewExporter(version string) *Exporter {
+	return &Exporter{version: version}
+}
+
+// Listen: serve /metrics at addr in the background
+func (e *Exporter) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			log.Println("[metrics] server stopped:", err)
+		}
+	}()
+
+	log.Println("[metrics] serving at:", "http://"+listener.Addr().String()+"/metrics")
+	return nil
+}
+
+// Observe: update daemon metrics, and run metrics on completion of a successful run
+func (e *Exporter) Observe(status *daemon.Status) {
+	var run []*Family
+	if last := status.LastRun; last != nil && last.Error == "" && last.ID != e.lastRunID() {
+		if metadata, err := readMetadata(last.Output); err == nil {
+			run = Run(metadata)
+		} else {
+			log.Println("[metrics] error reading run metadata:", err)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.status = *status
+	if last := status.LastRun; last != nil {
+		e.lastID = last.ID
+	}
+	if run != nil {
+		e.run = run
+	}
+}
+
+func (e *Exporter) lastRunID() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lastID
+}
+
+// ServeHTTP: write metrics in the text exposition format
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	families := append(e.daemonFamilies(), e.run...)
+	e.mu.Unlock()
+
+	w.Header().Set("Content-Type", contentType)
+	Write(w, families)
+}
+
+func (e *Exporter) daemonFamilies() []*Family {
+	s := e.status
+
+	info := &Family{Name: "traceneck_build_info", Help: "Version of the traceneck daemon.", Type: typeGauge}
+	info.add(1, Label{"version", e.version})
+
+	runs := &Family{Name: "traceneck_runs_total", Help: "Runs executed by the daemon.", Type: typeCounter}
+	runs.add(float64(s.RunsTotal))
+
+	failed := &Family{Name: "traceneck_runs_failed_total", Help: "Runs failed.", Type: typeCounter}
+	failed.add(float64(s.RunsFailed))
+
+	skipped := &Family{Name: "traceneck_runs_skipped_total", Help: "Runs skipped, being locked or overrun.", Type: typeCounter}
+	skipped.add(float64(s.RunsSkipped))
+
+	families := []*Family{info, runs, failed, skipped}
+
+	if last := s.LastRun; last != nil {
+		success := &Family{Name: "traceneck_last_run_success", Help: "Whether the last run succeeded.", Type: typeGauge}
+		success.add(boolValue(last.Error == ""))
+
+		end := &Family{Name: "traceneck_last_run_end_timestamp_seconds", Help: "End time of the last run.", Type: typeGauge}
+		end.add(unixSeconds(last.End))
+
+		families = append(families, success, end)
+	}
+
+	if s.NextRun != nil {
+		next := &Family{Name: "traceneck_next_run_timestamp_seconds", Help: "Scheduled time of the next run.", Type: typeGauge}
+		next.add(unixSeconds(*s.NextRun))
+		families = append(families, next)
+	}
+
+	return families
+}
+
+// readMetadata: metadata of a run's output directory
+func readMetadata(dir string) (meta.Metadata, error) {
+	var metadata meta.Metadata
+
+	// metadata-<time>.json in output directories
+	paths, _ := filepath.Glob(filepath.Join(dir, "metadata*.json"))
+	if len(paths) == 0 {
+		return metadata, os.ErrNotExist
+	}
+
+	data, err := os.ReadFile(paths[len(paths)-1])
+	if err != nil {
+		return metadata, err
+	}
+
+	err = json.Unmarshal(data, &metadata)
+	return metadata, err
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
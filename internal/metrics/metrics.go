@@ -0,0 +1,100 @@
+/*
+ * metrics: prometheus metrics of measurement runs
+ *
+ * metrics of the last run (throughput per tool, per-hop rtt quantiles and loss, idle vs
+ * loaded) are derived from its metadata; the daemon serves them at /metrics alongside run
+ * counters, and one-shot runs may write them for the node exporter's textfile collector
+ *
+ */
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	typeGauge   = "gauge"
+	typeCounter = "counter"
+	typeSummary = "summary"
+)
+
+// Family: metric family of the text exposition format
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// Sample: single sample of a family, suffixed (e.g. _sum, _count) where of a summary
+type Sample struct {
+	Suffix string
+	Labels []Label
+	Value  float64
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+func (f *Family) add(value float64, labels ...Label) {
+	f.Samples = append(f.Samples, Sample{Labels: labels, Value: value})
+}
+
+// Write: write families in the prometheus text exposition format
+func Write(w io.Writer, families []*Family) error {
+	bw := bufio.NewWriter(w)
+
+	for _, f := range families {
+		if len(f.Samples) == 0 {
+			continue
+		}
+
+		bw.WriteString("# HELP " + f.Name + " " + escapeHelp(f.Help) + "\n")
+		bw.WriteString("# TYPE " + f.Name + " " + f.Type + "\n")
+
+		for _, s := range f.Samples {
+			bw.WriteString(f.Name + s.Suffix)
+
+			if len(s.Labels) > 0 {
+				bw.WriteByte('{')
+				for i, label := range s.Labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(label.Name + `="` + escapeLabel(label.Value) + `"`)
+				}
+				bw.WriteByte('}')
+			}
+
+			bw.WriteString(" " + formatValue(s.Value) + "\n")
+		}
+	}
+
+	return bw.Flush()
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
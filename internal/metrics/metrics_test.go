@@ -0,0 +1,275 @@
+package metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/internet-equity/traceneck/internal/daemon"
+	"github.com/internet-equity/traceneck/internal/meta"
+)
+
+func TestWrite(t *testing.T) {
+	families := []*Family{
+		{
+			Name: "traceneck_test",
+			Help: "Help with \\ and\nnewline.",
+			Type: typeGauge,
+			Samples: []Sample{
+				{Value: 1.5},
+				{Labels: []Label{{"hop", "1"}, {"name", "a \"quoted\"\\path\n"}}, Value: 1e-7},
+				{Labels: []Label{{"hop", "2"}}, Value: math.NaN()},
+				{Labels: []Label{{"hop", "3"}}, Value: math.Inf(1)},
+				{Labels: []Label{{"hop", "4"}}, Value: math.Inf(-1)},
+			},
+		},
+		{Name: "traceneck_empty", Help: "Skipped.", Type: typeGauge},
+		{
+			Name: "traceneck_rtt_seconds",
+			Help: "Summary.",
+			Type: typeSummary,
+			Samples: []Sample{
+				{Labels: []Label{{"quantile", "0.5"}}, Value: 0.012},
+				{Suffix: "_sum", Value: 0.036},
+				{Suffix: "_count", Value: 3},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, families); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP traceneck_test Help with \\ and\nnewline.
+# TYPE traceneck_test gauge
+traceneck_test 1.5
+traceneck_test{hop="1",name="a \"quoted\"\\path\n"} 1e-07
+traceneck_test{hop="2"} NaN
+traceneck_test{hop="3"} +Inf
+traceneck_test{hop="4"} -Inf
+# HELP traceneck_rtt_seconds Summary.
+# TYPE traceneck_rtt_seconds summary
+traceneck_rtt_seconds{quantile="0.5"} 0.012
+traceneck_rtt_seconds_sum 0.036
+traceneck_rtt_seconds_count 3
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func sampleMetadata() meta.Metadata {
+	sample := func(ttl, round int, phase string, rtt float64) meta.RttSample {
+		s := meta.RttSample{TTL: ttl, Round: round, Phase: phase, SendTime: 1700000000}
+		if rtt != 0 {
+			s.RecvTime = s.SendTime + rtt/1e3
+			s.RTT = rtt
+		}
+		return s
+	}
+
+	return meta.Metadata{
+		SchemaVersion: meta.SchemaVersion,
+		Meta: meta.Meta{
+			ID:            "20240101T000000Z-eth0",
+			Time:          1700000000,
+			ToolStartTime: 1700000000,
+			ToolEndTime:   1700000030.5,
+			Interface:     "eth0",
+			Direction:     "download",
+		},
+		Measurements: meta.Measurements{
+			Ookla: &meta.MeasureOokla{Download: 100, Upload: 10, Latency: 12},
+			RttSamples: []meta.RttSample{
+				sample(1, 0, "", 0), // direct hop placeholder
+				sample(1, 1, meta.PhaseBaseline, 10),
+				sample(1, 2, meta.PhaseBaseline, 20),
+				sample(1, 3, meta.PhaseDownload, 40),
+				sample(1, 4, meta.PhaseDownload, 0),
+				sample(2, 1, meta.PhaseDownload, 0),
+			},
+			BytesConsumed: 1000,
+		},
+	}
+}
+
+func TestRun(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, Run(sampleMetadata())); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+
+	base := `tool="ookla",interface="eth0"`
+	for _, line := range []string{
+		`traceneck_run_timestamp_seconds{` + base + `} 1.7e+09`,
+		`traceneck_run_duration_seconds{` + base + `} 30.5`,
+		`traceneck_throughput_bits_per_second{` + base + `,direction="download"} 1e+08`,
+		`traceneck_tool_latency_seconds{` + base + `} 0.012`,
+		`traceneck_test_bytes{` + base + `} 1000`,
+		`traceneck_hop_rtt_seconds{` + base + `,hop="1",state="idle",quantile="0.5"} 0.01`,
+		`traceneck_hop_rtt_seconds{` + base + `,hop="1",state="idle",quantile="0.99"} 0.02`,
+		`traceneck_hop_rtt_seconds_sum{` + base + `,hop="1",state="idle"} 0.03`,
+		`traceneck_hop_rtt_seconds_count{` + base + `,hop="1",state="idle"} 2`,
+		`traceneck_hop_rtt_seconds{` + base + `,hop="1",state="loaded",quantile="0.5"} 0.04`,
+		`traceneck_hop_rtt_seconds_count{` + base + `,hop="2",state="loaded"} 0`,
+		`traceneck_hop_probes{` + base + `,hop="1",state="loaded"} 2`,
+		`traceneck_hop_loss_ratio{` + base + `,hop="1",state="idle"} 0`,
+		`traceneck_hop_loss_ratio{` + base + `,hop="1",state="loaded"} 0.5`,
+		`traceneck_hop_loss_ratio{` + base + `,hop="2",state="loaded"} 1`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+
+	for _, absent := range []string{
+		`direction="upload"`,              // download-only run
+		`hop="2",state="loaded",quantile`, // no replies
+		`hop="1",state="idle",quantile="0.5"} 0` + "\n",
+	} {
+		if strings.Contains(got, absent) {
+			t.Errorf("unexpected %s", absent)
+		}
+	}
+}
+
+func TestExporter(t *testing.T) {
+	output := t.TempDir()
+	data, err := json.Marshal(sampleMetadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(output, "metadata-1700000000.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	exporter := NewExporter("v1.2.3")
+	server := httptest.NewServer(exporter)
+	t.Cleanup(server.Close)
+
+	scrape := func() string {
+		t.Helper()
+
+		resp, err := http.Get(server.URL + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if got := resp.Header.Get("Content-Type"); got != contentType {
+			t.Errorf("content type: got %q", got)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	got := scrape()
+	for _, line := range []string{
+		`traceneck_build_info{version="v1.2.3"} 1`,
+		`traceneck_runs_total 0`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("before runs: missing %s", line)
+		}
+	}
+	if strings.Contains(got, "traceneck_last_run_success") || strings.Contains(got, "traceneck_hop_") {
+		t.Error("before runs: unexpected run metrics")
+	}
+
+	end := time.Unix(1700000031, 0)
+	next := end.Add(30 * time.Minute)
+	exporter.Observe(&daemon.Status{
+		RunsTotal: 1,
+		NextRun:   &next,
+		LastRun:   &daemon.RunStatus{ID: "20240101T000000Z-eth0", Output: output, End: end},
+	})
+
+	got = scrape()
+	for _, line := range []string{
+		`traceneck_runs_total 1`,
+		`traceneck_last_run_success 1`,
+		`traceneck_last_run_end_timestamp_seconds 1.700000031e+09`,
+		`traceneck_next_run_timestamp_seconds 1.700001831e+09`,
+		`traceneck_hop_loss_ratio{tool="ookla",interface="eth0",hop="1",state="loaded"} 0.5`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("after run: missing %s", line)
+		}
+	}
+
+	// run metrics of the last successful run are retained across failures
+	exporter.Observe(&daemon.Status{
+		RunsTotal:  2,
+		RunsFailed: 1,
+		LastRun:    &daemon.RunStatus{ID: "20240101T003000Z-eth0", Output: t.TempDir(), End: end, Error: "exit status 1"},
+	})
+
+	got = scrape()
+	for _, line := range []string{
+		`traceneck_runs_failed_total 1`,
+		`traceneck_last_run_success 0`,
+		`traceneck_hop_loss_ratio{tool="ookla",interface="eth0",hop="1",state="loaded"} 0.5`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("after failure: missing %s", line)
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traceneck.prom")
+	if err := WriteFile(path, sampleMetadata()); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode: got %v", info.Mode().Perm())
+	}
+
+	data, _ := os.ReadFile(path)
+	if !bytes.HasPrefix(data, []byte("# HELP traceneck_last_run_success")) || !bytes.Contains(data, []byte("traceneck_hop_probes{")) {
+		t.Errorf("unexpected contents:\n%s", data)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+}
+
+func TestWriteFileByVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traceneck.prom")
+	if err := WriteFileByVersion(path, map[int]meta.Metadata{4: sampleMetadata(), 6: sampleMetadata()}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	got := string(data)
+	for _, line := range []string{
+		`traceneck_test_bytes{tool="ookla",interface="eth0",ip_version="4"} 1000`,
+		`traceneck_test_bytes{tool="ookla",interface="eth0",ip_version="6"} 1000`,
+	} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("missing %s", line)
+		}
+	}
+
+	// families written once, of either version's samples
+	if n := strings.Count(got, "# TYPE traceneck_hop_rtt_seconds summary\n"); n != 1 {
+		t.Errorf("hop rtt family written %d times", n)
+	}
+}
@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"cmp"
+	"slices"
+	"strconv"
+
+	"github.com/internet-equity/traceneck/internal/analysis"
+	"github.com/internet-equity/traceneck/internal/meta"
+)
+
+const (
+	stateIdle   = "idle"
+	stateLoaded = "loaded"
+)
+
+// quantiles: reported rtt quantiles per hop and state
+var quantiles = []float64{0.5, 0.9, 0.99}
+
+type hopKey struct {
+	hop   int
+	state string
+}
+
+// Run: metric families of a run's metadata, of extra labels besides tool and interface
+func Run(metadata meta.Metadata, extra ...Label) []*Family {
+	base := append([]Label{
+		{"tool", meta.ToolOf(metadata)},
+		{"interface", metadata.Meta.Interface},
+	}, extra...)
+	labels := func(extra ...Label) []Label {
+		return append(slices.Clone(base), extra...)
+	}
+
+	timestamp := &Family{
+		Name: "traceneck_run_timestamp_seconds",
+		Help: "Start time of the last run.",
+		Type: typeGauge,
+	}
+	timestamp.add(metadata.Meta.Time, labels()...)
+
+	duration := &Family{
+		Name: "traceneck_run_duration_seconds",
+		Help: "Duration of the last run.",
+		Type: typeGauge,
+	}
+	duration.add(metadata.Meta.ToolEndTime-metadata.Meta.ToolStartTime, labels()...)
+
+	throughput := &Family{
+		Name: "traceneck_throughput_bits_per_second",
+		Help: "Throughput measured by the speedtest tool in the last run.",
+		Type: typeGauge,
+	}
+	latency := &Family{
+		Name: "traceneck_tool_latency_seconds",
+		Help: "Latency measured by the speedtest tool in the last run.",
+		Type: typeGauge,
+	}
+
+	if download, upload, ok := meta.BitsPerSecond(metadata); ok {
+		if metadata.Meta.Direction != "upload" {
+			throughput.add(download, labels(Label{"direction", "download"})...)
+		}
+		if metadata.Meta.Direction != "download" {
+			throughput.add(upload, labels(Label{"direction", "upload"})...)
+		}
+	}
+	if ms, ok := toolLatency(metadata); ok {
+		latency.add(ms/1e3, labels()...)
+	}
+
+	bytes := &Family{
+		Name: "traceneck_test_bytes",
+		Help: "Bytes transferred by the speedtest in the last run.",
+		Type: typeGauge,
+	}
+	bytes.add(float64(metadata.Measurements.BytesConsumed), labels()...)
+
+	rtt := &Family{
+		Name: "traceneck_hop_rtt_seconds",
+		Help: "Round-trip time to each hop in the last run, idle or under load.",
+		Type: typeSummary,
+	}
+	probes := &Family{
+		Name: "traceneck_hop_probes",
+		Help: "Probes sent to each hop in the last run, idle or under load.",
+		Type: typeGauge,
+	}
+	loss := &Family{
+		Name: "traceneck_hop_loss_ratio",
+		Help: "Fraction of probes to each hop without reply in the last run, idle or under load.",
+		Type: typeGauge,
+	}
+
+	rtts, sent := hopSamples(metadata.Measurements.RttSamples)
+
+	keys := make([]hopKey, 0, len(sent))
+	for key := range sent {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b hopKey) int {
+		return cmp.Or(cmp.Compare(a.hop, b.hop), cmp.Compare(a.state, b.state))
+	})
+
+	for _, key := range keys {
+		hopLabels := []Label{{"hop", strconv.Itoa(key.hop)}, {"state", key.state}}
+
+		values := rtts[key]
+		slices.Sort(values)
+
+		var sum float64
+		for _, value := range values {
+			sum += value
+		}
+
+		if len(values) > 0 {
+			for _, q := range quantiles {
+				rtt.add(analysis.Quantile(values, q), labels(append(hopLabels, Label{"quantile", formatValue(q)})...)...)
+			}
+		}
+		rtt.Samples = append(rtt.Samples,
+			Sample{Suffix: "_sum", Labels: labels(hopLabels...), Value: sum},
+			Sample{Suffix: "_count", Labels: labels(hopLabels...), Value: float64(len(values))},
+		)
+
+		probes.add(float64(sent[key]), labels(hopLabels...)...)
+		loss.add(1-float64(len(values))/float64(sent[key]), labels(hopLabels...)...)
+	}
+
+	return []*Family{timestamp, duration, throughput, latency, bytes, rtt, probes, loss}
+}
+
+// hopSamples: rtts (s) of replies and count of probes sent, by hop and state
+func hopSamples(samples []meta.RttSample) (map[hopKey][]float64, map[hopKey]int) {
+	rtts := make(map[hopKey][]float64)
+	sent := make(map[hopKey]int)
+
+	for _, sample := range samples {
+		if sample.Round == 0 {
+			// placeholder of unresolved direct hop
+			continue
+		}
+
+		key := hopKey{sample.TTL, stateOf(sample.Phase)}
+		sent[key]++
+		if sample.RecvTime != 0 {
+			rtts[key] = append(rtts[key], sample.RTT/1e3)
+		}
+	}
+
+	return rtts, sent
+}
+
+// stateOf: loaded in load phases, otherwise idle
+func stateOf(phase string) string {
+	switch phase {
+	case meta.PhaseDownload, meta.PhaseUpload, meta.PhaseBidir:
+		return stateLoaded
+	default:
+		return stateIdle
+	}
+}
+
+// toolLatency: latency (ms) reported by the tool
+func toolLatency(metadata meta.Metadata) (float64, bool) {
+	switch m := metadata.Measurements; {
+	case m.Ndt7 != nil:
+		return m.Ndt7.DownloadLatency, true
+	case m.Ookla != nil:
+		return m.Ookla.Latency, true
+	case m.OoklaHttp != nil:
+		return m.OoklaHttp.Latency, true
+	}
+	return 0, false
+}
@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/internet-equity/traceneck/internal/meta"
+)
+
+// WriteFile: atomically replace the textfile collector file at path with metrics of a
+// completed run
+//
+// failed runs leave the file as is: alert on staleness of the end timestamp.
+func WriteFile(path string, metadata meta.Metadata) error {
+	return writeFile(path, Run(metadata))
+}
+
+// WriteFileByVersion: atomically replace the textfile collector file at path with metrics
+// of the completed runs of a dual-stack comparison, labelled by ip version
+func WriteFileByVersion(path string, runs map[int]meta.Metadata) error {
+	var families []*Family
+	for _, version := range slices.Sorted(maps.Keys(runs)) {
+		families = merge(families, Run(runs[version], Label{"ip_version", strconv.Itoa(version)}))
+	}
+
+	return writeFile(path, families)
+}
+
+// writeFile: atomically replace the file at path with run families, after the last run's
+// success and end time
+func writeFile(path string, run []*Family) error {
+	success := &Family{Name: "traceneck_last_run_success", Help: "Whether the last run succeeded.", Type: typeGauge}
+	success.add(1)
+
+	end := &Family{Name: "traceneck_last_run_end_timestamp_seconds", Help: "End time of the last run.", Type: typeGauge}
+	end.add(unixSeconds(time.Now()))
+
+	// the collector ignores files other than *.prom, such as the temporary file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".traceneck-*.prom.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := Write(tmp, append([]*Family{success, end}, run...)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// merge: samples of families of the same name as one family, families being written once
+func merge(families, more []*Family) []*Family {
+	for _, f := range more {
+		i := slices.IndexFunc(families, func(g *Family) bool { return g.Name == f.Name })
+		if i < 0 {
+			families = append(families, f)
+			continue
+		}
+		families[i].Samples = append(families[i].Samples, f.Samples...)
+	}
+
+	return families
+}
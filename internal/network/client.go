@@ -4,6 +4,7 @@ import (
 	"io"
 	"log"
 	"os/exec"
+	"strconv"
 
 	"github.com/internet-equity/traceneck/internal/channel"
 	"github.com/internet-equity/traceneck/internal/config"
@@ -17,6 +18,12 @@ var logParserDone = make(channel.Type)
 func SpeedtestProcess() {
 	defer close(channel.SpeedtestDone)
 
+	if config.Tool == "iperf" {
+		// native client: no subprocess or log parser
+		iperfProcess()
+		return
+	}
+
 	// Create a pipe to capture stdout
 	logIn, logOut := io.Pipe()
 	defer logOut.Close()
@@ -29,7 +36,10 @@ func SpeedtestProcess() {
 		cmdArgs := []string{"--accept-license", "-f", "json", "-p", "yes"}
 		if config.Server != "" {
 			cmdArgs = append(cmdArgs, "--host", config.Server)
+		} else if config.ServerID != "" {
+			cmdArgs = append(cmdArgs, "--server-id", config.ServerID)
 		}
+		cmdArgs = append(cmdArgs, ooklaBindArgs()...)
 		cmd = exec.Command("speedtest", cmdArgs...)
 		logParser = logParserOokla
 	case "ookla-http":
@@ -37,25 +47,36 @@ func SpeedtestProcess() {
 		if config.Server == "" {
 			log.Println("[ookla-http] No server specified, falling back to regular ookla.")
 			cmdArgs = []string{"--accept-license", "-f", "json", "-p", "yes"}
+			cmdArgs = append(cmdArgs, ooklaBindArgs()...)
 			cmd = exec.Command("speedtest", cmdArgs...)
 			logParser = logParserOokla
 		} else {
-			cmdArgs = []string{"--json", "--server-ip", config.Server}
+			cmdArgs = []string{"--json", "--phases", "--server-ip", config.Server}
+			if config.Direction == "download" {
+				cmdArgs = append(cmdArgs, "--no-upload")
+			} else if config.Direction == "upload" {
+				cmdArgs = append(cmdArgs, "--no-download")
+			}
 			cmd = exec.Command("tools/ookla-http/speedtest.py", cmdArgs...)
 			logParser = logParserOoklaHttp
+
+			// phase events are written to stderr
+			phaseIn, phaseOut := io.Pipe()
+			defer phaseOut.Close()
+			cmd.Stderr = phaseOut
+			go phaseParserOoklaHttp(phaseIn)
 		}
-	case "iperf":
-		cmdArgs := []string{"-c", config.Server, "-J"}
-		if config.Server == "" {
-			log.Println("[iperf] No server specified, using default iperf3 public servers may be required.")
-			cmdArgs = []string{"-J"}
-		}
-		cmd = exec.Command("iperf3", cmdArgs...)
-		logParser = logParserIperf
 	default:
 		cmdArgs := []string{"-format", "json"}
 		if config.Server != "" {
 			cmdArgs = append(cmdArgs, "-no-verify", "-server", config.Server)
+		} else if config.ServiceURL != "" {
+			cmdArgs = append(cmdArgs, "-service-url", config.ServiceURL)
+		}
+		if config.Direction == "download" {
+			cmdArgs = append(cmdArgs, "-upload=false")
+		} else if config.Direction == "upload" {
+			cmdArgs = append(cmdArgs, "-download=false")
 		}
 		cmd = exec.Command("ndt7-client", cmdArgs...)
 		logParser = logParserNdt7
@@ -84,3 +105,18 @@ func SpeedtestProcess() {
 	<-logParserDone
 	log.Println("[speedtest] [log parser] complete")
 }
+
+// ooklaBindArgs: bind the ookla client to the interface address of the configured ip
+// version, such that it selects and tests a server of that version
+func ooklaBindArgs() []string {
+	if config.IPVersion == 0 {
+		return nil
+	}
+
+	ip := config.InterfaceIPOf(config.IPVersion)
+	if ip == nil {
+		log.Println("[ookla] no ipv"+strconv.Itoa(config.IPVersion), "interface address to bind")
+		return nil
+	}
+	return []string{"--ip", ip.String()}
+}
@@ -1,64 +1,114 @@
 package network
 
 import (
-	"bufio"
-	"encoding/json"
-	"io"
 	"log"
 	"net"
-	"strings"
+	"time"
 
-	"github.com/internet-equity/traceneck/internal/channel"
 	"github.com/internet-equity/traceneck/internal/config"
+	"github.com/internet-equity/traceneck/internal/iperf"
 	"github.com/internet-equity/traceneck/internal/meta"
+	timeUtil "github.com/internet-equity/traceneck/internal/util/time"
 )
 
-type IperfResult struct {
-	Start struct {
-		Connected []struct {
-			RemoteHost string `json:"remote_host"`
-		} `json:"connected"`
-	} `json:"start"`
-	End struct {
-		SumSent struct {
-			BitsPerSecond float64 `json:"bits_per_second"`
-		} `json:"sum_sent"`
-		SumReceived struct {
-			BitsPerSecond float64 `json:"bits_per_second"`
-		} `json:"sum_received"`
-	} `json:"end"`
+// iperfPhases: load phases to run, in order
+func iperfPhases() []string {
+	switch {
+	case config.IperfBidir:
+		return []string{meta.PhaseBidir}
+	case config.Direction == "download":
+		return []string{meta.PhaseDownload}
+	case config.Direction == "upload":
+		return []string{meta.PhaseUpload}
+	default:
+		return []string{meta.PhaseDownload, meta.PhaseUpload}
+	}
 }
 
-func logParserIperf(logPipe *io.PipeReader) {
-	defer close(logParserDone)
+// iperfProcess: run the native iperf3 client in place of an external tool
+//
+// a test in both directions runs a download (reverse) test followed by an upload test,
+// unless bidir is requested, which loads both directions simultaneously.
+func iperfProcess() {
+	client := iperf.Client{
+		Address:    config.IperfAddr,
+		Parallel:   config.IperfParallel,
+		Duration:   time.Duration(config.IperfDuration) * time.Second,
+		UDP:        config.IperfUDP,
+		Bitrate:    config.IperfRate,
+		Congestion: config.IperfCongestion,
 
-	var (
-		iperfResult IperfResult
-		line        string
-	)
+		OnConnect: func(serverIP net.IP) {
+			grabServerIP(serverIP, "[iperf]")
+		},
 
-	scanner := bufio.NewScanner(logPipe)
-	var jsonOutput strings.Builder
+		OnInterval: func(interval iperf.Interval) {
+			meta.MThroughput = append(meta.MThroughput, meta.ThroughputSample{
+				Direction:     string(interval.Direction),
+				StartTime:     timeUtil.UnixPrecise(interval.Start),
+				EndTime:       timeUtil.UnixPrecise(interval.End),
+				Bytes:         interval.Bytes,
+				BitsPerSecond: interval.BitsPerSecond,
+			})
+		},
+	}
 
-	for scanner.Scan() {
-		line = scanner.Text()
-		jsonOutput.WriteString(line)
+	meta.MIperf = meta.MeasureIperf{Protocol: "tcp", DownloadRetrans: -1, UploadRetrans: -1}
+	if config.IperfUDP {
+		meta.MIperf.Protocol = "udp"
 	}
 
-	if err := json.Unmarshal([]byte(jsonOutput.String()), &iperfResult); err == nil {
-		if len(iperfResult.Start.Connected) > 0 {
-			config.ServerIP = net.ParseIP(iperfResult.Start.Connected[0].RemoteHost)
-			close(channel.IPGrabbed)
-			log.Println("[iperf] [log parser] grabbed server ip:", config.ServerIP)
-		} else {
-			log.Println("[iperf] [log parser] failed to grab server ip")
+	meta.MMeta.SpeedtestStartTime = timeUtil.UnixNow()
+	log.Println("[speedtest] started")
+
+	for _, phase := range iperfPhases() {
+		client.Reverse = phase == meta.PhaseDownload
+		client.Bidir = phase == meta.PhaseBidir
+		client.OnStart = func(time.Time) {
+			meta.PhaseStart(phase)
 		}
 
-		meta.MIperf = meta.MeasureIperf{
-			Download: iperfResult.End.SumReceived.BitsPerSecond,
-			Upload:   iperfResult.End.SumSent.BitsPerSecond,
+		result, err := client.Run()
+		meta.PhaseEnd(phase)
+		if err != nil {
+			log.Println("[speedtest] client error:", err)
+			return
 		}
-	} else {
-		log.Println("[iperf] [log parser] failed to parse iperf result")
+		log.Println("[speedtest]", phase, "complete")
+
+		iperfRecord(result)
 	}
-}
\ No newline at end of file
+
+	meta.MMeta.SpeedtestEndTime = timeUtil.UnixNow()
+	log.Println("[speedtest] complete")
+}
+
+// iperfRecord: record results of one iperf test
+func iperfRecord(result *iperf.Result) {
+	meta.MIperf.Streams = result.Streams
+	meta.MIperf.Congestion = result.CongestionUsed
+	meta.MIperf.ServerIP = result.ServerIP
+
+	if sum := result.Download; sum != nil {
+		meta.MIperf.Download = sum.BitsPerSecond
+		meta.MIperf.DownloadRetrans = sum.Retransmits
+		meta.MIperf.DownloadJitter = sum.Jitter
+		meta.MIperf.DownloadLoss = lossPercent(sum)
+		meta.MBytes += int64(sum.BytesReceived)
+	}
+
+	if sum := result.Upload; sum != nil {
+		meta.MIperf.Upload = sum.BitsPerSecond
+		meta.MIperf.UploadRetrans = sum.Retransmits
+		meta.MIperf.UploadJitter = sum.Jitter
+		meta.MIperf.UploadLoss = lossPercent(sum)
+		meta.MBytes += int64(sum.BytesSent)
+	}
+}
+
+func lossPercent(sum *iperf.Summary) float64 {
+	if sum.Packets == 0 {
+		return 0
+	}
+	return float64(sum.Lost) * 100 / float64(sum.Packets)
+}
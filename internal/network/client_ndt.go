@@ -8,7 +8,6 @@ import (
 	"net"
 	"strings"
 
-	"github.com/internet-equity/traceneck/internal/channel"
 	"github.com/internet-equity/traceneck/internal/config"
 	"github.com/internet-equity/traceneck/internal/meta"
 )
@@ -46,6 +45,32 @@ type Value struct {
 	Value float64 `json:"Value"`
 }
 
+type NdtEvent struct {
+	Key   string `json:"Key"`
+	Value struct {
+		Test string `json:"Test"`
+	} `json:"Value"`
+}
+
+// ndtPhase: mark test phases from starting and complete events
+func ndtPhase(line string) {
+	if !strings.Contains(line, `"starting"`) && !strings.Contains(line, `"complete"`) {
+		return
+	}
+
+	var event NdtEvent
+	if err := json.Unmarshal([]byte(line), &event); err != nil {
+		return
+	}
+
+	switch event.Key {
+	case "starting":
+		meta.PhaseStart(event.Value.Test)
+	case "complete":
+		meta.PhaseEnd(event.Value.Test)
+	}
+}
+
 func logParserNdt7(logPipe *io.PipeReader) {
 	defer close(logParserDone)
 
@@ -59,6 +84,7 @@ func logParserNdt7(logPipe *io.PipeReader) {
 	scanner := bufio.NewScanner(logPipe)
 	for scanner.Scan() {
 		line = scanner.Text()
+		ndtPhase(line)
 
 		if strings.Contains(line, "ConnectionInfo") {
 			break
@@ -67,16 +93,14 @@ func logParserNdt7(logPipe *io.PipeReader) {
 
 	if err := json.Unmarshal([]byte(line), &ndtConnInfo); err == nil {
 		ipStr := strings.Split(ndtConnInfo.Value.ConnectionInfo.Server, ":")[0]
-		config.ServerIP = net.ParseIP(ipStr)
-		close(channel.IPGrabbed)
-
-		log.Println("[speedtest] [log parser] grabbed server ip:", config.ServerIP)
+		grabServerIP(net.ParseIP(ipStr), "[speedtest] [log parser]")
 	} else {
 		log.Fatalf("[speedtest] [log parser] failed to grab server ip")
 	}
 
 	for scanner.Scan() {
 		line = scanner.Text()
+		ndtPhase(line)
 
 		if strings.Contains(line, "AppInfo") {
 			if strings.Contains(line, "download") {
@@ -104,15 +128,19 @@ func logParserNdt7(logPipe *io.PipeReader) {
 		log.Println("[speedtest] [log parser] failed to parse final info")
 	}
 
-	if err := json.Unmarshal([]byte(last_download), &ndtDownInfo); err == nil {
-		meta.MBytes += ndtDownInfo.Value.AppInfo.NumBytes
-	} else {
-		log.Println("[speedtest] [log parser] failed to parse download numBytes")
+	if config.Direction != "upload" {
+		if err := json.Unmarshal([]byte(last_download), &ndtDownInfo); err == nil {
+			meta.MBytes += ndtDownInfo.Value.AppInfo.NumBytes
+		} else {
+			log.Println("[speedtest] [log parser] failed to parse download numBytes")
+		}
 	}
 
-	if err := json.Unmarshal([]byte(last_upload), &ndtUpInfo); err == nil {
-		meta.MBytes += ndtUpInfo.Value.AppInfo.NumBytes
-	} else {
-		log.Println("[speedtest] [log parser] failed to parse upload numBytes")
+	if config.Direction != "download" {
+		if err := json.Unmarshal([]byte(last_upload), &ndtUpInfo); err == nil {
+			meta.MBytes += ndtUpInfo.Value.AppInfo.NumBytes
+		} else {
+			log.Println("[speedtest] [log parser] failed to parse upload numBytes")
+		}
 	}
 }
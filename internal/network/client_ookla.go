@@ -8,8 +8,6 @@ import (
 	"net"
 	"strings"
 
-	"github.com/internet-equity/traceneck/internal/channel"
-	"github.com/internet-equity/traceneck/internal/config"
 	"github.com/internet-equity/traceneck/internal/meta"
 )
 
@@ -40,13 +38,35 @@ type OoklaResultInfo struct {
 	PktLoss float64 `json:"packetLoss"`
 }
 
+type OoklaEvent struct {
+	Type string `json:"type"`
+}
+
+// ooklaPhase: mark test phases from progress event types
+func ooklaPhase(line string, current *string) {
+	var event OoklaEvent
+	if err := json.Unmarshal([]byte(line), &event); err != nil {
+		return
+	}
+
+	switch event.Type {
+	case meta.PhaseDownload, meta.PhaseUpload:
+		if event.Type != *current {
+			meta.PhaseStart(event.Type)
+			*current = event.Type
+		}
+	case "result":
+		meta.PhaseEnd(*current)
+	}
+}
+
 func logParserOokla(logPipe *io.PipeReader) {
 	defer close(logParserDone)
 
 	var (
 		ooklaStartInfo  OoklaStartInfo
 		ooklaResultInfo OoklaResultInfo
-		line            string
+		line, phase     string
 	)
 
 	scanner := bufio.NewScanner(logPipe)
@@ -59,16 +79,14 @@ func logParserOokla(logPipe *io.PipeReader) {
 	}
 
 	if err := json.Unmarshal([]byte(line), &ooklaStartInfo); err == nil {
-		config.ServerIP = ooklaStartInfo.Server.IP
-		close(channel.IPGrabbed)
-
-		log.Println("[speedtest] [log parser] grabbed server ip:", config.ServerIP)
+		grabServerIP(ooklaStartInfo.Server.IP, "[speedtest] [log parser]")
 	} else {
 		log.Fatalf("[speedtest] [log parser] failed to grab server ip")
 	}
 
 	for scanner.Scan() {
 		line = scanner.Text()
+		ooklaPhase(line, &phase)
 
 		if strings.Contains(line, "result") {
 			break
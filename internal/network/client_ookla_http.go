@@ -8,8 +8,6 @@ import (
 	"net"
 	"strings"
 
-	"github.com/internet-equity/traceneck/internal/channel"
-	"github.com/internet-equity/traceneck/internal/config"
 	"github.com/internet-equity/traceneck/internal/meta"
 )
 
@@ -54,9 +52,7 @@ func logParserOoklaHttp(logPipe *io.PipeReader) {
 	}
 	
 	if err := json.Unmarshal([]byte(line), &ooklaStartInfo); err == nil {
-		config.ServerIP = ooklaStartInfo.Server.IP
-		close(channel.IPGrabbed)
-		log.Println("[speedtest] [log parser] grabbed server ip:", config.ServerIP)
+		grabServerIP(ooklaStartInfo.Server.IP, "[speedtest] [log parser]")
 	} else {
 		log.Fatalf("[speedtest] [log parser] failed to grab server ip")
 	}
@@ -78,3 +74,28 @@ func logParserOoklaHttp(logPipe *io.PipeReader) {
 		log.Println("[speedtest] [log parser] failed to parse result info")
 	}
 }
+
+type OoklaHttpPhaseEvent struct {
+	Type      string  `json:"type"`
+	Phase     string  `json:"phase"`
+	Event     string  `json:"event"`
+	Timestamp float64 `json:"timestamp"`
+}
+
+// phaseParserOoklaHttp: mark test phases from events written to stderr
+func phaseParserOoklaHttp(phasePipe *io.PipeReader) {
+	scanner := bufio.NewScanner(phasePipe)
+	for scanner.Scan() {
+		var event OoklaHttpPhaseEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || event.Type != "phase" {
+			continue
+		}
+
+		switch event.Event {
+		case "start":
+			meta.PhaseStartAt(event.Phase, event.Timestamp)
+		case "end":
+			meta.PhaseEndAt(event.Phase, event.Timestamp)
+		}
+	}
+}
@@ -2,18 +2,19 @@ package network
 
 import (
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"strconv"
 	"time"
 
 	"github.com/google/gopacket"
-	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 	"github.com/google/gopacket/pcapgo"
 
 	"github.com/internet-equity/traceneck/internal/channel"
 	"github.com/internet-equity/traceneck/internal/config"
+	"github.com/internet-equity/traceneck/internal/meta"
 )
 
 // Ethernet Header: 14 bytes
@@ -31,8 +32,16 @@ var (
 func CaptureProcess() {
 	defer close(channel.CaptureDone)
 
+	if config.NoCapture {
+		log.Println("[pcap] no capture permission: capture skipped")
+		return
+	}
+
 	if config.Tool == "ndt" {
 		captureFilter = "port 80 or port 443"
+	} else if config.Tool == "iperf" {
+		_, port, _ := net.SplitHostPort(config.IperfAddr)
+		captureFilter = "port " + port
 	} else {
 		captureFilter = "port 8080 or port 5060"
 	}
@@ -43,7 +52,7 @@ func CaptureProcess() {
 		captureFilter += " or (udp and outbound) or ((icmp or icmp6) and inbound)"
 	}
 
-	CapFile = config.GetFilePath("capture.pcap")
+	CapFile = config.GetFilePath("capture.pcapng")
 	capWriter, err := os.Create(CapFile)
 	if err != nil {
 		log.Println("[pcap] error opening pcap file:", err)
@@ -72,21 +81,40 @@ func pcapProcess(capWriter *os.File) {
 	}
 
 	packets := gopacket.NewPacketSource(handle, handle.LinkType()).Packets()
-	pcapWriter := pcapgo.NewWriter(capWriter)
-	pcapWriter.WriteFileHeader(captureSnapLen, layers.LinkTypeEthernet)
+	ngWriter, err := newCaptureWriter(capWriter, handle.LinkType())
+	if err != nil {
+		log.Println("[pcap] error writing pcapng header:", err)
+		return
+	}
 
-	log.Println("[pcap] writing pcap to:", CapFile)
+	log.Println("[pcap] writing pcapng to:", CapFile)
 	defer log.Println("[pcap] stopped")
 
+	start := time.Now()
 	for {
 		select {
 		case <-channel.Stop:
+			stats := pcapgo.NgInterfaceStatistics{
+				LastUpdate: time.Now(),
+				StartTime:  start,
+				EndTime:    time.Now(),
+				Comment:    ngWriter.pendingComment(),
+			}
+			if handleStats, err := handle.Stats(); err == nil {
+				stats.PacketsReceived = uint64(handleStats.PacketsReceived)
+				stats.PacketsDropped = uint64(handleStats.PacketsDropped)
+			}
+			if err := ngWriter.close(stats); err != nil {
+				log.Println("[pcap] error writing to pcapng:", err)
+			}
 			return
+		case mark := <-meta.PhaseMarks:
+			ngWriter.mark(mark)
 		case packet := <-packets:
-			if err := pcapWriter.WritePacket(
+			if err := ngWriter.writePacket(
 				packet.Metadata().CaptureInfo, packet.Data(),
 			); err != nil {
-				log.Println("[pcap] error writing to pcap:", err)
+				log.Println("[pcap] error writing to pcapng:", err)
 			}
 		}
 	}
@@ -95,7 +123,7 @@ func pcapProcess(capWriter *os.File) {
 func tsharkProcess() {
 	Tshark := exec.Command(
 		"tshark",
-		"-F", "pcap",
+		"-F", "pcapng",
 		"-s", strconv.Itoa(captureSnapLen),
 		"-i", config.Interface,
 		"-f", captureFilter,
@@ -105,7 +133,7 @@ func tsharkProcess() {
 		log.Println("[tshark] error starting:", err)
 		return
 	}
-	log.Println("[tshark] writing pcap to:", CapFile)
+	log.Println("[tshark] writing pcapng to:", CapFile)
 
 	<-channel.Stop
 
@@ -0,0 +1,139 @@
+package network
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/internet-equity/traceneck/internal/config"
+	"github.com/internet-equity/traceneck/internal/meta"
+)
+
+// pcapng block and option codes of commented packets
+const (
+	ngEnhancedPacketBlock = 0x00000006
+	ngOptionEnd           = 0
+	ngOptionComment       = 1
+)
+
+// captureWriter: pcapng writer of the capture, describing the run in its section header
+// and the interface in its interface description, and commenting the first packet with
+// the run id and the first packet after each phase mark with the mark
+//
+// gopacket writes no packet options, such that commented packets are written as
+// enhanced packet blocks of our own, past the buffered blocks of the writer.
+type captureWriter struct {
+	w        io.Writer
+	ngWriter *pcapgo.NgWriter
+	pending  []string // comments of the next packet
+}
+
+func newCaptureWriter(w io.Writer, linkType layers.LinkType) (*captureWriter, error) {
+	ngWriter, err := pcapgo.NewNgWriterInterface(w,
+		pcapgo.NgInterface{
+			Name:                config.Interface,
+			Description:         "traceneck capture of " + config.Interface,
+			Filter:              captureFilter,
+			OS:                  runtime.GOOS,
+			LinkType:            linkType,
+			SnapLength:          captureSnapLen,
+			TimestampResolution: 9,
+		},
+		pcapgo.NgWriterOptions{
+			SectionInfo: pcapgo.NgSectionInfo{
+				Hardware:    runtime.GOARCH,
+				OS:          runtime.GOOS,
+				Application: config.NAME + " " + config.VERSION,
+				Comment:     fmt.Sprintf("run %s, args: %s", meta.MMeta.ID, strings.Join(config.RecordedArgs(), " ")),
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &captureWriter{
+		w:        w,
+		ngWriter: ngWriter,
+		pending:  []string{"traceneck run " + meta.MMeta.ID},
+	}, nil
+}
+
+// mark: comment the next packet with a phase mark
+func (c *captureWriter) mark(mark meta.PhaseMark) {
+	event := "end"
+	if mark.Start {
+		event = "start"
+	}
+	c.pending = append(c.pending, fmt.Sprintf("traceneck phase %s %s at %.6f", mark.Phase, event, mark.Time))
+}
+
+// pendingComment: comments not yet written with a packet
+func (c *captureWriter) pendingComment() string {
+	return strings.Join(c.pending, "; ")
+}
+
+func (c *captureWriter) writePacket(ci gopacket.CaptureInfo, data []byte) error {
+	if len(c.pending) == 0 {
+		return c.ngWriter.WritePacket(ci, data)
+	}
+
+	if err := c.ngWriter.Flush(); err != nil {
+		return err
+	}
+	if _, err := c.w.Write(commentedPacket(ci, data, c.pending)); err != nil {
+		return err
+	}
+	c.pending = nil
+	return nil
+}
+
+// close: write the interface statistics, and flush
+func (c *captureWriter) close(stats pcapgo.NgInterfaceStatistics) error {
+	if err := c.ngWriter.WriteInterfaceStats(0, stats); err != nil {
+		return err
+	}
+	return c.ngWriter.Flush()
+}
+
+// commentedPacket: enhanced packet block of a packet of interface 0 with comments, its
+// timestamp in nanoseconds as the interface resolution
+func commentedPacket(ci gopacket.CaptureInfo, data []byte, comments []string) []byte {
+	var options []byte
+	for _, comment := range comments {
+		options = binary.LittleEndian.AppendUint16(options, ngOptionComment)
+		options = binary.LittleEndian.AppendUint16(options, uint16(len(comment)))
+		options = append(options, comment...)
+		options = append(options, make([]byte, pad4(len(comment)))...)
+	}
+	options = binary.LittleEndian.AppendUint32(options, ngOptionEnd)
+
+	length := uint32(28 + len(data) + pad4(len(data)) + len(options) + 4)
+	ts := uint64(ci.Timestamp.UnixNano())
+
+	block := make([]byte, 0, length)
+	block = binary.LittleEndian.AppendUint32(block, ngEnhancedPacketBlock)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	block = binary.LittleEndian.AppendUint32(block, 0) // interface id
+	block = binary.LittleEndian.AppendUint32(block, uint32(ts>>32))
+	block = binary.LittleEndian.AppendUint32(block, uint32(ts))
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(data)))
+	block = binary.LittleEndian.AppendUint32(block, uint32(ci.Length))
+	block = append(block, data...)
+	block = append(block, make([]byte, pad4(len(data)))...)
+	block = append(block, options...)
+	block = binary.LittleEndian.AppendUint32(block, length)
+
+	return block
+}
+
+// pad4: padding of n bytes to 32 bits
+func pad4(n int) int {
+	return (4 - n&3) & 3
+}
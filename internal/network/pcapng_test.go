@@ -0,0 +1,147 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/internet-equity/traceneck/internal/config"
+	"github.com/internet-equity/traceneck/internal/meta"
+)
+
+// packetComments: comments of each enhanced packet block of a pcapng file, of which
+// gopacket's reader reads none
+func packetComments(t *testing.T, file []byte) [][]string {
+	var comments [][]string
+
+	for offset := 0; offset < len(file); {
+		blockType := binary.LittleEndian.Uint32(file[offset:])
+		length := int(binary.LittleEndian.Uint32(file[offset+4:]))
+		if length < 12 || offset+length > len(file) {
+			t.Fatalf("block at %d: invalid length %d", offset, length)
+		}
+
+		if blockType == ngEnhancedPacketBlock {
+			captured := int(binary.LittleEndian.Uint32(file[offset+20:]))
+			var packet []string
+			for option := offset + 28 + captured + pad4(captured); option < offset+length-4; {
+				code := binary.LittleEndian.Uint16(file[option:])
+				size := int(binary.LittleEndian.Uint16(file[option+2:]))
+				if code == ngOptionEnd {
+					break
+				}
+				if code == ngOptionComment {
+					packet = append(packet, string(file[option+4:option+4+size]))
+				}
+				option += 4 + size + pad4(size)
+			}
+			comments = append(comments, packet)
+		}
+
+		offset += length
+	}
+
+	return comments
+}
+
+func TestCaptureWriter(t *testing.T) {
+	config.Interface = "eth0"
+	meta.MMeta.ID = "f47ac10b-58cc-4372-a567-0e02b2c3d479"
+
+	packets := []struct {
+		data     []byte
+		length   int
+		marks    []meta.PhaseMark // before the packet
+		comments []string
+	}{
+		{[]byte{1, 2, 3, 4, 5}, 1500, nil, []string{"traceneck run " + meta.MMeta.ID}},
+		{bytes.Repeat([]byte{6}, 60), 60, nil, nil},
+		{
+			bytes.Repeat([]byte{7}, 13), 74,
+			[]meta.PhaseMark{{Phase: "download", Start: true, Time: 1700000001.5}},
+			[]string{"traceneck phase download start at 1700000001.500000"},
+		},
+		{[]byte{8, 9, 10, 11}, 4, nil, nil},
+		{
+			bytes.Repeat([]byte{12}, 7), 66,
+			[]meta.PhaseMark{{Phase: "download", Time: 1700000002.25}, {Phase: "upload", Start: true, Time: 1700000002.25}},
+			[]string{"traceneck phase download end at 1700000002.250000", "traceneck phase upload start at 1700000002.250000"},
+		},
+	}
+
+	var file bytes.Buffer
+	writer, err := newCaptureWriter(&file, layers.LinkTypeEthernet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, packet := range packets {
+		for _, mark := range packet.marks {
+			writer.mark(mark)
+		}
+		ci := gopacket.CaptureInfo{
+			Timestamp:     time.Unix(1700000000, 123456789+int64(i)),
+			CaptureLength: len(packet.data),
+			Length:        packet.length,
+		}
+		if err := writer.writePacket(ci, packet.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.close(pcapgo.NgInterfaceStatistics{PacketsReceived: uint64(len(packets))}); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := pcapgo.NewNgReader(bytes.NewReader(file.Bytes()), pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := reader.SectionInfo(); !strings.Contains(info.Comment, meta.MMeta.ID) {
+		t.Errorf("section comment: got %q", info.Comment)
+	}
+
+	var read int
+	for ; ; read++ {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read >= len(packets) {
+			continue
+		}
+
+		packet := packets[read]
+		if !bytes.Equal(data, packet.data) {
+			t.Errorf("packet %d: got data %v, want %v", read, data, packet.data)
+		}
+		if want := time.Unix(1700000000, 123456789+int64(read)); !ci.Timestamp.Equal(want) {
+			t.Errorf("packet %d: got timestamp %v, want %v", read, ci.Timestamp, want)
+		}
+		if ci.CaptureLength != len(packet.data) || ci.Length != packet.length {
+			t.Errorf("packet %d: got lengths %d, %d, want %d, %d", read, ci.CaptureLength, ci.Length, len(packet.data), packet.length)
+		}
+	}
+	if read != len(packets) {
+		t.Errorf("got %d packets, want %d", read, len(packets))
+	}
+
+	comments := packetComments(t, file.Bytes())
+	if len(comments) != len(packets) {
+		t.Fatalf("got %d enhanced packet blocks, want %d", len(comments), len(packets))
+	}
+	for i, packet := range packets {
+		if !slices.Equal(comments[i], packet.comments) {
+			t.Errorf("packet %d: got comments %q, want %q", i, comments[i], packet.comments)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package network
+
+import (
+	"errors"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/internet-equity/traceneck/internal/channel"
+	"github.com/internet-equity/traceneck/internal/config"
+	"github.com/internet-equity/traceneck/internal/discovery"
+)
+
+var ipGrabbed sync.Once
+
+// grabServerIP: set the server ip and release pings
+//
+// only the first ip grabbed (whether resolved up front or parsed from tool output) is
+// kept, as pings may already be running against it.
+func grabServerIP(ip net.IP, logPrefix string) {
+	grabbed := false
+
+	ipGrabbed.Do(func() {
+		config.ServerIP = ip
+		close(channel.IPGrabbed)
+		grabbed = true
+
+		log.Println(logPrefix, "grabbed server ip:", config.ServerIP)
+	})
+
+	if config.IPVersion != 0 && (config.IPVersion == 4) != (ip.To4() != nil) {
+		log.Println(logPrefix, "server ip", ip, "is not of ip version", config.IPVersion, "selected by the tool")
+	}
+	if !grabbed && !ip.Equal(config.ServerIP) {
+		log.Println(logPrefix, "server ip", ip, "differs from grabbed:", config.ServerIP)
+	}
+}
+
+// ResolveServer: resolve the configured server ahead of the speedtest
+//
+// the configured server is passed to the tool as is, as tools select servers by their
+// listed host names; the resolved address is that pinged and captured. only the native
+// iperf client is pointed at the resolved address.
+func ResolveServer() error {
+	if config.Server == "" {
+		return errors.New("no server configured")
+	}
+
+	host, _, err := net.SplitHostPort(config.Server)
+	if err != nil {
+		host = config.Server
+	}
+
+	ip, err := discovery.LookupIP(host, config.IPVersion)
+	if err != nil {
+		return err
+	}
+
+	if config.IperfAddr != "" {
+		_, iperfPort, _ := net.SplitHostPort(config.IperfAddr)
+		config.IperfAddr = net.JoinHostPort(ip.String(), iperfPort)
+	}
+
+	grabServerIP(ip, "[server]")
+
+	return nil
+}
+
+// DiscoverServer: select, resolve and grab the server ahead of the speedtest
+//
+// a configured server is resolved as is; otherwise the tool's server directory is
+// queried and the selected server is passed explicitly to the tool.
+func DiscoverServer() error {
+	if config.Server != "" {
+		return ResolveServer()
+	}
+
+	server, err := selectServer()
+	if err != nil {
+		return err
+	}
+
+	if err := server.Resolve(config.IPVersion); err != nil {
+		return err
+	}
+	log.Println("[server] discovered:", server.Name, server.Host)
+
+	switch config.Tool {
+	case "ndt":
+		config.ServiceURL = server.URL
+	case "ookla":
+		config.ServerID = server.ID
+	case "ookla-http":
+		config.Server = server.Host
+	}
+
+	grabServerIP(server.IP, "[server]")
+
+	return nil
+}
+
+// DiscoverHost: select the server host, unresolved, such that runs of either ip version
+// test against the same server [empty: the tool's]
+func DiscoverHost() (string, error) {
+	if config.Server != "" || config.NoDiscover {
+		return config.Server, nil
+	}
+
+	server, err := selectServer()
+	if err != nil {
+		return "", err
+	}
+	log.Println("[server] discovered:", server.Name, server.Host)
+
+	return server.Host, nil
+}
+
+// selectServer: query the tool's server directory
+func selectServer() (discovery.Server, error) {
+	client := discovery.Client{
+		BaseURL:   config.DiscoverURL,
+		UserAgent: config.NAME + "/" + config.VERSION,
+	}
+
+	var (
+		server discovery.Server
+		err    error
+	)
+
+	switch config.Tool {
+	case "ndt":
+		if client.BaseURL == "" {
+			client.BaseURL = discovery.LocateURL
+		}
+		server, err = client.Ndt7(config.Direction == "upload")
+	case "ookla", "ookla-http":
+		if client.BaseURL == "" {
+			client.BaseURL = discovery.OoklaURL
+		}
+		server, err = client.Ookla()
+	default:
+		return server, errors.New("no discovery for tool: " + config.Tool)
+	}
+
+	return server, err
+}
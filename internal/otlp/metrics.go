@@ -0,0 +1,84 @@
+/*
+ * otlp: opentelemetry metrics over otlp/http
+ *
+ * a minimal subset of the otlp metrics data model (gauges of double data points) encoded
+ * as protobuf json, as accepted by collectors at /v1/metrics
+ *
+ */
+package otlp
+
+import (
+	"math"
+	"strconv"
+)
+
+// ExportRequest: ExportMetricsServiceRequest
+type ExportRequest struct {
+	ResourceMetrics []ResourceMetrics `json:"resourceMetrics"`
+}
+
+type ResourceMetrics struct {
+	Resource     Resource       `json:"resource"`
+	ScopeMetrics []ScopeMetrics `json:"scopeMetrics"`
+}
+
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+type ScopeMetrics struct {
+	Scope   Scope    `json:"scope"`
+	Metrics []Metric `json:"metrics"`
+}
+
+type Scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type Metric struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	Gauge       Gauge  `json:"gauge"`
+}
+
+type Gauge struct {
+	DataPoints []DataPoint `json:"dataPoints"`
+}
+
+type DataPoint struct {
+	Attributes   []KeyValue `json:"attributes,omitempty"`
+	TimeUnixNano string     `json:"timeUnixNano"` // uint64 encoded as string
+	AsDouble     float64    `json:"asDouble"`
+}
+
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+type AnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"` // int64 encoded as string
+}
+
+// String: string attribute
+func String(key, value string) KeyValue {
+	return KeyValue{Key: key, Value: AnyValue{StringValue: &value}}
+}
+
+// Int: integer attribute
+func Int(key string, value int) KeyValue {
+	s := strconv.Itoa(value)
+	return KeyValue{Key: key, Value: AnyValue{IntValue: &s}}
+}
+
+// Point: data point at unix time t (seconds)
+func Point(t float64, value float64, attributes ...KeyValue) DataPoint {
+	return DataPoint{
+		Attributes:   attributes,
+		TimeUnixNano: strconv.FormatUint(uint64(math.Round(t*1e9)), 10),
+		AsDouble:     value,
+	}
+}
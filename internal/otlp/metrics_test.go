@@ -0,0 +1,75 @@
+package otlp
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func request() ExportRequest {
+	return ExportRequest{ResourceMetrics: []ResourceMetrics{{
+		Resource: Resource{Attributes: []KeyValue{String("service.name", "traceneck")}},
+		ScopeMetrics: []ScopeMetrics{{
+			Scope: Scope{Name: "traceneck", Version: "v1.2.3"},
+			Metrics: []Metric{{
+				Name:  "traceneck.hop.rtt",
+				Unit:  "ms",
+				Gauge: Gauge{DataPoints: []DataPoint{Point(1700000000.5, 12.5, Int("hop", 1))}},
+			}},
+		}},
+	}}}
+}
+
+func TestEncoding(t *testing.T) {
+	got, err := json.Marshal(request())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"resourceMetrics":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"traceneck"}}]},` +
+		`"scopeMetrics":[{"scope":{"name":"traceneck","version":"v1.2.3"},"metrics":[{"name":"traceneck.hop.rtt","unit":"ms",` +
+		`"gauge":{"dataPoints":[{"attributes":[{"key":"hop","value":{"intValue":"1"}}],"timeUnixNano":"1700000000500000000","asDouble":12.5}]}}]}]}]}`
+	if string(got) != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestExport(t *testing.T) {
+	var (
+		body   []byte
+		header http.Header
+		status = http.StatusOK
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/metrics" || r.Method != http.MethodPost {
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		}
+		body, _ = io.ReadAll(r.Body)
+		header = r.Header.Clone()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	client := Client{URL: server.URL + "/v1/metrics", Header: http.Header{"Authorization": {"Bearer secret"}}}
+	if err := client.Export(request()); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded ExportRequest
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if point := decoded.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Gauge.DataPoints[0]; point.AsDouble != 12.5 {
+		t.Errorf("data point: got %+v", point)
+	}
+	if header.Get("Authorization") != "Bearer secret" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("headers: got %v", header)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := client.Export(request()); err == nil {
+		t.Error("want error on unexpected status")
+	}
+}
@@ -0,0 +1,102 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// thrift compact protocol types
+const (
+	compactI32    = 5
+	compactI64    = 6
+	compactBinary = 8
+	compactList   = 9
+	compactStruct = 12
+)
+
+// compactWriter: encoder of the thrift compact protocol, as used by parquet metadata
+//
+// fields are written in increasing id order; struct nesting is tracked to encode field
+// id deltas.
+type compactWriter struct {
+	buf    bytes.Buffer
+	lastID []int16
+}
+
+func newCompactWriter() *compactWriter {
+	return &compactWriter{lastID: []int16{0}}
+}
+
+func (t *compactWriter) varint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *compactWriter) fieldHeader(id int16, typ byte) {
+	last := &t.lastID[len(t.lastID)-1]
+
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(uint64(uint16((id << 1) ^ (id >> 15))))
+	}
+
+	*last = id
+}
+
+func (t *compactWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, compactI32)
+	t.varint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+func (t *compactWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, compactI64)
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *compactWriter) binary(id int16, s string) {
+	t.fieldHeader(id, compactBinary)
+	t.elemBinary(s)
+}
+
+func (t *compactWriter) structBegin(id int16) {
+	t.fieldHeader(id, compactStruct)
+	t.elemStructBegin()
+}
+
+func (t *compactWriter) structEnd() {
+	t.buf.WriteByte(0)
+	t.lastID = t.lastID[:len(t.lastID)-1]
+}
+
+func (t *compactWriter) listBegin(id int16, elemType byte, size int) {
+	t.fieldHeader(id, compactList)
+
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.buf.WriteByte(0xf0 | elemType)
+		t.varint(uint64(size))
+	}
+}
+
+// list elements carry no field header
+
+func (t *compactWriter) elemStructBegin() {
+	t.lastID = append(t.lastID, 0)
+}
+
+func (t *compactWriter) elemI32(v int32) {
+	t.varint(uint64(uint32((v << 1) ^ (v >> 31))))
+}
+
+func (t *compactWriter) elemBinary(s string) {
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+// end: terminate the top-level struct and return the encoding
+func (t *compactWriter) end() []byte {
+	t.buf.WriteByte(0)
+	return t.buf.Bytes()
+}
@@ -0,0 +1,382 @@
+/*
+ * parquet: minimal Apache Parquet writer
+ *
+ * writes flat schemas of required or optional int32, int64, double and utf8 string
+ * columns; each row group holds one gzip-compressed, plain-encoded data page per column
+ *
+ */
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	magic = "PAR1"
+
+	DefaultRowGroupSize = 1 << 20 // rows
+)
+
+type Type int
+
+const (
+	Int32 Type = iota
+	Int64
+	Double
+	String
+)
+
+// parquet format enumerations
+const (
+	physicalInt32     = 1
+	physicalInt64     = 2
+	physicalDouble    = 5
+	physicalByteArray = 6
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	convertedUTF8 = 0
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecGzip = 2
+
+	pageData = 0
+)
+
+// Column: flat column definition
+type Column struct {
+	Name     string
+	Type     Type
+	Optional bool
+}
+
+// Writer: buffer rows and write row groups to an underlying writer
+//
+// rows are written as slices of int32, int64, float64 or string values (int is accepted
+// for either integer type), with nil for null values of optional columns.
+type Writer struct {
+	RowGroupSize int
+	CreatedBy    string
+
+	w       io.Writer
+	offset  int64
+	columns []Column
+	values  [][]any
+	rows    int
+	groups  []rowGroup
+	numRows int64
+}
+
+type columnChunk struct {
+	offset           int64
+	numValues        int64
+	uncompressedSize int64
+	compressedSize   int64
+}
+
+type rowGroup struct {
+	chunks  []columnChunk
+	numRows int64
+}
+
+// NewWriter: begin a parquet file with the given schema
+func NewWriter(w io.Writer, columns []Column) (*Writer, error) {
+	writer := &Writer{
+		RowGroupSize: DefaultRowGroupSize,
+		w:            w,
+		columns:      columns,
+		values:       make([][]any, len(columns)),
+	}
+
+	if err := writer.write([]byte(magic)); err != nil {
+		return nil, err
+	}
+
+	return writer, nil
+}
+
+func (w *Writer) write(data []byte) error {
+	n, err := w.w.Write(data)
+	w.offset += int64(n)
+	return err
+}
+
+// Write: buffer a row, writing a row group once full
+func (w *Writer) Write(row []any) error {
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("row has %d values, schema %d columns", len(row), len(w.columns))
+	}
+
+	converted := make([]any, len(row))
+	for i, value := range row {
+		var err error
+		if converted[i], err = convert(w.columns[i], value); err != nil {
+			return fmt.Errorf("column %s: %w", w.columns[i].Name, err)
+		}
+	}
+
+	for i, value := range converted {
+		w.values[i] = append(w.values[i], value)
+	}
+	w.rows++
+
+	if w.rows >= w.RowGroupSize {
+		return w.Flush()
+	}
+
+	return nil
+}
+
+func convert(column Column, value any) (any, error) {
+	if value == nil {
+		if !column.Optional {
+			return nil, errors.New("null value in required column")
+		}
+		return nil, nil
+	}
+
+	switch v := value.(type) {
+	case int32:
+		if column.Type == Int32 {
+			return v, nil
+		}
+	case int64:
+		if column.Type == Int64 {
+			return v, nil
+		}
+	case int:
+		if column.Type == Int32 && v >= math.MinInt32 && v <= math.MaxInt32 {
+			return int32(v), nil
+		}
+		if column.Type == Int64 {
+			return int64(v), nil
+		}
+	case float64:
+		if column.Type == Double {
+			return v, nil
+		}
+	case string:
+		if column.Type == String {
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid value %v (%T)", value, value)
+}
+
+// Flush: write buffered rows as a row group
+func (w *Writer) Flush() error {
+	if w.rows == 0 {
+		return nil
+	}
+
+	group := rowGroup{numRows: int64(w.rows)}
+
+	for i, column := range w.columns {
+		chunk, err := w.writeChunk(column, w.values[i])
+		if err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		w.values[i] = w.values[i][:0]
+	}
+
+	w.groups = append(w.groups, group)
+	w.numRows += group.numRows
+	w.rows = 0
+
+	return nil
+}
+
+// writeChunk: write a column chunk as a single data page
+func (w *Writer) writeChunk(column Column, values []any) (columnChunk, error) {
+	var page bytes.Buffer
+
+	if column.Optional {
+		levels := encodeLevels(values)
+		page.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(levels))))
+		page.Write(levels)
+	}
+
+	for _, value := range values {
+		switch v := value.(type) {
+		case int32:
+			page.Write(binary.LittleEndian.AppendUint32(nil, uint32(v)))
+		case int64:
+			page.Write(binary.LittleEndian.AppendUint64(nil, uint64(v)))
+		case float64:
+			page.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(v)))
+		case string:
+			page.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(v))))
+			page.WriteString(v)
+		}
+	}
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := gz.Write(page.Bytes()); err != nil {
+		return columnChunk{}, err
+	}
+	if err := gz.Close(); err != nil {
+		return columnChunk{}, err
+	}
+
+	header := newCompactWriter()
+	header.i32(1, pageData)
+	header.i32(2, int32(page.Len()))
+	header.i32(3, int32(compressed.Len()))
+	header.structBegin(5) // data page header
+	header.i32(1, int32(len(values)))
+	header.i32(2, encodingPlain)
+	header.i32(3, encodingRLE)
+	header.i32(4, encodingRLE)
+	header.structEnd()
+	headerBytes := header.end()
+
+	chunk := columnChunk{
+		offset:           w.offset,
+		numValues:        int64(len(values)),
+		uncompressedSize: int64(len(headerBytes) + page.Len()),
+		compressedSize:   int64(len(headerBytes) + compressed.Len()),
+	}
+
+	if err := w.write(headerBytes); err != nil {
+		return columnChunk{}, err
+	}
+	if err := w.write(compressed.Bytes()); err != nil {
+		return columnChunk{}, err
+	}
+
+	return chunk, nil
+}
+
+// encodeLevels: definition levels (1: defined, 0: null) in the rle/bit-packed hybrid encoding
+//
+// levels are written as rle runs of one-byte values.
+func encodeLevels(values []any) []byte {
+	var out []byte
+
+	for i := 0; i < len(values); {
+		defined := values[i] != nil
+
+		run := 1
+		for i+run < len(values) && (values[i+run] != nil) == defined {
+			run++
+		}
+
+		out = binary.AppendUvarint(out, uint64(run)<<1)
+		if defined {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+
+		i += run
+	}
+
+	return out
+}
+
+// Close: flush buffered rows and write the file footer
+func (w *Writer) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	footer := w.fileMetadata()
+
+	if err := w.write(footer); err != nil {
+		return err
+	}
+	if err := w.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer)))); err != nil {
+		return err
+	}
+	return w.write([]byte(magic))
+}
+
+func (w *Writer) fileMetadata() []byte {
+	meta := newCompactWriter()
+
+	meta.i32(1, 1) // version
+
+	meta.listBegin(2, compactStruct, len(w.columns)+1)
+	meta.elemStructBegin()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(w.columns)))
+	meta.structEnd()
+	for _, column := range w.columns {
+		meta.elemStructBegin()
+		meta.i32(1, physicalType(column.Type))
+		if column.Optional {
+			meta.i32(3, repetitionOptional)
+		} else {
+			meta.i32(3, repetitionRequired)
+		}
+		meta.binary(4, column.Name)
+		if column.Type == String {
+			meta.i32(6, convertedUTF8)
+		}
+		meta.structEnd()
+	}
+
+	meta.i64(3, w.numRows)
+
+	meta.listBegin(4, compactStruct, len(w.groups))
+	for _, group := range w.groups {
+		var totalSize int64
+
+		meta.elemStructBegin()
+		meta.listBegin(1, compactStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			totalSize += chunk.uncompressedSize
+
+			meta.elemStructBegin()
+			meta.i64(2, chunk.offset)
+			meta.structBegin(3) // column metadata
+			meta.i32(1, physicalType(w.columns[i].Type))
+			meta.listBegin(2, compactI32, 2)
+			meta.elemI32(encodingPlain)
+			meta.elemI32(encodingRLE)
+			meta.listBegin(3, compactBinary, 1)
+			meta.elemBinary(w.columns[i].Name)
+			meta.i32(4, codecGzip)
+			meta.i64(5, chunk.numValues)
+			meta.i64(6, chunk.uncompressedSize)
+			meta.i64(7, chunk.compressedSize)
+			meta.i64(9, chunk.offset)
+			meta.structEnd()
+			meta.structEnd()
+		}
+		meta.i64(2, totalSize)
+		meta.i64(3, group.numRows)
+		meta.structEnd()
+	}
+
+	if w.CreatedBy != "" {
+		meta.binary(6, w.CreatedBy)
+	}
+
+	return meta.end()
+}
+
+func physicalType(t Type) int32 {
+	switch t {
+	case Int32:
+		return physicalInt32
+	case Int64:
+		return physicalInt64
+	case Double:
+		return physicalDouble
+	default:
+		return physicalByteArray
+	}
+}
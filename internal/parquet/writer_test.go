@@ -0,0 +1,419 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// the reader below decodes files independently of the writer, after the parquet format
+// specification: a generic thrift compact protocol decoder of the footer and page headers,
+// and a decoder of the rle/bit-packed hybrid definition levels and plain values
+
+type thriftStruct map[int16]any
+
+func readVarint(r *bytes.Reader) uint64 {
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func readStruct(r *bytes.Reader) thriftStruct {
+	fields := make(thriftStruct)
+	var id int16
+
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			panic(err)
+		}
+		if b == 0 {
+			return fields
+		}
+
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			id = int16(unzigzag(readVarint(r)))
+		}
+		fields[id] = readValue(r, b&0x0f)
+	}
+}
+
+func readValue(r *bytes.Reader, typ byte) any {
+	switch typ {
+	case 1, 2: // bool in field header
+		return typ == 1
+	case 3:
+		b, _ := r.ReadByte()
+		return b
+	case 4, 5, 6:
+		return unzigzag(readVarint(r))
+	case 7:
+		var v float64
+		binary.Read(r, binary.LittleEndian, &v)
+		return v
+	case 8:
+		b := make([]byte, readVarint(r))
+		io.ReadFull(r, b)
+		return string(b)
+	case 9:
+		header, _ := r.ReadByte()
+		size := uint64(header >> 4)
+		if size == 15 {
+			size = readVarint(r)
+		}
+		list := make([]any, size)
+		for i := range list {
+			list[i] = readValue(r, header&0x0f)
+		}
+		return list
+	case 12:
+		return readStruct(r)
+	default:
+		panic(fmt.Sprintf("unexpected thrift type %d", typ))
+	}
+}
+
+type readColumn struct {
+	name     string
+	physical int64
+	optional bool
+	utf8     bool
+}
+
+// readFile: schema and rows of a parquet file, checking the footer against the pages
+func readFile(t *testing.T, data []byte) ([]readColumn, [][]any, thriftStruct) {
+	t.Helper()
+
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatal("missing magic")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := readStruct(bytes.NewReader(data[len(data)-8-footerLen : len(data)-8]))
+
+	schema := footer[2].([]any)
+	root := schema[0].(thriftStruct)
+	if root[5].(int64) != int64(len(schema)-1) {
+		t.Fatalf("root children: %v, schema elements %d", root[5], len(schema))
+	}
+
+	var columns []readColumn
+	for _, element := range schema[1:] {
+		e := element.(thriftStruct)
+		_, utf8 := e[6]
+		columns = append(columns, readColumn{
+			name:     e[4].(string),
+			physical: e[1].(int64),
+			optional: e[3].(int64) == repetitionOptional,
+			utf8:     utf8 && e[6].(int64) == convertedUTF8,
+		})
+	}
+
+	var rows [][]any
+	for _, group := range footer[4].([]any) {
+		g := group.(thriftStruct)
+		numRows := int(g[3].(int64))
+
+		groupRows := make([][]any, numRows)
+		for i := range groupRows {
+			groupRows[i] = make([]any, len(columns))
+		}
+
+		for c, chunk := range g[1].([]any) {
+			meta := chunk.(thriftStruct)[3].(thriftStruct)
+			if meta[3].([]any)[0] != columns[c].name || meta[1].(int64) != columns[c].physical {
+				t.Fatalf("chunk %d metadata: %v", c, meta)
+			}
+			if meta[4].(int64) != codecGzip || meta[5].(int64) != int64(numRows) {
+				t.Fatalf("chunk %d codec or values: %v", c, meta)
+			}
+
+			offset := meta[9].(int64)
+			r := bytes.NewReader(data[offset:])
+			header := readStruct(r)
+			headerLen := int64(len(data[offset:])) - int64(r.Len())
+			if meta[7].(int64) != headerLen+header[3].(int64) || meta[6].(int64) != headerLen+header[2].(int64) {
+				t.Fatalf("chunk %d sizes: %v, page header %v", c, meta, header)
+			}
+
+			compressed := make([]byte, header[3].(int64))
+			io.ReadFull(r, compressed)
+			gz, err := gzip.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, err := io.ReadAll(gz)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int64(len(page)) != header[2].(int64) {
+				t.Fatalf("page size: %d, header %v", len(page), header)
+			}
+
+			if n := header[5].(thriftStruct)[1].(int64); n != int64(numRows) {
+				t.Fatalf("page values: %d, rows %d", n, numRows)
+			}
+			for i, value := range decodePage(columns[c], page, numRows) {
+				groupRows[i][c] = value
+			}
+		}
+
+		rows = append(rows, groupRows...)
+	}
+
+	if footer[3].(int64) != int64(len(rows)) {
+		t.Fatalf("num rows: %v, read %d", footer[3], len(rows))
+	}
+
+	return columns, rows, footer
+}
+
+func decodePage(column readColumn, page []byte, n int) []any {
+	r := bytes.NewReader(page)
+
+	defined := make([]bool, 0, n)
+	if column.optional {
+		var length uint32
+		binary.Read(r, binary.LittleEndian, &length)
+		levels := bytes.NewReader(page[4 : 4+length])
+		r.Seek(int64(length), io.SeekCurrent)
+
+		for levels.Len() > 0 {
+			header := readVarint(levels)
+			if header&1 == 0 {
+				value, _ := levels.ReadByte()
+				for range header >> 1 {
+					defined = append(defined, value == 1)
+				}
+			} else {
+				for range header >> 1 {
+					b, _ := levels.ReadByte()
+					for bit := range 8 {
+						defined = append(defined, b>>bit&1 == 1)
+					}
+				}
+			}
+		}
+		defined = defined[:n]
+	} else {
+		for range n {
+			defined = append(defined, true)
+		}
+	}
+
+	values := make([]any, n)
+	for i := range values {
+		if !defined[i] {
+			continue
+		}
+		switch column.physical {
+		case physicalInt32:
+			var v int32
+			binary.Read(r, binary.LittleEndian, &v)
+			values[i] = v
+		case physicalInt64:
+			var v int64
+			binary.Read(r, binary.LittleEndian, &v)
+			values[i] = v
+		case physicalDouble:
+			var v float64
+			binary.Read(r, binary.LittleEndian, &v)
+			values[i] = v
+		case physicalByteArray:
+			var length uint32
+			binary.Read(r, binary.LittleEndian, &length)
+			b := make([]byte, length)
+			io.ReadFull(r, b)
+			values[i] = string(b)
+		}
+	}
+
+	return values
+}
+
+func TestRoundTrip(t *testing.T) {
+	columns := []Column{
+		{Name: "seq", Type: Int32},
+		{Name: "time", Type: Int64, Optional: true},
+		{Name: "rtt", Type: Double},
+		{Name: "hop", Type: String, Optional: true},
+	}
+
+	rows := [][]any{
+		{int32(0), int64(1700000000123456789), 12.5, "192.0.2.1"},
+		{1, nil, -0.25, nil},
+		{int32(math.MaxInt32), int64(math.MinInt64), math.Inf(1), ""},
+		{-7, nil, 0.0, "2001:db8::1"},
+		{int32(4), int64(-1), math.SmallestNonzeroFloat64, nil},
+		{int32(5), int64(5), 1e300, "héllo"},
+		{int32(6), nil, 6.0, "x"},
+	}
+
+	for _, rowGroupSize := range []int{1, 3, 7, DefaultRowGroupSize} {
+		t.Run(fmt.Sprint("row group size ", rowGroupSize), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, columns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.RowGroupSize = rowGroupSize
+			w.CreatedBy = "traceneck test"
+
+			for _, row := range rows {
+				if err := w.Write(row); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			readColumns, readRows, footer := readFile(t, buf.Bytes())
+
+			wantColumns := []readColumn{
+				{"seq", physicalInt32, false, false},
+				{"time", physicalInt64, true, false},
+				{"rtt", physicalDouble, false, false},
+				{"hop", physicalByteArray, true, true},
+			}
+			if !reflect.DeepEqual(readColumns, wantColumns) {
+				t.Errorf("schema: got %v, want %v", readColumns, wantColumns)
+			}
+
+			wantRows := make([][]any, len(rows))
+			for i, row := range rows {
+				wantRows[i] = make([]any, len(row))
+				for c, value := range row {
+					wantRows[i][c], _ = convert(columns[c], value)
+				}
+			}
+			if !reflect.DeepEqual(readRows, wantRows) {
+				t.Errorf("rows:\ngot  %v\nwant %v", readRows, wantRows)
+			}
+
+			if groups := len(footer[4].([]any)); groups != (len(rows)+rowGroupSize-1)/rowGroupSize {
+				t.Errorf("row groups: got %d", groups)
+			}
+			if footer[6] != "traceneck test" {
+				t.Errorf("created by: got %v", footer[6])
+			}
+		})
+	}
+}
+
+func TestWideSchema(t *testing.T) {
+	// schema lists of 15 or more elements take the long list header
+	var columns []Column
+	row := make([]any, 20)
+	for i := range 20 {
+		columns = append(columns, Column{Name: fmt.Sprint("c", i), Type: Int64, Optional: i%3 == 1})
+		if i%3 != 1 {
+			row[i] = int64(i * 1000)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 40 {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	readColumns, readRows, _ := readFile(t, buf.Bytes())
+	if len(readColumns) != 20 || len(readRows) != 40 {
+		t.Fatalf("got %d columns, %d rows", len(readColumns), len(readRows))
+	}
+	if !reflect.DeepEqual(readRows[39], row) {
+		t.Errorf("row: got %v, want %v", readRows[39], row)
+	}
+}
+
+func TestEmptyFile(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, []Column{{Name: "x", Type: String}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	columns, rows, footer := readFile(t, buf.Bytes())
+	if len(columns) != 1 || len(rows) != 0 || len(footer[4].([]any)) != 0 {
+		t.Errorf("got %v, %v, %v", columns, rows, footer)
+	}
+}
+
+func TestWriteErrors(t *testing.T) {
+	columns := []Column{
+		{Name: "a", Type: Int32},
+		{Name: "b", Type: String, Optional: true},
+	}
+
+	tests := []struct {
+		row []any
+		err string
+	}{
+		{[]any{int32(1)}, "row has 1 values"},
+		{[]any{nil, "x"}, "null value in required column"},
+		{[]any{int64(1), "x"}, "invalid value"},
+		{[]any{math.MaxInt32 + 1, "x"}, "invalid value"},
+		{[]any{1, 2.0}, "invalid value"},
+	}
+
+	for _, test := range tests {
+		w, err := NewWriter(io.Discard, columns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(test.row); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: got %v, want %q", test.row, err, test.err)
+		}
+	}
+}
+
+func TestCompactWriter(t *testing.T) {
+	c := newCompactWriter()
+	c.i32(1, -1)       // short header, zigzag 1
+	c.i64(20, 300)     // long header: delta beyond 15
+	c.binary(21, "ab") // short header after a long one
+	c.structBegin(22)  // nested struct restarts ids
+	c.i32(1, 63)       // zigzag 126
+	c.structEnd()      // stop
+	c.listBegin(23, compactI32, 2)
+	c.elemI32(64)  // zigzag 128: two-byte varint
+	c.elemI32(-64) // zigzag 127
+	got := c.end()
+
+	want := []byte{
+		0x15, 0x01,
+		0x06, 0x28, 0xd8, 0x04,
+		0x18, 0x02, 'a', 'b',
+		0x1c, 0x15, 0x7e, 0x00,
+		0x19, 0x25, 0x80, 0x01, 0x7f,
+		0x00,
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got  % x\nwant % x", got, want)
+	}
+}
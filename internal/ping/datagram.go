@@ -0,0 +1,111 @@
+package ping
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/internet-equity/traceneck/internal/config"
+)
+
+// unprivileged pings on icmp datagram sockets
+//
+// the kernel sets the echo id of a datagram socket's probes to its port and delivers the
+// socket the replies to them: echo replies as such, and time exceeded messages to its
+// error queue (IP_RECVERR), by the offending hop and quoting only the probe's icmp header.
+// each sender's socket is read by a reader of its own, passing replies to the listener.
+
+// datagramReply: reply to a probe of a datagram socket, and the echo message it carries
+// or quotes
+type datagramReply struct {
+	reply reply
+	msg   *icmp.Message
+}
+
+var datagramReplies chan datagramReply
+
+func datagram() bool {
+	return config.PingSocket == "datagram"
+}
+
+// listenDatagram: handle replies of the senders' readers until the listener is stopped
+func listenDatagram() {
+	for {
+		select {
+		case <-stopListener:
+			return
+		case d := <-datagramReplies:
+			handleEchoReply(d.reply, d.msg)
+		}
+	}
+}
+
+// startReader: enable reply headers, time exceeded messages and timestamps on conn and
+// start its reader, which closes conn once the listener is stopped
+func startReader(conn *icmp.PacketConn) {
+	var (
+		udpConn *net.UDPConn
+		err     error
+	)
+	if msgProto == protocolICMP {
+		udpConn, _ = conn.IPv4PacketConn().PacketConn.(*net.UDPConn)
+		err = conn.IPv4PacketConn().SetControlMessage(ipv4.FlagTTL, true)
+	} else {
+		udpConn, _ = conn.IPv6PacketConn().PacketConn.(*net.UDPConn)
+		err = conn.IPv6PacketConn().SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagTrafficClass, true)
+	}
+	if udpConn == nil {
+		log.Println("[ping] [datagram reader] not a datagram connection")
+		conn.Close()
+		return
+	}
+	if err != nil {
+		log.Println("[ping] [datagram reader] error enabling reply headers:", err)
+	}
+	if err := enableErrorQueue(udpConn); err != nil {
+		log.Println("[ping] [datagram reader] error enabling time exceeded messages:", err)
+	}
+	setTimestamping(udpConn, rxTimestamping)
+
+	go readDatagram(conn, udpConn)
+}
+
+// readDatagram: read replies to the probes of conn until the listener is stopped
+func readDatagram(conn *icmp.PacketConn, udpConn *net.UDPConn) {
+	defer conn.Close()
+
+	buffer := make([]byte, icmpBufferSize)
+	oob := make([]byte, oobBufferSize)
+
+	for {
+		select {
+		case <-stopListener:
+			return
+		default:
+			if err := udpConn.SetReadDeadline(time.Now().Add(packetReadDelay)); err != nil {
+				log.Println("[ping] [datagram reader] error setting deadline:", err)
+				return
+			}
+
+			n, r, quoted, err := recvDatagram(udpConn, buffer, oob)
+			if err != nil {
+				break
+			}
+
+			msg, err := icmp.ParseMessage(msgProto, buffer[:n])
+			if err != nil || (!quoted && msg.Type != typeEchoReply) {
+				break
+			}
+
+			select {
+			case datagramReplies <- datagramReply{reply: r, msg: msg}:
+			case <-stopListener:
+				return
+			}
+		}
+	}
+}
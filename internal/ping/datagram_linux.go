@@ -0,0 +1,151 @@
+package ping
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+	"unsafe"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+// icmp types of time exceeded, in extended errors
+const (
+	icmpTimeExceeded   = 11
+	icmpv6TimeExceeded = 3
+)
+
+var errNotExceeded = errors.New("not a time exceeded error")
+
+// enableErrorQueue: deliver icmp errors to the error queue of conn
+func enableErrorQueue(conn *net.UDPConn) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if msgProto == protocolICMP {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_RECVERR, 1)
+		} else {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_RECVERR, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// recvDatagram: read a reply of conn into b: an echo reply, else the probe header quoted
+// by a time exceeded message of the error queue [quoted true]
+func recvDatagram(conn *net.UDPConn, b, oob []byte) (n int, r reply, quoted bool, err error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, r, false, err
+	}
+
+	var (
+		oobn    int
+		from    unix.Sockaddr
+		recvErr error
+	)
+	err = rawConn.Read(func(fd uintptr) bool {
+		n, oobn, _, from, recvErr = unix.Recvmsg(int(fd), b, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+		if recvErr == nil {
+			quoted = true
+			return true
+		}
+		n, oobn, _, from, recvErr = unix.Recvmsg(int(fd), b, oob, unix.MSG_DONTWAIT)
+		return !errors.Is(recvErr, unix.EAGAIN)
+	})
+	if err != nil {
+		return 0, r, false, err
+	}
+	if recvErr != nil {
+		return 0, r, false, recvErr
+	}
+
+	recvStamp := parseStamp(oob[:oobn], time.Now())
+
+	if !quoted {
+		ttl, tos := -1, -1
+		if msgProto == protocolICMP {
+			var cm ipv4.ControlMessage
+			if cm.Parse(oob[:oobn]) == nil {
+				ttl = cm.TTL
+			}
+		} else {
+			var cm ipv6.ControlMessage
+			if cm.Parse(oob[:oobn]) == nil {
+				ttl, tos = cm.HopLimit, cm.TrafficClass
+			}
+		}
+		return n, newReply(sockaddrIP(from), recvStamp, ttl, tos, -1), false, nil
+	}
+
+	offender, err := exceededBy(oob[:oobn])
+	if err != nil {
+		return 0, r, false, err
+	}
+	return n, newReply(offender, recvStamp, -1, -1, -1), true, nil
+}
+
+// exceededBy: hop of a time exceeded error, by the extended error of control messages oob
+func exceededBy(oob []byte) (net.IP, error) {
+	messages, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+
+	eeLen := int(unsafe.Sizeof(unix.SockExtendedErr{}))
+	for _, m := range messages {
+		if !(m.Header.Level == unix.SOL_IP && m.Header.Type == unix.IP_RECVERR) &&
+			!(m.Header.Level == unix.SOL_IPV6 && m.Header.Type == unix.IPV6_RECVERR) {
+			continue
+		}
+		if len(m.Data) < eeLen {
+			continue
+		}
+
+		ee := (*unix.SockExtendedErr)(unsafe.Pointer(&m.Data[0]))
+		switch {
+		case ee.Origin == unix.SO_EE_ORIGIN_ICMP && ee.Type == icmpTimeExceeded,
+			ee.Origin == unix.SO_EE_ORIGIN_ICMP6 && ee.Type == icmpv6TimeExceeded:
+		default:
+			return nil, errNotExceeded
+		}
+
+		// offender: sockaddr following the extended error
+		offender := m.Data[eeLen:]
+		if len(offender) < 2 {
+			return nil, errNotExceeded
+		}
+		switch binary.NativeEndian.Uint16(offender) {
+		case unix.AF_INET:
+			if len(offender) >= 8 {
+				return net.IP(offender[4:8]).To16(), nil
+			}
+		case unix.AF_INET6:
+			if len(offender) >= 24 {
+				return net.IP(offender[8:24]), nil
+			}
+		}
+	}
+
+	return nil, errNotExceeded
+}
+
+func sockaddrIP(sa unix.Sockaddr) net.IP {
+	switch addr := sa.(type) {
+	case *unix.SockaddrInet4:
+		return net.IP(addr.Addr[:]).To16()
+	case *unix.SockaddrInet6:
+		return net.IP(addr.Addr[:])
+	}
+	return nil
+}
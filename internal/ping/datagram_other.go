@@ -0,0 +1,23 @@
+//go:build !linux
+
+package ping
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// enableErrorQueue: time exceeded messages are not delivered to datagram sockets but on
+// linux, such that only echo replies are received
+func enableErrorQueue(*net.UDPConn) error {
+	return errors.New("icmp errors of datagram sockets not supported on this platform")
+}
+
+func recvDatagram(conn *net.UDPConn, b, oob []byte) (int, reply, bool, error) {
+	n, _, _, peer, err := conn.ReadMsgUDP(b, oob)
+	if err != nil {
+		return 0, reply{}, false, err
+	}
+	return n, newReply(peer.IP, userStamp(time.Now()), -1, -1, -1), false, nil
+}
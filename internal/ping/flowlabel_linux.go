@@ -0,0 +1,25 @@
+package ping
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// fixFlowLabel: send the ipv6 probes of conn with flow label 0, rather than with labels
+// hashed per flow, such that routers balancing load by flow label route them alike
+func fixFlowLabel(conn syscall.Conn) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_AUTOFLOWLABEL, 0)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package ping
+
+import "syscall"
+
+// fixFlowLabel: flow labels are left to the kernel
+func fixFlowLabel(syscall.Conn) error {
+	return nil
+}
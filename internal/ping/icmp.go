@@ -4,6 +4,7 @@ import (
 	"log"
 	"net"
 	"os"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/icmp"
@@ -18,52 +19,66 @@ import (
 
 var ID = os.Getpid() & 0xffff
 
-func handleEchoReply(replyIP net.IP, recvTime time.Time, msg *icmp.Message) {
+func handleEchoReply(r reply, msg *icmp.Message) {
 	msgBody, ok := msg.Body.(*icmp.Echo)
-	if !ok || msgBody.ID != ID || msgBody.Seq < 0 {
+	if !ok || (!datagram() && msgBody.ID != ID) {
 		return
 	}
 
-	pktNo := msgBody.Seq
-	i := pktNo % slots
-	round := pktNo / slots
+	seq := msgBody.Seq
+	i, round := roundOf(seq)
+	if round < 0 {
+		return
+	}
+	pktNo := i + round*slots
 
-	var reqTime time.Time
+	var sent stamp
 	if value, ok := timestamps[i].Load(round); ok {
-		reqTime = value.(time.Time)
+		sent = value.(stamp)
 	} else {
 		return
 	}
 
-	rtt := float64(recvTime.Sub(reqTime).Nanoseconds()) / 1000000
+	rtt, source := r.stamp.sub(sent)
 
-	meta.MSamples[pktNo] = meta.RttSample{
+	sample := meta.RttSample{
 		TTL:       getTTL(i),
 		Round:     round + 1,
-		ReplyIP:   replyIP,
-		SendTime:  timeUtil.UnixPrecise(reqTime),
-		RecvTime:  timeUtil.UnixPrecise(recvTime),
+		ReplyIP:   r.ip,
+		SendTime:  timeUtil.UnixPrecise(sent.time),
+		RecvTime:  timeUtil.UnixPrecise(r.stamp.time),
 		RTT:       rtt,
-		IcmpSeqNo: &pktNo,
+		IcmpSeqNo: &seq,
+
+		TimestampSource: source,
 	}
+	r.annotate(&sample)
+	meta.MSamples[pktNo] = sample
+	received[pktNo] = r.stamp
 
 	if directHopIP == nil && i == config.DirectHop {
-		directHopIP = replyIP
+		directHopIP = r.ip
 	}
 }
 
-func handleTimeExceededICMP(replyIP net.IP, recvTime time.Time, msg *icmp.Message) {
+func handleTimeExceededICMP(r reply, msg *icmp.Message) {
 	msgBody, ok := msg.Body.(*icmp.TimeExceeded)
 	if !ok {
 		return
 	}
 
-	reqMsg, err := icmp.ParseMessage(msgProto, msgBody.Data[20:])
+	r, ipHeaderLen, ok := r.quote(msgBody.Data)
+	if !ok {
+		return
+	}
+	r = r.extend(msgBody.Extensions)
+
+	reqMsg, err := icmp.ParseMessage(msgProto, msgBody.Data[ipHeaderLen:])
 	if err != nil {
 		return
 	}
 
-	handleEchoReply(replyIP, recvTime, reqMsg)
+	handleEchoReply(r, reqMsg)
 }
 
 func senderICMP(i int, dstIP net.IP) {
@@ -72,42 +87,73 @@ func senderICMP(i int, dstIP net.IP) {
 	conn, err := icmp.ListenPacket(listenNetwork, listenAddr)
 	if err != nil {
 		log.Println("[ping] [icmp sender] error opening connection:", err)
+		return
+	}
+	if datagram() {
+		startReader(conn)
+	} else {
+		defer conn.Close()
 	}
-	defer conn.Close()
 
-	var typeEchoRequest icmp.Type
+	var (
+		typeEchoRequest icmp.Type
+		packetConn      net.PacketConn
+	)
 	if dstIP.To4() == nil {
+		packetConn = conn.IPv6PacketConn().PacketConn
 		err = conn.IPv6PacketConn().SetHopLimit(getTTL(i))
+		if err == nil && config.ProbeTOS != 0 {
+			err = conn.IPv6PacketConn().SetTrafficClass(config.ProbeTOS)
+		}
+		if sysConn, ok := packetConn.(syscall.Conn); ok && err == nil {
+			err = fixFlowLabel(sysConn)
+		}
 		typeEchoRequest = ipv6.ICMPTypeEchoRequest
 	} else {
+		packetConn = conn.IPv4PacketConn().PacketConn
 		err = conn.IPv4PacketConn().SetTTL(getTTL(i))
+		if err == nil && config.ProbeTOS != 0 {
+			err = conn.IPv4PacketConn().SetTOS(config.ProbeTOS)
+		}
 		typeEchoRequest = ipv4.ICMPTypeEcho
 	}
 	if err != nil {
-		log.Println("[ping] [icmp sender] error setting ttl:", err)
+		log.Println("[ping] [icmp sender] error setting ttl, tos or flow label:", err)
 		return
 	}
 
-	dstAddr := &net.IPAddr{IP: dstIP}
+	var sent *stamper
+	// the error queue of datagram sockets is their reader's
+	if tsConn, ok := packetConn.(timestampConn); ok && !datagram() {
+		sent = newStamper(tsConn)
+	}
+
+	var dstAddr net.Addr = &net.IPAddr{IP: dstIP}
+	if datagram() {
+		dstAddr = &net.UDPAddr{IP: dstIP}
+	}
 	msg := &icmp.Message{
 		Type: typeEchoRequest,
 		Code: 0,
 		Body: &icmp.Echo{
-			ID:  ID,
-			Seq: i - slots,
+			ID:   ID,
+			Data: config.ProbePayload,
 		},
 	}
 
-	for r := 0; ; r++ {
+	for r := startRound(i); ; r++ {
 		select {
 		case <-channel.Stop:
 			return
-		case <-time.After(packetSendDelay):
-			msg.Body.(*icmp.Echo).Seq += slots
+		case <-time.After(time.Until(probeTime(i, r))):
+			msg.Body.(*icmp.Echo).Seq = wireSeq(i, r)
 			if msgBytes, err := msg.Marshal(nil); err == nil {
-				timestamps[i].Store(r, time.Now())
+				timestamps[i].Store(r, userStamp(time.Now()))
+				lastRound[i].Store(int64(r))
 				if _, err := conn.WriteTo(msgBytes, dstAddr); err != nil {
 					log.Println("[ping] [icmp sender] error sending packet:", err)
+				} else {
+					sent.sent(i, r)
 				}
 			} else {
 				log.Println("[ping] [icmp sender] error encoding packet:", err)
@@ -124,12 +170,15 @@ func lostLoggerICMP(i int) (total, dropped int) {
 		pktNo := i + r*slots
 		total += 1
 
-		if _, ok := meta.MSamples[pktNo]; !ok {
+		if sample, ok := meta.MSamples[pktNo]; ok {
+			meta.MSamples[pktNo] = settle(sample, pktNo, value.(stamp))
+		} else {
+			seq := wireSeq(i, r)
 			meta.MSamples[pktNo] = meta.RttSample{
 				TTL:       ttl,
 				Round:     r + 1,
-				SendTime:  timeUtil.UnixPrecise(value.(time.Time)),
-				IcmpSeqNo: &pktNo,
+				SendTime:  timeUtil.UnixPrecise(value.(stamp).time),
+				IcmpSeqNo: &seq,
 			}
 			dropped += 1
 		}
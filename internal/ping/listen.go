@@ -1,23 +1,69 @@
 package ping
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net"
 	"time"
 
 	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+
+	"github.com/internet-equity/traceneck/internal/analysis"
+	"github.com/internet-equity/traceneck/internal/meta"
 )
 
+// reply: received icmp message's source and ip header fields, and those of the probe it
+// quotes (time exceeded)
+type reply struct {
+	ip    net.IP
+	stamp stamp // receive timestamp
+
+	ttl int // remaining ttl (hop limit) [-1: unavailable]
+	tos int // tos (traffic class) [-1: unavailable]
+	id  int // ip id [-1: ipv6]
+
+	quotedTTL int // ttl (hop limit) of the quoted probe [-1: echo reply]
+	quotedTOS int // tos (traffic class) of the quoted probe [-1: echo reply]
+
+	mpls       []analysis.MplsLabel    // label stack of icmp extensions
+	interfaces []analysis.HopInterface // interfaces of icmp extensions
+}
+
+// interfaceIndexAttr: c-type bit of an interface information object including its ifIndex
+const interfaceIndexAttr = 0x08
+
+// oobBufferSize: buffer of control messages of a packet
+const oobBufferSize = 512
+
+var errShortHeader = errors.New("ip header beyond packet")
+
+// readFunc: read of an icmp message into b, returning its length and reply fields
+type readFunc func(b []byte) (int, reply, error)
+
 func listener() {
 	defer close(listenerDone)
 
-	conn, err := icmp.ListenPacket(listenNetwork, listenAddr)
+	if datagram() {
+		listenDatagram()
+		return
+	}
+
+	conn, err := net.ListenPacket(listenNetwork, listenAddr)
 	if err != nil {
 		log.Println("[ping] [listener] error opening connection:", err)
 		return
 	}
 	defer conn.Close()
 
+	read, err := headerReader(conn)
+	if err != nil {
+		log.Println("[ping] [listener] error enabling ip headers:", err)
+		return
+	}
+
 	buffer := make([]byte, icmpBufferSize)
 
 	for {
@@ -30,11 +76,10 @@ func listener() {
 				return
 			}
 
-			n, peer, err := conn.ReadFrom(buffer)
+			n, r, err := read(buffer)
 			if err != nil {
 				break
 			}
-			recvTime := time.Now()
 
 			msg, err := icmp.ParseMessage(msgProto, buffer[:n])
 			if err != nil {
@@ -43,10 +88,171 @@ func listener() {
 
 			switch msg.Type {
 			case typeEchoReply:
-				handleEchoReply(net.ParseIP(peer.String()), recvTime, msg)
-			case typeTimeExceeded:
-				timeExceededHandler(net.ParseIP(peer.String()), recvTime, msg)
+				handleEchoReply(r, msg)
+			case typeTimeExceeded, typeUnreachable:
+				timeExceededHandler(r, msg)
+			}
+		}
+	}
+}
+
+// headerReader: read of icmp messages with the ip header of their reply, from the raw
+// ipv4 header, else from ipv6 control messages, and their kernel timestamps
+func headerReader(conn net.PacketConn) (readFunc, error) {
+	ipConn, ok := conn.(*net.IPConn)
+	if !ok {
+		return nil, fmt.Errorf("not an ip connection: %T", conn)
+	}
+
+	if err := setTimestamping(ipConn, rxTimestamping); err != nil {
+		timestampingLog.Do(func() {
+			log.Println("[ping] kernel timestamps unavailable, timing replies in user space:", err)
+		})
+	}
+
+	oob := make([]byte, oobBufferSize)
+
+	if msgProto == protocolICMP {
+		return func(b []byte) (int, reply, error) {
+			n, oobn, _, _, err := ipConn.ReadMsgIP(b, oob)
+			if err != nil {
+				return 0, reply{}, err
+			}
+			recvStamp := parseStamp(oob[:oobn], time.Now())
+
+			header, err := ipv4.ParseHeader(b[:n])
+			if err != nil {
+				return 0, reply{}, err
+			}
+			if header.Len > n {
+				return 0, reply{}, errShortHeader
+			}
+
+			r := newReply(header.Src, recvStamp, header.TTL, header.TOS, header.ID)
+			return copy(b, b[header.Len:n]), r, nil
+		}, nil
+	}
+
+	packet := ipv6.NewPacketConn(conn)
+	if err := packet.SetControlMessage(ipv6.FlagHopLimit|ipv6.FlagTrafficClass, true); err != nil {
+		return nil, err
+	}
+
+	return func(b []byte) (int, reply, error) {
+		n, oobn, _, peer, err := ipConn.ReadMsgIP(b, oob)
+		if err != nil {
+			return 0, reply{}, err
+		}
+		recvStamp := parseStamp(oob[:oobn], time.Now())
+
+		ttl, tos := -1, -1
+		var cm ipv6.ControlMessage
+		if err := cm.Parse(oob[:oobn]); err == nil {
+			ttl, tos = cm.HopLimit, cm.TrafficClass
+		}
+		return n, newReply(peer.IP, recvStamp, ttl, tos, -1), nil
+	}, nil
+}
+
+// newReply: reply received from ip at recvStamp, quoting no probe
+func newReply(ip net.IP, recvStamp stamp, ttl, tos, id int) reply {
+	return reply{ip: ip, stamp: recvStamp, ttl: ttl, tos: tos, id: id, quotedTTL: -1, quotedTOS: -1}
+}
+
+// quote: reply with the ttl and tos of the probe header quoted in data, and the length
+// of that header, including any ipv6 extension headers [ok false: data too short to hold
+// the header and 8 bytes of payload]
+func (r reply) quote(data []byte) (quoted reply, headerLen int, ok bool) {
+	if len(data) == 0 {
+		return r, 0, false
+	}
+
+	switch data[0] >> 4 {
+	case 4:
+		headerLen = int(data[0]&0x0f) << 2
+		if headerLen < ipv4.HeaderLen || len(data) < headerLen+8 {
+			return r, 0, false
+		}
+		r.quotedTTL, r.quotedTOS = int(data[8]), int(data[1])
+	case 6:
+		if len(data) < ipv6.HeaderLen {
+			return r, 0, false
+		}
+		headerLen = extensionHeadersEnd(data)
+		if headerLen < 0 || len(data) < headerLen+8 {
+			return r, 0, false
+		}
+		r.quotedTTL, r.quotedTOS = int(data[7]), int(data[0]&0x0f)<<4|int(data[1]>>4)
+	default:
+		return r, 0, false
+	}
+
+	return r, headerLen, true
+}
+
+// extensionHeadersEnd: offset of the upper-layer header of an ipv6 packet, past its
+// extension headers [-1: truncated]
+func extensionHeadersEnd(data []byte) int {
+	next, offset := int(data[6]), ipv6.HeaderLen
+	for {
+		switch next {
+		case ipv6HopByHop, ipv6Routing, ipv6DestinationOptions:
+			if len(data) < offset+2 {
+				return -1
+			}
+			next, offset = int(data[offset]), offset+(int(data[offset+1])+1)*8
+		case ipv6Fragment:
+			if len(data) < offset+8 {
+				return -1
 			}
+			next, offset = int(data[offset]), offset+8
+		default:
+			return offset
 		}
 	}
 }
+
+// extend: reply with the mpls label stack (rfc 4950) and interfaces (rfc 5837) of its
+// multipart icmp extensions (rfc 4884)
+func (r reply) extend(extensions []icmp.Extension) reply {
+	for _, extension := range extensions {
+		switch ext := extension.(type) {
+		case *icmp.MPLSLabelStack:
+			for _, label := range ext.Labels {
+				r.mpls = append(r.mpls, analysis.MplsLabel{Label: label.Label, TC: label.TC, S: label.S, TTL: label.TTL})
+			}
+		case *icmp.InterfaceInfo:
+			ifc := analysis.HopInterface{Role: analysis.InterfaceRole(ext.Type)}
+			if ext.Interface != nil {
+				if ext.Type&interfaceIndexAttr != 0 {
+					ifc.Index = &ext.Interface.Index
+				}
+				ifc.Name, ifc.MTU = ext.Interface.Name, ext.Interface.MTU
+			}
+			if ext.Addr != nil {
+				ifc.Addr = ext.Addr.IP
+			}
+			r.interfaces = append(r.interfaces, ifc)
+		}
+	}
+
+	return r
+}
+
+// annotate: record the header fields of the reply in sample
+func (r reply) annotate(sample *meta.RttSample) {
+	sample.ReplyTTL = optional(r.ttl)
+	sample.ReplyTOS = optional(r.tos)
+	sample.ReplyIPID = optional(r.id)
+	sample.QuotedTTL = optional(r.quotedTTL)
+	sample.QuotedTOS = optional(r.quotedTOS)
+	sample.Mpls = r.mpls
+	sample.Interfaces = r.interfaces
+}
+
+func optional(v int) *int {
+	if v < 0 {
+		return nil
+	}
+	return &v
+}
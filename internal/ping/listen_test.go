@@ -0,0 +1,94 @@
+package ping
+
+import "testing"
+
+// ipv4Quote: quoted ipv4 probe header of ihl, ttl and tos, followed by payload bytes
+func ipv4Quote(ihl byte, ttl, tos byte, payload int) []byte {
+	data := make([]byte, int(ihl)*4+payload)
+	data[0], data[1], data[8], data[9] = 0x40|ihl, tos, ttl, protocolICMP
+	return data
+}
+
+func TestQuoteIPv4(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		ok        bool
+		headerLen int
+	}{
+		{"header and 8 bytes", ipv4Quote(5, 3, 0xb8, 8), true, 20},
+		{"options", ipv4Quote(6, 3, 0xb8, 8), true, 24},
+		{"whole probe", ipv4Quote(5, 3, 0xb8, 64), true, 20},
+		{"short payload", ipv4Quote(5, 3, 0xb8, 7), false, 0},
+		{"ihl below minimum", ipv4Quote(4, 3, 0xb8, 12), false, 0},
+		{"truncated ihl", append([]byte{0x4f}, make([]byte, 27)...), false, 0},
+		{"first byte only", []byte{0x45}, false, 0},
+		{"empty", nil, false, 0},
+		{"not ip", append([]byte{0x55}, make([]byte, 27)...), false, 0},
+	}
+
+	for _, test := range tests {
+		r, headerLen, ok := newReply(nil, stamp{}, 60, 0, 1).quote(test.data)
+		if ok != test.ok || headerLen != test.headerLen {
+			t.Errorf("%s: got ok %v, header length %d, want %v, %d", test.name, ok, headerLen, test.ok, test.headerLen)
+			continue
+		}
+		if !ok && (r.quotedTTL != -1 || r.quotedTOS != -1) {
+			t.Errorf("%s: quoted ttl %d, tos %d of an invalid quote", test.name, r.quotedTTL, r.quotedTOS)
+		}
+		if ok && (r.quotedTTL != 3 || r.quotedTOS != 0xb8) {
+			t.Errorf("%s: got quoted ttl %d, tos %#x, want 3, 0xb8", test.name, r.quotedTTL, r.quotedTOS)
+		}
+		if r.ttl != 60 {
+			t.Errorf("%s: reply ttl overwritten: %d", test.name, r.ttl)
+		}
+	}
+}
+
+// ipv6Quote: quoted ipv6 probe header of hop limit 3 and traffic class 0xb8, followed by
+// the extension headers, each of next header as its first byte, and payload bytes
+func ipv6Quote(next byte, extensions []byte, payload int) []byte {
+	data := make([]byte, 40, 40+len(extensions)+payload)
+	data[0], data[1], data[6], data[7] = 0x6b, 0x80, next, 3
+	data = append(data, extensions...)
+	return append(data, make([]byte, payload)...)
+}
+
+func TestQuoteIPv6(t *testing.T) {
+	hopByHop := []byte{protocolIPv6ICMP, 0, 1, 4, 0, 0, 0, 0}
+	fragment := []byte{protocolIPv6ICMP, 0, 0, 1, 0, 0, 0, 42}
+
+	tests := []struct {
+		name      string
+		data      []byte
+		ok        bool
+		headerLen int
+	}{
+		{"header and 8 bytes", ipv6Quote(protocolIPv6ICMP, nil, 8), true, 40},
+		{"short payload", ipv6Quote(protocolIPv6ICMP, nil, 7), false, 0},
+		{"short header", ipv6Quote(protocolIPv6ICMP, nil, 0)[:39], false, 0},
+		{"version only", []byte{0x60}, false, 0},
+		{"hop-by-hop", ipv6Quote(ipv6HopByHop, hopByHop, 8), true, 48},
+		{"fragment", ipv6Quote(ipv6Fragment, fragment, 8), true, 48},
+		{"chain", ipv6Quote(ipv6DestinationOptions, append([]byte{ipv6Fragment, 0, 1, 4, 0, 0, 0, 0}, fragment...), 8), true, 56},
+		// header length of 6 units past the data
+		{"extension past data", ipv6Quote(ipv6Routing, []byte{protocolIPv6ICMP, 5, 0, 0, 0, 0, 0, 0}, 8), false, 0},
+		{"truncated extension", ipv6Quote(ipv6HopByHop, []byte{protocolIPv6ICMP}, 0), false, 0},
+		{"truncated fragment", ipv6Quote(ipv6Fragment, fragment[:4], 0), false, 0},
+		{"chain past data", ipv6Quote(ipv6HopByHop, []byte{ipv6Routing, 0, 1, 4, 0, 0, 0, 0}, 0), false, 0},
+	}
+
+	for _, test := range tests {
+		r, headerLen, ok := newReply(nil, stamp{}, 60, 0, -1).quote(test.data)
+		if ok != test.ok || headerLen != test.headerLen {
+			t.Errorf("%s: got ok %v, header length %d, want %v, %d", test.name, ok, headerLen, test.ok, test.headerLen)
+			continue
+		}
+		if !ok && (r.quotedTTL != -1 || r.quotedTOS != -1) {
+			t.Errorf("%s: quoted hop limit %d, traffic class %d of an invalid quote", test.name, r.quotedTTL, r.quotedTOS)
+		}
+		if ok && (r.quotedTTL != 3 || r.quotedTOS != 0xb8) {
+			t.Errorf("%s: got quoted hop limit %d, traffic class %#x, want 3, 0xb8", test.name, r.quotedTTL, r.quotedTOS)
+		}
+	}
+}
@@ -2,8 +2,10 @@ package ping
 
 import (
 	"log"
+	"math/rand/v2"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/icmp"
@@ -17,25 +19,39 @@ import (
 )
 
 const (
-	icmpBufferSize = 56
+	icmpBufferSize = 1500
 
 	protocolICMP     = 1  // Internet Control Message
 	protocolIPv6ICMP = 58 // ICMP for IPv6
 
+	// ipv6 extension headers
+	ipv6HopByHop           = 0
+	ipv6Routing            = 43
+	ipv6Fragment           = 44
+	ipv6DestinationOptions = 60
+
 	replyListenDelay = time.Second
 	packetReadDelay  = 100 * time.Millisecond
-	packetSendDelay  = 100 * time.Millisecond
+
+	seqSpace = 1 << 16 // icmp sequence numbers, and udp ports
 )
 
 var (
 	slots      int
-	timestamps []sync.Map
+	timestamps []sync.Map     // send stamps of probes by round, per slot
+	received   map[int]stamp  // receive stamps of replies by packet number
+	wireRounds int            // rounds of a slot distinguished on the wire
+	lastRound  []atomic.Int64 // latest round sent, per slot
+
+	scheduleStart time.Time       // origin of the probe schedule
+	scheduleMutex sync.Mutex      // guards roundOffsets
+	roundOffsets  []time.Duration // send offsets of rounds from scheduleStart, shared by slots
 
 	stopListener channel.Type
 	listenerDone channel.Type
 	senderDone   []channel.Type
 
-	timeExceededHandler func(net.IP, time.Time, *icmp.Message)
+	timeExceededHandler func(reply, *icmp.Message)
 	sender              func(int, net.IP)
 	lostLogger          func(int) (int, int)
 
@@ -44,6 +60,7 @@ var (
 	msgProto         int
 	typeEchoReply    icmp.Type
 	typeTimeExceeded icmp.Type
+	typeUnreachable  icmp.Type
 
 	directHopIP net.IP
 )
@@ -58,11 +75,71 @@ func getTTL(i int) (ttl int) {
 	return
 }
 
+// probeTime: send time of the probe of round r, slot i, hops being staggered across the
+// probe interval such that probes to all hops are not sent in bursts
+//
+// rounds are drawn once for all slots, such that round r of each hop is sent within the
+// stagger of the others also where poisson, as paired by round in analysis.
+func probeTime(i, r int) time.Time {
+	scheduleMutex.Lock()
+	for len(roundOffsets) <= r {
+		var last time.Duration
+		if n := len(roundOffsets); n > 0 {
+			last = roundOffsets[n-1]
+		}
+		roundOffsets = append(roundOffsets, last+probeDelay())
+	}
+	offset := roundOffsets[r]
+	scheduleMutex.Unlock()
+
+	return scheduleStart.Add(offset).Add(config.ProbeInterval * time.Duration(i) / time.Duration(slots))
+}
+
+// startRound: first round of slot i not yet due, a sender starting late (the direct hop,
+// once discovered) skipping past rounds rather than sending them in a burst
+func startRound(i int) (r int) {
+	for time.Until(probeTime(i, r)) < 0 {
+		r++
+	}
+	return
+}
+
+// wireSeq: on-wire sequence number (icmp sequence number, else udp port offset) of the
+// probe of round r, slot i, wrapping every wireRounds rounds
+func wireSeq(i, r int) int {
+	return i + r%wireRounds*slots
+}
+
+// roundOf: slot and round of the probe of on-wire sequence number seq, being the latest
+// round sent of that number [-1: not sent]
+//
+// replies later than wireRounds probe intervals are thus mismatched.
+func roundOf(seq int) (i, round int) {
+	i = seq % slots
+	if seq < 0 || seq/slots >= wireRounds {
+		return i, -1
+	}
+
+	latest := int(lastRound[i].Load())
+	return i, latest - ((latest-seq/slots)%wireRounds+wireRounds)%wireRounds
+}
+
+// probeDelay: delay between rounds of probes, exponentially distributed where poisson
+func probeDelay() time.Duration {
+	if config.ProbePoisson {
+		return time.Duration(rand.ExpFloat64() * float64(config.ProbeInterval))
+	}
+	return config.ProbeInterval
+}
+
 func PingProcess() {
 	defer close(channel.PingDone)
 
 	slots = config.MaxTTL + 1
 	timestamps = make([]sync.Map, slots)
+	received = make(map[int]stamp)
+	lastRound = make([]atomic.Int64, slots)
+	roundOffsets = nil
 
 	stopListener = make(channel.Type)
 	listenerDone = make(channel.Type)
@@ -72,17 +149,25 @@ func PingProcess() {
 		timeExceededHandler = handleTimeExceededICMP
 		sender = senderICMP
 		lostLogger = lostLoggerICMP
+		wireRounds = seqSpace / slots
 	} else {
 		timeExceededHandler = handleTimeExceededUDP
 		sender = senderUDP
 		lostLogger = lostLoggerUDP
+		wireRounds = (seqSpace - startingPort) / slots
 	}
 
 	for i := 0; i < slots; i++ {
 		senderDone[i] = make(channel.Type)
 	}
 
-	<-channel.IPGrabbed
+	select {
+	case <-channel.IPGrabbed:
+	case <-channel.Stop:
+		// speedtest ended without a server to ping
+		log.Println("[ping] no server ip grabbed")
+		return
+	}
 
 	if config.ServerIP.To4() == nil {
 		listenNetwork = "ip6:ipv6-icmp"
@@ -90,12 +175,23 @@ func PingProcess() {
 		msgProto = protocolIPv6ICMP
 		typeEchoReply = ipv6.ICMPTypeEchoReply
 		typeTimeExceeded = ipv6.ICMPTypeTimeExceeded
+		typeUnreachable = ipv6.ICMPTypeDestinationUnreachable
 	} else {
 		listenNetwork = "ip4:icmp"
 		listenAddr = "0.0.0.0"
 		msgProto = protocolICMP
 		typeEchoReply = ipv4.ICMPTypeEchoReply
 		typeTimeExceeded = ipv4.ICMPTypeTimeExceeded
+		typeUnreachable = ipv4.ICMPTypeDestinationUnreachable
+	}
+	if datagram() {
+		// senders' network, replies being read on their sockets
+		if msgProto == protocolICMP {
+			listenNetwork = "udp4"
+		} else {
+			listenNetwork = "udp6"
+		}
+		datagramReplies = make(chan datagramReply, slots)
 	}
 
 	log.Println("[ping] started")
@@ -103,6 +199,7 @@ func PingProcess() {
 
 	go listener()
 
+	scheduleStart = time.Now()
 	for i := 1; i < slots; i++ {
 		go sender(i, config.ServerIP)
 	}
@@ -131,20 +228,33 @@ func PingProcess() {
 	)
 
 	if directHopIP == nil {
-		meta.MSamples[0] = meta.RttSample{
-			TTL:       config.DirectHop,
-			Round:     0,
-			ReplyIP:   net.ParseIP("0.0.0.0"),
-			IcmpSeqNo: new(int),
+		sample := meta.RttSample{
+			TTL:     config.DirectHop,
+			Round:   0,
+			ReplyIP: net.IPv4zero,
 		}
+		if config.ServerIP.To4() == nil {
+			sample.ReplyIP = net.IPv6unspecified
+		}
+		if config.PingType == "icmp" {
+			sample.IcmpSeqNo = new(int)
+		} else {
+			udpPort := startingPort
+			sample.UdpDestPort = &udpPort
+		}
+		meta.MSamples[0] = sample
 	} else {
-		total, dropped = lostLoggerICMP(0)
+		total, dropped = lostLogger(0)
 	}
 	log.Println("[ping] hop:", config.DirectHop, "total:", total, "dropped:", dropped)
+	if config.DirectHop != 0 {
+		meta.MHopProbes = append(meta.MHopProbes, meta.HopProbes{TTL: config.DirectHop, Direct: true, Total: total, Dropped: dropped})
+	}
 
 	for i := 1; i < slots; i++ {
 		total, dropped = lostLogger(i)
 		log.Println("[ping] hop:", i, "total:", total, "dropped:", dropped)
+		meta.MHopProbes = append(meta.MHopProbes, meta.HopProbes{TTL: i, Total: total, Dropped: dropped})
 	}
 
 	log.Println("[ping] logging complete")
@@ -158,7 +268,7 @@ func pingDirect() {
 			return
 		case <-time.After(packetReadDelay):
 			if directHopIP != nil {
-				senderICMP(0, directHopIP)
+				sender(0, directHopIP)
 				return
 			}
 		}
@@ -0,0 +1,80 @@
+package ping
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/internet-equity/traceneck/internal/config"
+)
+
+func TestRoundOf(t *testing.T) {
+	slots = 31
+	wireRounds = seqSpace / slots
+	lastRound = make([]atomic.Int64, slots)
+
+	for _, r := range []int{0, 1, wireRounds - 1, wireRounds, 3*wireRounds + 7} {
+		i := 5
+		seq := wireSeq(i, r)
+		if seq >= seqSpace {
+			t.Fatalf("round %d: sequence number %d wraps past 16 bits", r, seq)
+		}
+
+		lastRound[i].Store(int64(r))
+		if gotI, got := roundOf(seq); gotI != i || got != r {
+			t.Errorf("round %d: got slot %d, round %d", r, gotI, got)
+		}
+
+		// reply to an earlier probe of the slot, within the wrap
+		lastRound[i].Store(int64(r + 10))
+		if _, got := roundOf(seq); got != r {
+			t.Errorf("round %d, 10 rounds on: got round %d", r, got)
+		}
+	}
+
+	lastRound[2].Store(3)
+	if _, got := roundOf(wireSeq(2, 4)); got >= 0 {
+		t.Errorf("unsent round: got %d", got)
+	}
+	if _, got := roundOf(wireRounds * slots); got >= 0 {
+		t.Errorf("sequence number beyond the wrap: got %d", got)
+	}
+}
+
+func TestProbeTime(t *testing.T) {
+	defer func(interval time.Duration, poisson bool) {
+		config.ProbeInterval, config.ProbePoisson = interval, poisson
+	}(config.ProbeInterval, config.ProbePoisson)
+	config.ProbeInterval = 10 * time.Millisecond
+	config.ProbePoisson = true
+
+	slots = 31
+	scheduleStart = time.Now()
+	roundOffsets = nil
+
+	stagger := func(i int) time.Duration { return config.ProbeInterval * time.Duration(i) / time.Duration(slots) }
+
+	var prev time.Time
+	for r := 0; r < 100; r++ {
+		first := probeTime(1, r)
+		if r > 0 && first.Before(prev) {
+			t.Errorf("round %d: sent before round %d", r, r-1)
+		}
+		prev = first
+
+		// hops of a round staggered across one interval of the first, however drawn
+		for i := 2; i < slots; i++ {
+			if got, want := probeTime(i, r).Sub(first), stagger(i)-stagger(1); got != want {
+				t.Fatalf("round %d, slot %d: got offset %v, want %v", r, i, got, want)
+			}
+		}
+	}
+
+	if r := startRound(1); r != 0 {
+		t.Errorf("start round: got %d, want 0", r)
+	}
+	scheduleStart = time.Now().Add(-time.Second)
+	if r := startRound(1); time.Until(probeTime(1, r)) < 0 || r > 0 && time.Until(probeTime(1, r-1)) >= 0 {
+		t.Errorf("late start round: got %d", r)
+	}
+}
@@ -0,0 +1,111 @@
+package ping
+
+import (
+	"log"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/internet-equity/traceneck/internal/meta"
+	timeUtil "github.com/internet-equity/traceneck/internal/util/time"
+)
+
+// sources of packet timestamps
+const (
+	sourceHardware = "hardware" // nic clock, of both send and receive
+	sourceSoftware = "software" // kernel
+	sourceUser     = "user"     // user space, about the syscall
+	sourceMixed    = "mixed"    // send and receive of different sources
+)
+
+const sentStampDelay = 5 * time.Millisecond // wait for the kernel's timestamp of a sent probe
+
+var timestampingLog sync.Once
+
+// stamp: packet timestamp by the system clock, and by the nic's clock where available
+type stamp struct {
+	time     time.Time // system clock
+	hardware time.Time // nic clock [zero: unavailable]
+	source   string    // source of time: software or user
+}
+
+func userStamp(t time.Time) stamp {
+	return stamp{time: t, source: sourceUser}
+}
+
+// sub: rtt (ms) of a reply received at s to a probe sent at sent, and the timestamps'
+// source, being of the nic's clock where both are
+func (s stamp) sub(sent stamp) (rtt float64, source string) {
+	if !s.hardware.IsZero() && !sent.hardware.IsZero() {
+		return float64(s.hardware.Sub(sent.hardware).Nanoseconds()) / 1000000, sourceHardware
+	}
+
+	source = s.source
+	if sent.source != s.source {
+		source = sourceMixed
+	}
+	return float64(s.time.Sub(sent.time).Nanoseconds()) / 1000000, source
+}
+
+// settle: sample of pktNo with the send time and rtt of its final send stamp, the kernel's
+// timestamp of a probe possibly being read after its reply was handled
+func settle(sample meta.RttSample, pktNo int, sent stamp) meta.RttSample {
+	recv, ok := received[pktNo]
+	if !ok {
+		return sample
+	}
+
+	sample.SendTime = timeUtil.UnixPrecise(sent.time)
+	sample.RTT, sample.TimestampSource = recv.sub(sent)
+	return sample
+}
+
+// stamper: kernel timestamps of probes sent on a connection
+type stamper struct {
+	conn   timestampConn
+	rounds []int // rounds of probes sent, by their timestamp counter
+}
+
+type timestampConn interface {
+	syscall.Conn
+	SetReadDeadline(time.Time) error
+}
+
+// newStamper: stamper of probes sent on conn, being nil where the kernel does not
+// timestamp them
+func newStamper(conn timestampConn) *stamper {
+	if err := setTimestamping(conn, txTimestamping); err != nil {
+		timestampingLog.Do(func() {
+			log.Println("[ping] kernel timestamps unavailable, timing probes in user space:", err)
+		})
+		return nil
+	}
+
+	return &stamper{conn: conn}
+}
+
+// sent: record the kernel's timestamp of the probe of round r, slot i, just sent
+func (s *stamper) sent(i, r int) {
+	if s == nil {
+		return
+	}
+
+	id := len(s.rounds)
+	s.rounds = append(s.rounds, r)
+
+	if err := s.conn.SetReadDeadline(time.Now().Add(sentStampDelay)); err != nil {
+		return
+	}
+	for {
+		key, sent, ok := readSentStamp(s.conn)
+		if !ok {
+			return
+		}
+		if int(key) < len(s.rounds) {
+			timestamps[i].Store(s.rounds[key], sent)
+		}
+		if int(key) >= id {
+			return
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package ping
+
+import (
+	"errors"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// timestamping flags of the listener (received) and senders (sent): software and raw
+// hardware timestamps, those of sent probes by counter and without looping them back
+const (
+	rxTimestamping = unix.SOF_TIMESTAMPING_RX_SOFTWARE | unix.SOF_TIMESTAMPING_RX_HARDWARE |
+		unix.SOF_TIMESTAMPING_SOFTWARE | unix.SOF_TIMESTAMPING_RAW_HARDWARE
+	txTimestamping = unix.SOF_TIMESTAMPING_TX_SOFTWARE | unix.SOF_TIMESTAMPING_TX_HARDWARE |
+		unix.SOF_TIMESTAMPING_SOFTWARE | unix.SOF_TIMESTAMPING_RAW_HARDWARE |
+		unix.SOF_TIMESTAMPING_OPT_ID | unix.SOF_TIMESTAMPING_OPT_TSONLY
+)
+
+func setTimestamping(conn syscall.Conn, flags int) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_TIMESTAMPING, flags)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// parseStamp: timestamp of a packet by its control messages oob, else at fallback
+func parseStamp(oob []byte, fallback time.Time) stamp {
+	s := userStamp(fallback)
+
+	messages, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return s
+	}
+
+	for _, m := range messages {
+		if m.Header.Level != unix.SOL_SOCKET || m.Header.Type != unix.SCM_TIMESTAMPING ||
+			len(m.Data) < int(unsafe.Sizeof(unix.ScmTimestamping{})) {
+			continue
+		}
+
+		// software timestamp first, raw hardware timestamp third
+		ts := (*unix.ScmTimestamping)(unsafe.Pointer(&m.Data[0]))
+		if software := ts.Ts[0]; software.Sec != 0 || software.Nsec != 0 {
+			s.time, s.source = time.Unix(software.Unix()), sourceSoftware
+		}
+		if hardware := ts.Ts[2]; hardware.Sec != 0 || hardware.Nsec != 0 {
+			s.hardware = time.Unix(hardware.Unix())
+		}
+	}
+
+	return s
+}
+
+// readSentStamp: timestamp of a sent probe from the error queue of conn, and its counter
+// [ok false: none by the read deadline]
+func readSentStamp(conn syscall.Conn) (key uint32, s stamp, ok bool) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, s, false
+	}
+
+	oob := make([]byte, oobBufferSize)
+	var oobn int
+	var recvErr error
+
+	err = rawConn.Read(func(fd uintptr) bool {
+		_, oobn, _, _, recvErr = unix.Recvmsg(int(fd), nil, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+		return !errors.Is(recvErr, unix.EAGAIN)
+	})
+	if err != nil || recvErr != nil {
+		return 0, s, false
+	}
+
+	messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return 0, s, false
+	}
+
+	var stamped, keyed bool
+	for _, m := range messages {
+		switch {
+		case m.Header.Level == unix.SOL_IP && m.Header.Type == unix.IP_RECVERR,
+			m.Header.Level == unix.SOL_IPV6 && m.Header.Type == unix.IPV6_RECVERR:
+			if len(m.Data) < int(unsafe.Sizeof(unix.SockExtendedErr{})) {
+				continue
+			}
+			ee := (*unix.SockExtendedErr)(unsafe.Pointer(&m.Data[0]))
+			if ee.Origin == unix.SO_EE_ORIGIN_TIMESTAMPING {
+				key, keyed = ee.Data, true
+			}
+		case m.Header.Level == unix.SOL_SOCKET && m.Header.Type == unix.SCM_TIMESTAMPING:
+			s, stamped = parseStamp(oob[:oobn], time.Time{}), true
+		}
+	}
+
+	return key, s, stamped && keyed && !s.time.IsZero()
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package ping
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+const (
+	rxTimestamping = 0
+	txTimestamping = 0
+)
+
+func setTimestamping(syscall.Conn, int) error {
+	return errors.New("kernel timestamps not supported on this platform")
+}
+
+func parseStamp(_ []byte, fallback time.Time) stamp {
+	return userStamp(fallback)
+}
+
+func readSentStamp(syscall.Conn) (uint32, stamp, bool) {
+	return 0, stamp{}, false
+}
@@ -18,47 +18,77 @@ import (
 
 const startingPort = 1024
 
-func handleTimeExceededUDP(replyIP net.IP, recvTime time.Time, msg *icmp.Message) {
-	msgBody, ok := msg.Body.(*icmp.TimeExceeded)
-	if !ok {
-		return
+// port unreachable codes of destination unreachable messages
+const (
+	codePortUnreachable4 = 3
+	codePortUnreachable6 = 4
+)
+
+func codePortUnreachable() int {
+	if msgProto == protocolICMP {
+		return codePortUnreachable4
 	}
+	return codePortUnreachable6
+}
 
-	ipHeaderLen := 40
-	if (msgBody.Data[0] >> 4) == 4 {
-		ipHeaderLen = int((msgBody.Data[0] & 0x0F) << 2)
+// handleTimeExceededUDP: handle the time exceeded reply of a hop to a probe, else the
+// port unreachable reply of its destination (the server, or the direct hop)
+func handleTimeExceededUDP(r reply, msg *icmp.Message) {
+	var (
+		data       []byte
+		extensions []icmp.Extension
+	)
+	switch msgBody := msg.Body.(type) {
+	case *icmp.TimeExceeded:
+		data, extensions = msgBody.Data, msgBody.Extensions
+	case *icmp.DstUnreach:
+		if msg.Code != codePortUnreachable() {
+			return
+		}
+		data, extensions = msgBody.Data, msgBody.Extensions
+	default:
+		return
 	}
 
-	dstPort := int(binary.BigEndian.Uint16(msgBody.Data[ipHeaderLen+2 : ipHeaderLen+4]))
-	if dstPort < startingPort {
+	r, ipHeaderLen, ok := r.quote(data)
+	if !ok {
 		return
 	}
+	r = r.extend(extensions)
 
-	pktNo := dstPort - startingPort
-	i := pktNo % slots
-	round := pktNo / slots
+	dstPort := int(binary.BigEndian.Uint16(data[ipHeaderLen+2 : ipHeaderLen+4]))
+	i, round := roundOf(dstPort - startingPort)
+	if round < 0 {
+		return
+	}
+	pktNo := i + round*slots
 
-	var reqTime time.Time
+	var sent stamp
 	if value, ok := timestamps[i].Load(round); ok {
-		reqTime = value.(time.Time)
+		sent = value.(stamp)
 	} else {
 		return
 	}
 
-	rtt := float64(recvTime.Sub(reqTime).Nanoseconds()) / 1000000
+	rtt, source := r.stamp.sub(sent)
 
-	meta.MSamples[pktNo] = meta.RttSample{
+	sample := meta.RttSample{
 		TTL:         getTTL(i),
 		Round:       round + 1,
-		ReplyIP:     replyIP,
-		SendTime:    timeUtil.UnixPrecise(reqTime),
-		RecvTime:    timeUtil.UnixPrecise(recvTime),
+		ReplyIP:     r.ip,
+		SendTime:    timeUtil.UnixPrecise(sent.time),
+		RecvTime:    timeUtil.UnixPrecise(r.stamp.time),
 		RTT:         rtt,
 		UdpDestPort: &dstPort,
+
+		TimestampSource: source,
 	}
+	r.annotate(&sample)
+	meta.MSamples[pktNo] = sample
+	received[pktNo] = r.stamp
 
 	if i == config.DirectHop && directHopIP == nil {
-		directHopIP = replyIP
+		directHopIP = r.ip
 	}
 }
 
@@ -72,29 +102,42 @@ func senderUDP(i int, dstIP net.IP) {
 	}
 
 	if dstIP.To4() == nil {
-		err = ipv6.NewPacketConn(conn).SetHopLimit(getTTL(i))
+		packet := ipv6.NewPacketConn(conn)
+		err = packet.SetHopLimit(getTTL(i))
+		if err == nil && config.ProbeTOS != 0 {
+			err = packet.SetTrafficClass(config.ProbeTOS)
+		}
+		if err == nil {
+			err = fixFlowLabel(conn)
+		}
 	} else {
-		err = ipv4.NewPacketConn(conn).SetTTL(getTTL(i))
+		packet := ipv4.NewPacketConn(conn)
+		err = packet.SetTTL(getTTL(i))
+		if err == nil && config.ProbeTOS != 0 {
+			err = packet.SetTOS(config.ProbeTOS)
+		}
 	}
 	if err != nil {
-		log.Println("[ping] [udp sender] error setting ttl:", err)
+		log.Println("[ping] [udp sender] error setting ttl, tos or flow label:", err)
 		return
 	}
 
-	dstAddr := net.UDPAddr{
-		IP:   dstIP,
-		Port: startingPort + i - slots,
-	}
+	sent := newStamper(conn)
+
+	dstAddr := net.UDPAddr{IP: dstIP}
 
-	for r := 0; ; r++ {
+	for r := startRound(i); ; r++ {
 		select {
 		case <-channel.Stop:
 			return
-		case <-time.After(packetSendDelay):
-			dstAddr.Port += slots
-			timestamps[i].Store(r, time.Now())
-			if _, err := conn.WriteTo(nil, &dstAddr); err != nil {
+		case <-time.After(time.Until(probeTime(i, r))):
+			dstAddr.Port = startingPort + wireSeq(i, r)
+			timestamps[i].Store(r, userStamp(time.Now()))
+			lastRound[i].Store(int64(r))
+			if _, err := conn.WriteTo(config.ProbePayload, &dstAddr); err != nil {
 				log.Println("[ping] [udp sender] error sending packet:", err)
+			} else {
+				sent.sent(i, r)
 			}
 		}
 	}
@@ -108,12 +151,14 @@ func lostLoggerUDP(i int) (total, dropped int) {
 		pktNo := i + r*slots
 		total += 1
 
-		if _, ok := meta.MSamples[pktNo]; !ok {
-			udpPort := startingPort + pktNo
+		if sample, ok := meta.MSamples[pktNo]; ok {
+			meta.MSamples[pktNo] = settle(sample, pktNo, value.(stamp))
+		} else {
+			udpPort := startingPort + wireSeq(i, r)
 			meta.MSamples[pktNo] = meta.RttSample{
 				TTL:         ttl,
 				Round:       r + 1,
-				SendTime:    timeUtil.UnixPrecise(value.(time.Time)),
+				SendTime:    timeUtil.UnixPrecise(value.(stamp).time),
 				UdpDestPort: &udpPort,
 			}
 			dropped += 1
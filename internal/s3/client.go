@@ -0,0 +1,221 @@
+/*
+ * client: minimal S3-compatible object storage client
+ *
+ * implements the multipart upload operations against AWS S3 or any compatible store
+ * (MinIO, Ceph RGW); custom endpoints are addressed path-style, AWS virtual-hosted style
+ *
+ */
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRegion = "us-east-1"
+
+	requestTimeout = 5 * time.Minute
+)
+
+// Client: object store endpoint, region and credentials
+type Client struct {
+	Endpoint    string // base url of custom endpoint [empty: AWS]
+	Region      string
+	Credentials Credentials
+	HTTP        *http.Client
+}
+
+// Error: error response of the object store
+type Error struct {
+	Status  string
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (err Error) Error() string {
+	if err.Code == "" {
+		return "unexpected status: " + err.Status
+	}
+	return fmt.Sprintf("%s: %s (%s)", err.Code, err.Message, err.Status)
+}
+
+type initiateResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+// ParseURL: split s3://bucket/key into bucket and key
+func ParseURL(rawURL string) (bucket, key string, err error) {
+	rest, ok := strings.CutPrefix(rawURL, "s3://")
+	if !ok {
+		return "", "", errors.New("not an s3:// url")
+	}
+
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" {
+		return "", "", errors.New("missing bucket")
+	}
+
+	return bucket, key, nil
+}
+
+func (c Client) objectURL(bucket, key string, query url.Values) (*url.URL, error) {
+	var u *url.URL
+
+	if c.Endpoint == "" {
+		u = &url.URL{
+			Scheme: "https",
+			Host:   bucket + ".s3." + c.Region + ".amazonaws.com",
+			Path:   "/" + key,
+		}
+	} else {
+		endpoint, err := url.Parse(c.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		u = endpoint.JoinPath(bucket, key)
+	}
+
+	// send the path as signed
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = strings.ReplaceAll(query.Encode(), "uploads=", "uploads")
+	return u, nil
+}
+
+// do: sign and send a request, decoding error responses
+func (c Client) do(method, bucket, key string, query url.Values, body []byte) (*http.Response, error) {
+	u, err := c.objectURL(bucket, key, query)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := EmptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+	c.Credentials.sign(req, c.Region, payloadHash, time.Now())
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: requestTimeout}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, decodeError(resp)
+	}
+
+	return resp, nil
+}
+
+func decodeError(resp *http.Response) error {
+	respErr := Error{Status: resp.Status}
+	xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&respErr)
+	return respErr
+}
+
+// createMultipart: initiate a multipart upload, returning its id
+func (c Client) createMultipart(bucket, key string) (string, error) {
+	resp, err := c.do(http.MethodPost, bucket, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var result initiateResult
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.UploadID == "" {
+		return "", errors.New("no upload id in response")
+	}
+
+	return result.UploadID, nil
+}
+
+// uploadPart: upload a part, returning its etag
+func (c Client) uploadPart(bucket, key, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{
+		"partNumber": {strconv.Itoa(number)},
+		"uploadId":   {uploadID},
+	}
+
+	resp, err := c.do(http.MethodPut, bucket, key, query, data)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", errors.New("no etag in response")
+	}
+
+	return etag, nil
+}
+
+// completeMultipart: assemble uploaded parts into the object
+func (c Client) completeMultipart(bucket, key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(completeUpload{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(http.MethodPost, bucket, key, url.Values{"uploadId": {uploadID}}, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// completion may fail after a 200 status: the error is then in the body
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("<Error>")) {
+		respErr := Error{Status: resp.Status}
+		xml.Unmarshal(data, &respErr)
+		return respErr
+	}
+
+	return nil
+}
+
+// abortMultipart: discard uploaded parts
+func (c Client) abortMultipart(bucket, key, uploadID string) error {
+	resp, err := c.do(http.MethodDelete, bucket, key, url.Values{"uploadId": {uploadID}}, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
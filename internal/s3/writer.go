@@ -0,0 +1,107 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	PartSize = 8 * 1024 * 1024 // bytes per part [minimum 5 MiB for all but the last]
+	maxParts = 10000
+)
+
+// Writer: stream an object as a multipart upload
+//
+// written data is buffered into parts, each uploaded once full; Close uploads the final
+// part and completes the upload, while Abort discards it.
+type Writer struct {
+	client   Client
+	bucket   string
+	key      string
+	uploadID string
+
+	buf   []byte
+	parts []completedPart
+	err   error
+}
+
+// NewWriter: initiate a multipart upload of bucket/key
+func NewWriter(client Client, bucket, key string) (*Writer, error) {
+	uploadID, err := client.createMultipart(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		client:   client,
+		bucket:   bucket,
+		key:      key,
+		uploadID: uploadID,
+		buf:      make([]byte, 0, PartSize),
+	}, nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), PartSize-len(w.buf))
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(w.buf) == PartSize {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+func (w *Writer) flush() error {
+	number := len(w.parts) + 1
+	if number > maxParts {
+		w.err = errors.New("object exceeds maximum number of parts")
+		return w.err
+	}
+
+	etag, err := w.client.uploadPart(w.bucket, w.key, w.uploadID, number, w.buf)
+	if err != nil {
+		w.err = fmt.Errorf("part %d: %w", number, err)
+		return w.err
+	}
+
+	w.parts = append(w.parts, completedPart{PartNumber: number, ETag: etag})
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// Close: upload the final part and complete the upload (aborting on failure)
+func (w *Writer) Close() error {
+	if w.err == nil && (len(w.buf) > 0 || len(w.parts) == 0) {
+		w.flush()
+	}
+	if w.err == nil {
+		w.err = w.client.completeMultipart(w.bucket, w.key, w.uploadID, w.parts)
+	}
+	if w.err != nil {
+		w.Abort()
+		return w.err
+	}
+
+	w.err = errors.New("writer closed")
+	return nil
+}
+
+// Abort: discard the upload
+func (w *Writer) Abort() error {
+	if w.err == nil {
+		w.err = errors.New("upload aborted")
+	}
+	return w.client.abortMultipart(w.bucket, w.key, w.uploadID)
+}
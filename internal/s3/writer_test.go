@@ -0,0 +1,234 @@
+package s3
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// store: local stand-in of an S3-compatible store, path-style, of multipart uploads
+type store struct {
+	mu        sync.Mutex
+	parts     map[int][]byte
+	objects   map[string][]byte
+	aborted   int
+	failPart  int  // part number failed with 500 [0: none]
+	failEarly bool // completion fails with an error body after 200
+	t         *testing.T
+}
+
+func (s *store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") || !strings.Contains(auth, "/us-east-1/s3/aws4_request") {
+		s.t.Errorf("authorization: %s", auth)
+	}
+	payloadHash := EmptyPayloadHash
+	if len(body) > 0 {
+		payloadHash = hashHex(body)
+	}
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != payloadHash {
+		s.t.Errorf("payload hash: got %s, want %s", got, payloadHash)
+	}
+
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.parts = make(map[int][]byte)
+		fmt.Fprint(w, `<InitiateMultipartUploadResult><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPut && query.Get("uploadId") == "upload-1":
+		var number int
+		fmt.Sscan(query.Get("partNumber"), &number)
+		if number == s.failPart {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `<Error><Code>InternalError</Code><Message>try again</Message></Error>`)
+			return
+		}
+		s.parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d"`, number))
+	case r.Method == http.MethodPost && query.Get("uploadId") == "upload-1":
+		if s.failEarly {
+			fmt.Fprint(w, `<Error><Code>InvalidPart</Code><Message>missing part</Message></Error>`)
+			return
+		}
+		var complete completeUpload
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			s.t.Error(err)
+		}
+		var object []byte
+		for i, part := range complete.Parts {
+			if part.PartNumber != i+1 || part.ETag != fmt.Sprintf(`"etag-%d"`, i+1) {
+				s.t.Errorf("part %d: %+v", i+1, part)
+			}
+			object = append(object, s.parts[part.PartNumber]...)
+		}
+		s.objects[r.URL.EscapedPath()] = object
+	case r.Method == http.MethodDelete && query.Get("uploadId") == "upload-1":
+		s.aborted++
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s.t.Errorf("unexpected request: %s %s", r.Method, r.URL)
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func newStore(t *testing.T) (*store, Client) {
+	t.Helper()
+
+	s := &store{objects: make(map[string][]byte), t: t}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+
+	return s, Client{
+		Endpoint:    server.URL,
+		Region:      DefaultRegion,
+		Credentials: Credentials{AccessKey: "key", SecretKey: "secret"},
+	}
+}
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name  string
+		size  int
+		parts int
+	}{
+		{name: "empty object", size: 0, parts: 1},
+		{name: "single part", size: 1000, parts: 1},
+		{name: "full part", size: PartSize, parts: 1},
+		{name: "multiple parts", size: PartSize + 1000, parts: 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, client := newStore(t)
+
+			w, err := NewWriter(client, "bucket", "runs/2024 01/x.tar.gz")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data := bytes.Repeat([]byte("traceneck"), test.size/9+1)[:test.size]
+			// uneven writes straddle part boundaries
+			for rest := data; len(rest) > 0; {
+				n := min(len(rest), 777777)
+				if _, err := w.Write(rest[:n]); err != nil {
+					t.Fatal(err)
+				}
+				rest = rest[n:]
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(s.parts) != test.parts {
+				t.Errorf("parts: got %d, want %d", len(s.parts), test.parts)
+			}
+			object, ok := s.objects["/bucket/runs/2024%2001/x.tar.gz"]
+			if !ok {
+				t.Fatalf("object not stored: %v", s.objects)
+			}
+			if !bytes.Equal(object, data) {
+				t.Errorf("object: got %d bytes, want %d", len(object), len(data))
+			}
+			if s.aborted != 0 {
+				t.Errorf("aborted %d times", s.aborted)
+			}
+		})
+	}
+}
+
+func TestWriterAborts(t *testing.T) {
+	t.Run("failed part", func(t *testing.T) {
+		s, client := newStore(t)
+		s.failPart = 2
+
+		w, err := NewWriter(client, "bucket", "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(make([]byte, PartSize+1)); err != nil {
+			t.Fatal(err)
+		}
+
+		err = w.Close()
+		var storeErr Error
+		if !errors.As(err, &storeErr) || storeErr.Code != "InternalError" {
+			t.Errorf("got %v, want InternalError", err)
+		}
+		if s.aborted != 1 {
+			t.Errorf("aborted %d times, want 1", s.aborted)
+		}
+		if _, err := w.Write([]byte("x")); err == nil {
+			t.Error("write after failure succeeded")
+		}
+	})
+
+	t.Run("error body after 200", func(t *testing.T) {
+		s, client := newStore(t)
+		s.failEarly = true
+
+		w, err := NewWriter(client, "bucket", "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = w.Close()
+		var storeErr Error
+		if !errors.As(err, &storeErr) || storeErr.Code != "InvalidPart" {
+			t.Errorf("got %v, want InvalidPart", err)
+		}
+		if s.aborted != 1 {
+			t.Errorf("aborted %d times, want 1", s.aborted)
+		}
+	})
+}
+
+func TestObjectURL(t *testing.T) {
+	tests := []struct {
+		client Client
+		want   string
+	}{
+		{Client{Region: "eu-west-1"}, "https://bucket.s3.eu-west-1.amazonaws.com/runs/a%20b.json"},
+		{Client{Endpoint: "http://minio:9000"}, "http://minio:9000/bucket/runs/a%20b.json"},
+		{Client{Endpoint: "http://minio:9000/base/"}, "http://minio:9000/base/bucket/runs/a%20b.json"},
+	}
+
+	for _, test := range tests {
+		u, err := test.client.objectURL("bucket", "runs/a b.json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u.String() != test.want {
+			t.Errorf("got %s, want %s", u, test.want)
+		}
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url, bucket, key string
+		err              bool
+	}{
+		{url: "s3://bucket/runs/x.tar.gz", bucket: "bucket", key: "runs/x.tar.gz"},
+		{url: "s3://bucket", bucket: "bucket"},
+		{url: "s3:///key", err: true},
+		{url: "https://bucket/key", err: true},
+	}
+
+	for _, test := range tests {
+		bucket, key, err := ParseURL(test.url)
+		if (err != nil) != test.err || bucket != test.bucket || key != test.key {
+			t.Errorf("%s: got %q %q %v", test.url, bucket, key, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/pflag"
+
+	"github.com/internet-equity/traceneck/internal/meta"
+)
+
+// ValidateMain: run the validate command, returning the exit status
+func ValidateMain(name string, args []string) int {
+	flags := pflag.NewFlagSet("validate", pflag.ContinueOnError)
+
+	var quiet bool
+	flags.BoolVarP(&quiet, "quiet", "q", false, "Only report invalid files")
+	flags.SortFlags = false
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\nUsage: %s validate <metadata.json>...\n\nOptions:\n", name)
+		flags.PrintDefaults()
+	}
+
+	if status, ok := parse(flags, args); !ok {
+		return status
+	}
+
+	schema := Generate()
+	status := 0
+
+	for _, path := range flags.Args() {
+		data, err := readInput(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "[validate]", err)
+			status = 1
+			continue
+		}
+
+		errs, err := validateJSON(schema, data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[validate] %s: %v\n", path, err)
+			status = 1
+			continue
+		}
+
+		if len(errs) > 0 {
+			fmt.Printf("%s: invalid\n", path)
+			for _, err := range errs {
+				fmt.Println("  " + err.Error())
+			}
+			status = 1
+		} else if !quiet {
+			fmt.Printf("%s: valid\n", path)
+		}
+	}
+
+	return status
+}
+
+// validateJSON: violations of encoded metadata, or error where not of the current version
+func validateJSON(schema map[string]any, data []byte) ([]ValidationError, error) {
+	doc, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := Version(doc)
+	if err != nil {
+		return nil, err
+	}
+	if version != meta.SchemaVersion {
+		return nil, fmt.Errorf("schema_version %d, expected %d (upgrade with migrate)", version, meta.SchemaVersion)
+	}
+
+	return Validate(schema, any(doc)), nil
+}
+
+// MigrateMain: run the migrate command, returning the exit status
+func MigrateMain(name string, args []string) int {
+	flags := pflag.NewFlagSet("migrate", pflag.ContinueOnError)
+
+	var inPlace bool
+	flags.BoolVarP(&inPlace, "in-place", "i", false, "Rewrite files in place [default: write to stdout]")
+	flags.SortFlags = false
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\nUsage: %s migrate [-i] <metadata.json>...\n\nOptions:\n", name)
+		flags.PrintDefaults()
+	}
+
+	if status, ok := parse(flags, args); !ok {
+		return status
+	}
+
+	if !inPlace && flags.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "[migrate] multiple files require --in-place")
+		return 1
+	}
+
+	status := 0
+
+	for _, path := range flags.Args() {
+		data, err := readInput(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "[migrate]", err)
+			status = 1
+			continue
+		}
+
+		migrated, version, err := MigrateJSON(data)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[migrate] %s: %v\n", path, err)
+			status = 1
+			continue
+		}
+
+		if !inPlace {
+			os.Stdout.Write(migrated)
+			continue
+		}
+
+		if version == meta.SchemaVersion {
+			fmt.Fprintf(os.Stderr, "[migrate] %s: already version %d\n", path, version)
+			continue
+		}
+
+		if err := replaceFile(path, migrated); err != nil {
+			fmt.Fprintf(os.Stderr, "[migrate] %s: %v\n", path, err)
+			status = 1
+			continue
+		}
+
+		fmt.Fprintf(os.Stderr, "[migrate] %s: version %d to %d\n", path, version, meta.SchemaVersion)
+	}
+
+	return status
+}
+
+// SchemaMain: run the schema command, printing the json schema
+func SchemaMain(name string, args []string) int {
+	flags := pflag.NewFlagSet("schema", pflag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\nUsage: %s schema\n", name)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0
+		}
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(Generate()); err != nil {
+		fmt.Fprintln(os.Stderr, "[schema]", err)
+		return 1
+	}
+
+	return 0
+}
+
+// parse: parse flags requiring file arguments, returning exit status where not ok
+func parse(flags *pflag.FlagSet, args []string) (int, bool) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return 0, false
+		}
+		return 1, false
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 1, false
+	}
+
+	return 0, true
+}
+
+// readInput: contents of file at path, or of stdin for "-"
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// replaceFile: atomically replace contents of file at path
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".migrate-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
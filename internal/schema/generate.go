@@ -0,0 +1,116 @@
+/*
+ * schema: json schema of metadata.json
+ *
+ * the schema is generated from the meta types (json and desc struct tags), such that it
+ * cannot drift from the output; the generated file is committed at schema/ and metadata
+ * files are validated against, and migrated to, the current schema version
+ *
+ */
+package schema
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+
+	"github.com/internet-equity/traceneck/internal/meta"
+)
+
+const (
+	draft = "https://json-schema.org/draft/2020-12/schema"
+	id    = "https://github.com/internet-equity/traceneck/schema/metadata.schema.json"
+)
+
+var ipType = reflect.TypeOf(net.IP{})
+
+type generator struct {
+	defs map[string]any
+}
+
+// Generate: json schema of the current metadata format
+func Generate() map[string]any {
+	g := &generator{defs: make(map[string]any)}
+
+	root := g.object(reflect.TypeOf(meta.Metadata{}))
+	root["$schema"] = draft
+	root["$id"] = id
+	root["title"] = "traceneck metadata"
+	root["description"] = fmt.Sprintf("traceneck metadata.json, schema version %d", meta.SchemaVersion)
+	root["$defs"] = g.defs
+
+	version := root["properties"].(map[string]any)["schema_version"].(map[string]any)
+	version["const"] = meta.SchemaVersion
+
+	return root
+}
+
+// schemaOf: schema of values of type t, named structs by reference
+func (g *generator) schemaOf(t reflect.Type) map[string]any {
+	if t == ipType {
+		return map[string]any{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schemaOf(t.Elem())
+	case reflect.Struct:
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // guard recursion
+			g.defs[t.Name()] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	case reflect.Slice:
+		// nil slices encode as null
+		return map[string]any{"type": []any{"array", "null"}, "items": g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": []any{"object", "null"}, "additionalProperties": g.schemaOf(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		panic("schema: unsupported type: " + t.String())
+	}
+}
+
+// object: closed object schema of struct fields, those not omitted when empty required
+func (g *generator) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	required := []any{}
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := g.schemaOf(field.Type)
+		if desc := field.Tag.Get("desc"); desc != "" {
+			property["description"] = desc
+		}
+		properties[name] = property
+
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	return map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
@@ -0,0 +1,167 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/internet-equity/traceneck/internal/meta"
+)
+
+// migrations: upgrade of a document from version i+1 to version i+2 [nil: additive change]
+var migrations = []func(doc map[string]any){
+	migrateV1,
+	nil, // analysis
+	nil, // aqm fingerprint
+	nil, // latency flow
+	nil, // queuing delay
+	nil, // hop probes, hop responses
+	nil, // reply and quoted headers, path
+	nil, // icmp extensions
+	nil, // timestamp source
+	nil, // probe schedule
+	nil, // ping socket, capture
+	nil, // server ip
+	migrateV13,
+}
+
+// Version: schema version of a decoded metadata document [1: unversioned]
+func Version(doc map[string]any) (int, error) {
+	value, ok := doc["schema_version"]
+	if !ok {
+		return 1, nil
+	}
+
+	version, ok := value.(float64)
+	if !ok || version < 1 || version != float64(int(version)) {
+		return 0, fmt.Errorf("invalid schema_version: %v", value)
+	}
+
+	return int(version), nil
+}
+
+// Migrate: upgrade a decoded metadata document in place to the current schema version,
+// returning its original version
+func Migrate(doc map[string]any) (int, error) {
+	version, err := Version(doc)
+	if err != nil {
+		return 0, err
+	}
+	if version > meta.SchemaVersion {
+		return version, fmt.Errorf("schema_version %d newer than supported version %d", version, meta.SchemaVersion)
+	}
+
+	for v := version; v < meta.SchemaVersion; v++ {
+		if migrate := migrations[v-1]; migrate != nil {
+			migrate(doc)
+		}
+	}
+	doc["schema_version"] = meta.SchemaVersion
+
+	return version, nil
+}
+
+// MigrateJSON: encoded metadata upgraded to the current schema version, returned as is
+// where already current
+func MigrateJSON(data []byte) ([]byte, int, error) {
+	doc, err := decode(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	version, err := Migrate(doc)
+	if err != nil || version == meta.SchemaVersion {
+		return data, version, err
+	}
+
+	// encoded as written by traceneck
+	migrated, err := json.Marshal(doc)
+	if err != nil {
+		return nil, version, err
+	}
+
+	return append(migrated, '\n'), version, nil
+}
+
+// migrateV1: distinct ooklahttp keys and run id, and defaults of keys required since the
+// baseline format, which recorded neither direction nor phases, and fewer iperf results
+func migrateV1(doc map[string]any) {
+	if measurements, ok := doc["Measurements"].(map[string]any); ok {
+		if ooklaHttp, ok := measurements["ooklahttp"].(map[string]any); ok {
+			for key, value := range ooklaHttp {
+				if suffix, ok := strings.CutPrefix(key, "speedtest_ookla_"); ok {
+					delete(ooklaHttp, key)
+					ooklaHttp["speedtest_ooklahttp_"+suffix] = value
+				}
+			}
+		}
+	}
+
+	if m, ok := doc["Meta"].(map[string]any); ok {
+		if id, _ := m["Id"].(string); id == "" {
+			// version 1 never set the run id: derive it as does export
+			t, _ := m["Time"].(float64)
+			iface, _ := m["Interface"].(string)
+			m["Id"] = meta.DerivedRunID(t, iface)
+		}
+
+		// both directions tested, of no recorded phase timeline
+		setDefault(m, "Direction", "both")
+		setDefault(m, "Phases", []any{})
+	}
+
+	if measurements, ok := doc["Measurements"].(map[string]any); ok {
+		if iperf, ok := measurements["iperf"].(map[string]any); ok {
+			// a single tcp stream of the iperf3 defaults, of unrecorded retransmits and server
+			setDefault(iperf, "speedtest_iperf_downloadretrans", -1)
+			setDefault(iperf, "speedtest_iperf_uploadretrans", -1)
+			setDefault(iperf, "speedtest_iperf_protocol", "tcp")
+			setDefault(iperf, "speedtest_iperf_streams", 1)
+			setDefault(iperf, "speedtest_iperf_server_ip", "")
+		}
+	}
+}
+
+// setDefault: set key of a document to value where absent
+func setDefault(doc map[string]any, key string, value any) {
+	if _, ok := doc[key]; !ok {
+		doc[key] = value
+	}
+}
+
+// migrateV13: snake_case top-level and meta keys
+func migrateV13(doc map[string]any) {
+	lowerKeys(doc, "Measurements", "Meta", "Analysis")
+
+	if m, ok := doc["meta"].(map[string]any); ok {
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		lowerKeys(m, keys...)
+	}
+}
+
+// lowerKeys: rename the given keys of a document to lower case
+func lowerKeys(doc map[string]any, keys ...string) {
+	for _, key := range keys {
+		if value, ok := doc[key]; ok {
+			delete(doc, key)
+			doc[strings.ToLower(key)] = value
+		}
+	}
+}
+
+// decode: json object document
+func decode(data []byte) (map[string]any, error) {
+	var doc map[string]any
+
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("not a json object")
+	}
+
+	return doc, nil
+}
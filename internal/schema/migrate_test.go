@@ -0,0 +1,75 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/internet-equity/traceneck/internal/meta"
+)
+
+func TestMigrate(t *testing.T) {
+	// unversioned files, as written by the baseline format
+	meta1 := `"Meta": {"Id": "", "Time": 1700000000, "Tool_start_time": 1700000000, "Tool_end_time": 1700000030,
+		"Speedtest_start_time": 0, "Speedtest_end_time": 0, "Ping_start_time": 0, "Ping_end_time": 0,
+		"Interface": "eth0", "Interface_ip": ["192.0.2.2"]}`
+	samples := `"rtt_samples": [{"ttl": 1, "round": 1, "reply_ip": "192.0.2.1", "send_time": 1700000001, "recv_time": 1700000001.01, "rtt": 10, "icmp_seq_no": 32}],
+		"test_bytes_consumed": 1000`
+
+	tests := []struct {
+		tool         string
+		measurements string
+	}{
+		{"ndt7", `"ndt7": {"speedtest_ndt7_download": 100, "speedtest_ndt7_downloadlatency": 10, "speedtest_ndt7_downloadretrans": 0.01,
+			"speedtest_ndt7_server": "ndt.example", "speedtest_ndt7_server_ip": "192.0.2.10", "speedtest_ndt7_upload": 10}`},
+		{"ookla", `"ookla": {"speedtest_ookla_download": 100, "speedtest_ookla_jitter": 1, "speedtest_ookla_latency": 10,
+			"speedtest_ookla_pktloss2": 0, "speedtest_ookla_server_host": "ookla.example:8080", "speedtest_ookla_server_id": 1,
+			"speedtest_ookla_server_name": "x", "speedtest_ookla_upload": 10}`},
+		{"ooklahttp", `"ooklahttp": {"speedtest_ookla_download": 100, "speedtest_ookla_latency": 10,
+			"speedtest_ookla_server_host": "ookla.example:8080", "speedtest_ookla_server_id": "1",
+			"speedtest_ookla_server_name": "x", "speedtest_ookla_upload": 10}`},
+		{"iperf", `"iperf": {"speedtest_iperf_download": 1e8, "speedtest_iperf_upload": 1e7}`},
+	}
+
+	schema := Generate()
+	for _, test := range tests {
+		data := []byte(`{"Measurements": {` + test.measurements + `, ` + samples + `}, ` + meta1 + `}`)
+
+		migrated, version, err := MigrateJSON(data)
+		if err != nil {
+			t.Fatal(test.tool, err)
+		}
+		if version != 1 {
+			t.Errorf("%s: version: got %d, want 1", test.tool, version)
+		}
+
+		errs, err := validateJSON(schema, migrated)
+		if err != nil {
+			t.Fatal(test.tool, err)
+		}
+		for _, err := range errs {
+			t.Errorf("%s: %v", test.tool, err)
+		}
+
+		var metadata meta.Metadata
+		if err := json.Unmarshal(migrated, &metadata); err != nil {
+			t.Fatal(test.tool, err)
+		}
+		if m := metadata.Meta; m.ID == "" || m.Time != 1700000000 || m.ToolStartTime != 1700000000 || m.Interface != "eth0" || m.Direction != "both" {
+			t.Errorf("%s: meta: got %+v", test.tool, m)
+		}
+		// uuid of version 5, as derived of run time and interface
+		if id := metadata.Meta.ID; id != meta.DerivedRunID(1700000000, "eth0") || len(id) != 36 || id[14] != '5' {
+			t.Errorf("%s: id: got %q", test.tool, id)
+		}
+		if h := metadata.Measurements.OoklaHttp; test.tool == "ooklahttp" && (h == nil || h.Download != 100) {
+			t.Errorf("ooklahttp: got %+v", h)
+		}
+		if p := metadata.Measurements.Iperf; test.tool == "iperf" && (p == nil || p.Protocol != "tcp" || p.Streams != 1 || p.DownloadRetrans != -1) {
+			t.Errorf("iperf: got %+v", p)
+		}
+	}
+
+	if _, err := Migrate(map[string]any{"schema_version": float64(meta.SchemaVersion + 1)}); err == nil {
+		t.Error("newer version: want error")
+	}
+}
@@ -0,0 +1,206 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// ValidationError: document location and violation
+type ValidationError struct {
+	Path    string // json pointer
+	Message string
+}
+
+func (err ValidationError) Error() string {
+	path := err.Path
+	if path == "" {
+		path = "/"
+	}
+	return path + ": " + err.Message
+}
+
+// Validate: check a decoded json document against a schema
+//
+// supports the keywords of generated schemas: $ref (local), type, const, minimum,
+// properties, required, additionalProperties and items.
+func Validate(schema map[string]any, doc any) []ValidationError {
+	v := validator{root: schema}
+	v.validate(schema, doc, "")
+	return v.errs
+}
+
+type validator struct {
+	root map[string]any
+	errs []ValidationError
+}
+
+func (v *validator) fail(path, format string, args ...any) {
+	v.errs = append(v.errs, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(schema map[string]any, doc any, path string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		resolved, err := v.resolve(ref)
+		if err != nil {
+			v.fail(path, "%v", err)
+			return
+		}
+		v.validate(resolved, doc, path)
+	}
+
+	if types, ok := schema["type"]; ok && !matchesType(types, doc) {
+		v.fail(path, "expected %v, found %s", types, typeOf(doc))
+		return
+	}
+
+	if expected, ok := schema["const"]; ok && !equalNumber(expected, doc) {
+		v.fail(path, "expected %v, found %v", expected, doc)
+	}
+
+	if minimum, ok := schema["minimum"]; ok {
+		if n, ok := doc.(float64); ok && n < toFloat(minimum) {
+			v.fail(path, "%v below minimum %v", n, minimum)
+		}
+	}
+
+	switch value := doc.(type) {
+	case map[string]any:
+		v.validateObject(schema, value, path)
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				v.validate(items, item, fmt.Sprintf("%s/%d", path, i))
+			}
+		}
+	}
+}
+
+func (v *validator) validateObject(schema map[string]any, obj map[string]any, path string) {
+	properties, _ := schema["properties"].(map[string]any)
+
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				v.fail(path, "missing required property %q", name)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		childPath := path + "/" + escapePointer(name)
+
+		if property, ok := properties[name].(map[string]any); ok {
+			v.validate(property, obj[name], childPath)
+			continue
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.fail(childPath, "unexpected property")
+			}
+		case map[string]any:
+			v.validate(additional, obj[name], childPath)
+		}
+	}
+}
+
+func (v *validator) resolve(ref string) (map[string]any, error) {
+	name, ok := strings.CutPrefix(ref, "#/$defs/")
+	if !ok {
+		return nil, fmt.Errorf("unsupported reference: %s", ref)
+	}
+
+	defs, _ := v.root["$defs"].(map[string]any)
+	def, ok := defs[name].(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("unresolved reference: %s", ref)
+	}
+
+	return def, nil
+}
+
+func matchesType(types any, doc any) bool {
+	switch t := types.(type) {
+	case string:
+		return matchesOne(t, doc)
+	case []any:
+		for _, one := range t {
+			if name, ok := one.(string); ok && matchesOne(name, doc) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesOne(name string, doc any) bool {
+	switch name {
+	case "null":
+		return doc == nil
+	case "boolean":
+		_, ok := doc.(bool)
+		return ok
+	case "string":
+		_, ok := doc.(string)
+		return ok
+	case "number":
+		_, ok := doc.(float64)
+		return ok
+	case "integer":
+		n, ok := doc.(float64)
+		return ok && n == math.Trunc(n)
+	case "array":
+		_, ok := doc.([]any)
+		return ok
+	case "object":
+		_, ok := doc.(map[string]any)
+		return ok
+	}
+	return false
+}
+
+func typeOf(doc any) string {
+	switch doc.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", doc)
+}
+
+func equalNumber(expected, doc any) bool {
+	n, ok := doc.(float64)
+	return ok && n == toFloat(expected)
+}
+
+func toFloat(n any) float64 {
+	switch v := n.(type) {
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return math.NaN()
+}
+
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
@@ -0,0 +1,57 @@
+/*
+ * telemetry: push run samples to timeseries stores
+ *
+ * rtt and throughput samples are written to an influxdb write endpoint as line protocol
+ * and/or exported to an opentelemetry collector as otlp metrics
+ *
+ */
+package telemetry
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/internet-equity/traceneck/internal/config"
+	"github.com/internet-equity/traceneck/internal/influx"
+	"github.com/internet-equity/traceneck/internal/meta"
+	"github.com/internet-equity/traceneck/internal/otlp"
+)
+
+// Process: push this run's samples to the configured endpoints
+func Process() {
+	samples := meta.SortedSamples()
+
+	if config.InfluxURL != "" {
+		points := meta.InfluxPoints(meta.MetaD, samples)
+		client := influx.Client{URL: config.InfluxURL, Token: config.InfluxToken}
+
+		if err := client.Write(points); err != nil {
+			log.Println("[telemetry] error writing to influxdb:", err)
+		} else {
+			log.Printf("[telemetry] %d point(s) written to: %s", len(points), config.InfluxURL)
+		}
+	}
+
+	if config.OtlpURL != "" {
+		client := otlp.Client{URL: config.OtlpURL, Header: otlpHeader()}
+
+		if err := client.Export(meta.OtlpRequest(meta.MetaD, samples, config.VERSION)); err != nil {
+			log.Println("[telemetry] error exporting otlp metrics:", err)
+		} else {
+			log.Println("[telemetry] metrics exported to:", config.OtlpURL)
+		}
+	}
+}
+
+func otlpHeader() http.Header {
+	h := make(http.Header)
+
+	// validated by config
+	for _, field := range config.OtlpHeaders {
+		key, value, _ := strings.Cut(field, ":")
+		h.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+
+	return h
+}
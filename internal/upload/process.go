@@ -0,0 +1,111 @@
+package upload
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/internet-equity/traceneck/internal/archive"
+	"github.com/internet-equity/traceneck/internal/config"
+	"github.com/internet-equity/traceneck/internal/meta"
+	timeUtil "github.com/internet-equity/traceneck/internal/util/time"
+)
+
+const initialBackoff = 2 * time.Second
+
+// Process: spool this run's outputs and flush the spool to the collector
+func Process() {
+	uploader := newUploader()
+	name := runName()
+
+	var err error
+	if config.UploadMetadata {
+		_, err = uploader.Spool(meta.MetaFile, name+"-metadata.json")
+	} else if config.ShouldArchive() && config.OutPath != "-" && !config.S3Output() {
+		_, err = uploader.Spool(config.OutPath, name+archiveExt(config.OutPath))
+	} else {
+		// no archive on disk: write one into the spool
+		err = spoolArchive(name + ".tar.gz")
+	}
+	if err != nil {
+		log.Println("[upload] error spooling outputs:", err)
+	}
+
+	flush(uploader)
+}
+
+// ProcessDualStack: spool the dual-stack comparison at path and flush the spool to the
+// collector, the runs of either ip version having spooled their own outputs
+func ProcessDualStack(path string) {
+	uploader := newUploader()
+
+	if _, err := uploader.Spool(path, runName()+"-"+filepath.Base(path)); err != nil {
+		log.Println("[upload] error spooling outputs:", err)
+	}
+
+	flush(uploader)
+}
+
+func newUploader() Uploader {
+	return Uploader{
+		URL:      config.UploadURL,
+		Header:   header(),
+		SpoolDir: config.SpoolDir,
+		Retries:  config.UploadRetries,
+		Backoff:  initialBackoff,
+	}
+}
+
+// runName: spooled name of this run's outputs, less extension
+func runName() string {
+	return config.Timestamp.UTC().Format(timeUtil.RunIDFormat) + "-" + config.Interface
+}
+
+func flush(uploader Uploader) {
+	delivered, err := uploader.Flush()
+	if err != nil {
+		log.Println("[upload] error uploading, left in spool:", err)
+	}
+	log.Printf("[upload] %d file(s) delivered to: %s", delivered, config.UploadURL)
+}
+
+// spoolArchive: write the archive under a hidden name and rename it into place
+func spoolArchive(name string) error {
+	tmp := filepath.Join(config.SpoolDir, ".spool-"+name)
+	defer os.Remove(tmp)
+
+	if err := archive.WriteFile(tmp); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(config.SpoolDir, name))
+}
+
+func header() http.Header {
+	h := make(http.Header)
+
+	if config.UploadToken != "" {
+		h.Set("Authorization", "Bearer "+config.UploadToken)
+	}
+
+	// validated by config
+	for _, field := range config.UploadHeaders {
+		key, value, _ := strings.Cut(field, ":")
+		h.Add(strings.TrimSpace(key), strings.TrimSpace(value))
+	}
+
+	return h
+}
+
+func archiveExt(path string) string {
+	if strings.HasSuffix(path, ".tar.gz") {
+		return ".tar.gz"
+	}
+	if ext := filepath.Ext(path); ext != "" {
+		return ext
+	}
+	return ".tar"
+}
@@ -0,0 +1,229 @@
+/*
+ * upload: deliver run outputs to an http collector
+ *
+ * outputs are first copied into a local spool directory; the spool is then flushed oldest
+ * first, each file being deleted only once the collector acknowledges it (2xx), such that
+ * outputs of offline periods are delivered by later runs
+ *
+ */
+package upload
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	RejectedDir = "rejected" // spool subdirectory of files refused by the collector
+
+	requestTimeout = 5 * time.Minute
+	maxBackoff     = time.Minute
+)
+
+// Uploader: spool and collector configuration
+type Uploader struct {
+	URL      string        // collector endpoint
+	Header   http.Header   // additional request headers (e.g. Authorization)
+	SpoolDir string        // local spool directory
+	Retries  int           // retries per file on transient failure
+	Backoff  time.Duration // initial delay between retries, doubled per retry
+	HTTP     *http.Client
+}
+
+// RejectedError: permanent refusal of a file by the collector
+type RejectedError struct {
+	Status string
+}
+
+func (err RejectedError) Error() string {
+	return "rejected by collector: " + err.Status
+}
+
+// Spool: copy src into the spool directory as name
+//
+// the copy is written under a hidden temporary name and renamed into place, such that
+// concurrent flushes never upload partial files.
+func (u Uploader) Spool(src, name string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	tmp, err := os.CreateTemp(u.SpoolDir, ".spool-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(u.SpoolDir, name)
+	return path, os.Rename(tmp.Name(), path)
+}
+
+// Pending: spooled files awaiting upload, oldest first
+func (u Uploader) Pending() ([]string, error) {
+	entries, err := os.ReadDir(u.SpoolDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.HasPrefix(entry.Name(), ".") {
+			files = append(files, entry.Name())
+		}
+	}
+
+	// names lead with the run timestamp
+	slices.Sort(files)
+
+	return files, nil
+}
+
+// Flush: upload pending files, returning the number delivered
+//
+// files refused by the collector are moved aside to the rejected subdirectory; flushing
+// stops at the first file failing transiently, leaving it and its successors spooled.
+func (u Uploader) Flush() (int, error) {
+	files, err := u.Pending()
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+
+	for _, name := range files {
+		path := filepath.Join(u.SpoolDir, name)
+
+		err := u.sendRetrying(path)
+
+		var rejected RejectedError
+		if errors.As(err, &rejected) {
+			log.Printf("[upload] %s: %v", name, err)
+			if err := u.reject(name); err != nil {
+				return delivered, err
+			}
+			continue
+		}
+		if err != nil {
+			return delivered, fmt.Errorf("%s: %w", name, err)
+		}
+
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return delivered, err
+		}
+		delivered++
+		log.Println("[upload] delivered:", name)
+	}
+
+	return delivered, nil
+}
+
+func (u Uploader) sendRetrying(path string) error {
+	backoff := u.Backoff
+
+	for attempt := 0; ; attempt++ {
+		err := u.send(path)
+
+		var rejected RejectedError
+		if err == nil || errors.As(err, &rejected) || errors.Is(err, os.ErrNotExist) || attempt >= u.Retries {
+			return err
+		}
+
+		log.Printf("[upload] %s: %v: retrying in %s", filepath.Base(path), err, backoff)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+// send: POST a single file
+func (u Uploader) send(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u.URL, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = info.Size()
+
+	for key, values := range u.Header {
+		req.Header[key] = values
+	}
+
+	name := filepath.Base(path)
+	req.Header.Set("Content-Type", contentType(name))
+	req.Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	// retried deliveries carry the same key, such that the collector may deduplicate
+	req.Header.Set("Idempotency-Key", name)
+
+	httpClient := u.HTTP
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: requestTimeout}
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch code := resp.StatusCode; {
+	case code >= 200 && code < 300:
+		return nil
+	case code == http.StatusUnauthorized, code == http.StatusForbidden,
+		code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		// credentials may be fixed and limits lifted: keep spooled
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	case code >= 400 && code < 500:
+		return RejectedError{Status: resp.Status}
+	default:
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+}
+
+func (u Uploader) reject(name string) error {
+	dir := filepath.Join(u.SpoolDir, RejectedDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(u.SpoolDir, name), filepath.Join(dir, name))
+}
+
+func contentType(name string) string {
+	switch {
+	case strings.HasSuffix(name, ".json"):
+		return "application/json"
+	case strings.HasSuffix(name, ".gz"), strings.HasSuffix(name, ".tgz"):
+		return "application/gzip"
+	case strings.HasSuffix(name, ".tar"):
+		return "application/x-tar"
+	default:
+		return "application/octet-stream"
+	}
+}
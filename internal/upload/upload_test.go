@@ -0,0 +1,208 @@
+package upload
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// collector: local stand-in of a collector replying status per file name
+type collector struct {
+	mu       sync.Mutex
+	status   map[string][]int // replies per file, the last repeated
+	received []string
+	bodies   map[string]string
+	headers  http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	name := r.Header.Get("Idempotency-Key")
+	body, _ := io.ReadAll(r.Body)
+
+	c.received = append(c.received, name)
+	c.bodies[name] = string(body)
+	c.headers = r.Header.Clone()
+
+	status := http.StatusOK
+	if replies := c.status[name]; len(replies) > 0 {
+		status = replies[0]
+		if len(replies) > 1 {
+			c.status[name] = replies[1:]
+		}
+	}
+	w.WriteHeader(status)
+}
+
+func newCollector(t *testing.T, status map[string][]int) (*collector, *httptest.Server) {
+	t.Helper()
+
+	c := &collector{status: status, bodies: make(map[string]string)}
+	server := httptest.NewServer(c)
+	t.Cleanup(server.Close)
+
+	return c, server
+}
+
+func spool(t *testing.T, u Uploader, files map[string]string) {
+	t.Helper()
+
+	for name, body := range files {
+		src := filepath.Join(t.TempDir(), "src")
+		if err := os.WriteFile(src, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := u.Spool(src, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFlush(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    map[string][]int
+		retries   int
+		delivered int
+		err       bool
+		received  []string
+		pending   []string
+		rejected  []string
+	}{
+		{
+			name:      "delivers oldest first",
+			delivered: 3,
+			received:  []string{"20240101T000000Z-a.json", "20240101T010000Z-a.json", "20240101T020000Z-a.tar.gz"},
+		},
+		{
+			name:      "retries transient failures",
+			status:    map[string][]int{"20240101T010000Z-a.json": {http.StatusServiceUnavailable, http.StatusOK}},
+			retries:   1,
+			delivered: 3,
+			received:  []string{"20240101T000000Z-a.json", "20240101T010000Z-a.json", "20240101T010000Z-a.json", "20240101T020000Z-a.tar.gz"},
+		},
+		{
+			name:      "stops at a transient failure",
+			status:    map[string][]int{"20240101T010000Z-a.json": {http.StatusBadGateway}},
+			retries:   1,
+			delivered: 1,
+			err:       true,
+			received:  []string{"20240101T000000Z-a.json", "20240101T010000Z-a.json", "20240101T010000Z-a.json"},
+			pending:   []string{"20240101T010000Z-a.json", "20240101T020000Z-a.tar.gz"},
+		},
+		{
+			name:      "keeps files of refused credentials",
+			status:    map[string][]int{"20240101T000000Z-a.json": {http.StatusUnauthorized}},
+			delivered: 0,
+			err:       true,
+			received:  []string{"20240101T000000Z-a.json"},
+			pending:   []string{"20240101T000000Z-a.json", "20240101T010000Z-a.json", "20240101T020000Z-a.tar.gz"},
+		},
+		{
+			name:      "moves rejected files aside",
+			status:    map[string][]int{"20240101T010000Z-a.json": {http.StatusUnprocessableEntity}},
+			retries:   3,
+			delivered: 2,
+			received:  []string{"20240101T000000Z-a.json", "20240101T010000Z-a.json", "20240101T020000Z-a.tar.gz"},
+			rejected:  []string{"20240101T010000Z-a.json"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, server := newCollector(t, test.status)
+
+			u := Uploader{
+				URL:      server.URL,
+				Header:   http.Header{"Authorization": {"Bearer token"}},
+				SpoolDir: t.TempDir(),
+				Retries:  test.retries,
+			}
+			spool(t, u, map[string]string{
+				"20240101T020000Z-a.tar.gz": "archive",
+				"20240101T000000Z-a.json":   "first",
+				"20240101T010000Z-a.json":   "second",
+			})
+
+			delivered, err := u.Flush()
+			if (err != nil) != test.err {
+				t.Fatalf("error: got %v, want error %v", err, test.err)
+			}
+			if delivered != test.delivered {
+				t.Errorf("delivered: got %d, want %d", delivered, test.delivered)
+			}
+			if !slices.Equal(c.received, test.received) {
+				t.Errorf("received: got %v, want %v", c.received, test.received)
+			}
+
+			pending, err := u.Pending()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(pending, test.pending) {
+				t.Errorf("pending: got %v, want %v", pending, test.pending)
+			}
+
+			rejected, _ := Uploader{SpoolDir: filepath.Join(u.SpoolDir, RejectedDir)}.Pending()
+			if !slices.Equal(rejected, test.rejected) {
+				t.Errorf("rejected: got %v, want %v", rejected, test.rejected)
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	c, server := newCollector(t, nil)
+
+	u := Uploader{
+		URL:      server.URL,
+		Header:   http.Header{"Authorization": {"Bearer token"}},
+		SpoolDir: t.TempDir(),
+	}
+	spool(t, u, map[string]string{"20240101T000000Z-a.tar.gz": "archive"})
+
+	if _, err := u.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]string{
+		"Authorization":       "Bearer token",
+		"Content-Type":        "application/gzip",
+		"Content-Disposition": `attachment; filename="20240101T000000Z-a.tar.gz"`,
+		"Content-Length":      "7",
+	} {
+		if got := c.headers.Get(key); got != want {
+			t.Errorf("%s: got %q, want %q", key, got, want)
+		}
+	}
+	if body := c.bodies["20240101T000000Z-a.tar.gz"]; body != "archive" {
+		t.Errorf("body: got %q", body)
+	}
+}
+
+func TestPendingSkipsPartialFiles(t *testing.T) {
+	u := Uploader{SpoolDir: t.TempDir()}
+	spool(t, u, map[string]string{"20240101T000000Z-a.json": "{}"})
+
+	if err := os.WriteFile(filepath.Join(u.SpoolDir, ".spool-123"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(u.SpoolDir, RejectedDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := u.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"20240101T000000Z-a.json"}; !slices.Equal(pending, want) {
+		t.Errorf("got %v, want %v", pending, want)
+	}
+}
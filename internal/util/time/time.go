@@ -4,6 +4,9 @@ import (
 	"time"
 )
 
+// RunIDFormat: per-run output names, sortable by time
+const RunIDFormat = "20060102T150405Z"
+
 func UnixNow() float64 {
 	return UnixPrecise(time.Now())
 }
@@ -11,3 +14,7 @@ func UnixNow() float64 {
 func UnixPrecise(t time.Time) float64 {
 	return float64(t.UnixNano()) / 1e9
 }
+
+func FromUnix(t float64) time.Time {
+	return time.Unix(0, int64(t*1e9))
+}
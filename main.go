@@ -3,20 +3,58 @@ package main
 import (
 	"log"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/internet-equity/traceneck/internal/archive"
 	"github.com/internet-equity/traceneck/internal/channel"
 	"github.com/internet-equity/traceneck/internal/config"
+	"github.com/internet-equity/traceneck/internal/daemon"
+	"github.com/internet-equity/traceneck/internal/doctor"
+	"github.com/internet-equity/traceneck/internal/dualstack"
+	"github.com/internet-equity/traceneck/internal/evaluate"
+	"github.com/internet-equity/traceneck/internal/export"
+	"github.com/internet-equity/traceneck/internal/flow"
 	"github.com/internet-equity/traceneck/internal/meta"
+	"github.com/internet-equity/traceneck/internal/metrics"
 	"github.com/internet-equity/traceneck/internal/network"
 	"github.com/internet-equity/traceneck/internal/ping"
+	"github.com/internet-equity/traceneck/internal/schema"
+	"github.com/internet-equity/traceneck/internal/telemetry"
+	"github.com/internet-equity/traceneck/internal/upload"
 )
 
 // flog: dedicated logger for failures -- which won't disable in quiet mode
 var flog = log.New(os.Stderr, "", log.LstdFlags)
 
 func main() {
+	// Run subcommand
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export":
+			// Convert outputs to parquet dataset
+			os.Exit(export.Main(config.NAME, os.Args[2:]))
+		case "validate":
+			// Check metadata against json schema
+			os.Exit(schema.ValidateMain(config.NAME, os.Args[2:]))
+		case "migrate":
+			// Upgrade metadata to current schema version
+			os.Exit(schema.MigrateMain(config.NAME, os.Args[2:]))
+		case "evaluate":
+			// Validate aqm fingerprints against labelled runs
+			os.Exit(evaluate.Main(config.NAME, os.Args[2:]))
+		case "reflect":
+			// Reflect latency flow test packets
+			os.Exit(flow.ReflectMain(config.NAME, os.Args[2:]))
+		case "schema":
+			// Print json schema
+			os.Exit(schema.SchemaMain(config.NAME, os.Args[2:]))
+		case "doctor":
+			// Report privileges, sysctls, tools and interface state
+			os.Exit(doctor.Main(config.NAME, os.Args[2:]))
+		}
+	}
+
 	// Define args
 	config.Define()
 
@@ -37,20 +75,112 @@ func main() {
 		}
 	}
 
+	// Schedule runs until interrupted
+	if config.Daemon {
+		opts := daemonOptions()
+
+		// Serve metrics of daemon and runs
+		if config.MetricsAddr != "" {
+			exporter := metrics.NewExporter(config.VERSION)
+			if err := exporter.Listen(config.MetricsAddr); err != nil {
+				flog.Fatalln("[metrics]", err)
+			}
+			opts.Observe = exporter.Observe
+		}
+
+		if err := daemon.Run(opts); err != nil {
+			flog.Fatalln("[daemon]", err)
+		}
+		return
+	}
+
+	// Hold run lock if requested
+	if config.LockFile != "" {
+		lock, err := daemon.TryLock(config.LockFile)
+		if err != nil {
+			flog.Fatalln("[lock]", err)
+		}
+		defer lock.Unlock()
+	}
+
+	// Run over either ip version and compare
+	if config.DualStack {
+		server, err := network.DiscoverHost()
+		if err != nil {
+			flog.Fatalln("[server] error discovering server:", err)
+		}
+
+		result, err := dualstack.Compare(dualstack.Options{
+			Args:   config.FamilyRunArgs(),
+			Env:    config.RunEnv(),
+			OutDir: config.OutPath,
+			Server: server,
+		})
+		if err != nil {
+			flog.Fatalln("[dual stack]", err)
+		}
+
+		if !config.Quiet {
+			result.WriteSummary(os.Stdout)
+		}
+
+		// Write metrics of both runs for textfile collector
+		if runs := result.Metadata(); config.MetricsFile != "" && len(runs) > 0 {
+			if err := metrics.WriteFileByVersion(config.MetricsFile, runs); err != nil {
+				log.Println("[metrics] error writing metrics file:", err)
+			} else {
+				log.Println("[metrics] metrics written to:", config.MetricsFile)
+			}
+		}
+
+		// Upload comparison
+		if config.UploadURL != "" {
+			upload.ProcessDualStack(filepath.Join(config.OutPath, dualstack.ResultFile))
+		}
+		return
+	}
+
 	// Init metadata
 	meta.Init()
 
+	// Select and resolve server up front, such that pings start before load
+	if !config.NoDiscover {
+		if err := network.DiscoverServer(); err != nil {
+			// the tool's own selection would ignore the ip version
+			if config.PreIdle > 0 || config.IPVersion != 0 {
+				flog.Fatalln("[server] error selecting server:", err)
+			}
+			log.Println("[server] error selecting server, tool will select:", err)
+		}
+	} else if config.PreIdle > 0 {
+		if err := network.ResolveServer(); err != nil {
+			flog.Fatalln("[server] error resolving server:", err)
+		}
+	}
+
 	// Start background packet capture
 	go network.CaptureProcess()
 
-	// Start speedtest client
-	go network.SpeedtestProcess()
-
 	// Start pings to server if enabled
 	if !config.NoPing {
 		go ping.PingProcess()
 	}
 
+	// Start sparse latency flow to server if enabled
+	if config.LatencyFlow != "" {
+		go flow.Process()
+	}
+
+	// Wait for baseline (unloaded) state data
+	if config.PreIdle > 0 {
+		meta.PhaseStart(meta.PhaseBaseline)
+		time.Sleep(time.Duration(config.PreIdle) * time.Second)
+		meta.PhaseEnd(meta.PhaseBaseline)
+	}
+
+	// Start speedtest client
+	go network.SpeedtestProcess()
+
 	// Wait until speedtest is complete
 	<-channel.SpeedtestDone
 
@@ -62,16 +192,76 @@ func main() {
 	if !config.NoPing {
 		<-channel.PingDone
 	}
+	if config.LatencyFlow != "" {
+		<-channel.FlowDone
+	}
 	<-channel.CaptureDone
 
 	// Collect metadata
 	meta.Collect()
 
+	// Fingerprint bottleneck aqm
+	if !config.NoPing {
+		meta.FingerprintAqm(network.CapFile)
+	}
+
+	// Print latency under load report
+	if !config.Quiet {
+		meta.PrintSummary()
+	}
+
 	// Write metadata
 	meta.Write()
 
+	// Write flat exports
+	if len(config.Formats) > 0 {
+		meta.WriteExports()
+	}
+
+	// Write metrics for textfile collector
+	if config.MetricsFile != "" {
+		if err := metrics.WriteFile(config.MetricsFile, meta.MetaD); err != nil {
+			log.Println("[metrics] error writing metrics file:", err)
+		} else {
+			log.Println("[metrics] metrics written to:", config.MetricsFile)
+		}
+	}
+
 	// Write archive
 	if config.ShouldArchive() {
 		archive.Write()
 	}
+
+	// Push samples to timeseries stores
+	if config.InfluxURL != "" || config.OtlpURL != "" {
+		telemetry.Process()
+	}
+
+	// Upload results
+	if config.UploadURL != "" {
+		upload.Process()
+	}
+}
+
+func daemonOptions() daemon.Options {
+	opts := daemon.Options{
+		Args:           config.RunArgs(),
+		Env:            config.RunEnv(),
+		OutDir:         config.OutPath,
+		Interval:       config.Interval,
+		RandomInterval: config.RandomInterval,
+		Jitter:         config.Jitter,
+		RetainRuns:     config.RetainRuns,
+		RetainDays:     config.RetainDays,
+		StatusFile:     config.StatusFile,
+		LockFile:       config.LockFile,
+		Version:        config.VERSION,
+	}
+
+	if config.Schedule != "" {
+		// validated by config
+		opts.Schedule, _ = daemon.ParseCron(config.Schedule)
+	}
+
+	return opts
 }
@@ -1723,6 +1723,8 @@ def parse_args():
                              'supplied multiple times')
     parser.add_argument('--server-ip',
                         help='Manually enter the destination IP of the server, using the format IP:Port as 127.0.0.1:8080')
+    parser.add_argument('--phases', action='store_true', default=False,
+                        help='Print JSON test phase events to stderr')
     parser.add_argument('--exclude', type=PARSER_TYPE_INT, action='append',
                         help='Exclude a server from selection. Can be '
                              'supplied multiple times')
@@ -1792,6 +1794,18 @@ def printer(string, quiet=False, debug=False, error=False, **kwargs):
         print_(out, **kwargs)
 
 
+def phase_event(enabled, phase, event):
+    """Print a test phase event to stderr as a JSON line"""
+
+    if not enabled:
+        return
+
+    printer(json.dumps({'type': 'phase', 'phase': phase, 'event': event,
+                        'timestamp': timeit.time.time()}),
+            error=True)
+    sys.stderr.flush()
+
+
 def shell():
     """Run the full speedtest.net test"""
 
@@ -1915,10 +1929,12 @@ def shell():
     if args.download:
         printer('Testing download speed', quiet,
                 end=('', '\n')[bool(debug)])
+        phase_event(args.phases, 'download', 'start')
         speedtest.download(
             callback=callback,
             threads=(None, 1)[args.single]
         )
+        phase_event(args.phases, 'download', 'end')
         printer('Download: %0.2f M%s/s' %
                 ((results.download / 1000.0 / 1000.0) / args.units[1],
                  args.units[0]),
@@ -1929,11 +1945,13 @@ def shell():
     if args.upload:
         printer('Testing upload speed', quiet,
                 end=('', '\n')[bool(debug)])
+        phase_event(args.phases, 'upload', 'start')
         speedtest.upload(
             callback=callback,
             pre_allocate=args.pre_allocate,
             threads=(None, 1)[args.single]
         )
+        phase_event(args.phases, 'upload', 'end')
         printer('Upload: %0.2f M%s/s' %
                 ((results.upload / 1000.0 / 1000.0) / args.units[1],
                  args.units[0]),